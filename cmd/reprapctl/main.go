@@ -43,11 +43,6 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	// err := tty.ConfigureTty("/dev/ved")
-	// if err != nil {
-	//	log.Fatal(err)
-	// }
-
 	a := app.NewWithID("reprapctl")
 	w := reprapctl.CreateMainWindow(a, logger, fanOutSink)
 	w.ShowAndRun()
@@ -0,0 +1,399 @@
+package reprapctl
+
+import (
+	"errors"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"image/color"
+	"io"
+	"log/slog"
+	"reprapctl/internal/pkg/printer"
+	"reprapctl/internal/pkg/tempchart"
+	"reprapctl/internal/pkg/tty"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// simulatorPort is the port selector option for the built-in printer simulator.
+	simulatorPort = "Simulated printer"
+
+	// autoBaudRate is the baud rate option which detects the baud rate of the printer.
+	autoBaudRate = "Auto"
+
+	temperatureInterval = 2 * time.Second
+	baudRateTimeout     = 3 * time.Second
+)
+
+var (
+	statusColorDisconnected = color.NRGBA{R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff}
+	statusColorConnecting   = color.NRGBA{R: 0xfb, G: 0xc0, B: 0x2d, A: 0xff}
+	statusColorConnected    = color.NRGBA{R: 0x43, G: 0xa0, B: 0x47, A: 0xff}
+	statusColorError        = color.NRGBA{R: 0xe5, G: 0x39, B: 0x35, A: 0xff}
+)
+
+// connection manages the connection to a printer and the toolbar controlling it.
+// Traffic is shown in the terminal, temperatures in the chart and SD card files in the
+// file browser. The terminal keeps its log when the connection is lost and restored.
+type connection struct {
+	logger   *slog.Logger
+	window   fyne.Window
+	terminal *Terminal
+	chart    *tempchart.TempChart
+	files    *sdPanel
+
+	ports         *widget.Select
+	refreshPorts  *widget.Button
+	baudRate      *widget.SelectEntry
+	reconnect     *widget.Check
+	connectButton *widget.Button
+	statusLight   *canvas.Circle
+	statusText    *widget.Label
+	toolbar       fyne.CanvasObject
+
+	portPaths map[string]string
+	session   *printer.Session
+	printer   *printer.RepRap
+	monitor   *printer.TemperatureMonitor
+	lock      sync.Mutex
+}
+
+func newConnection(
+	logger *slog.Logger,
+	window fyne.Window,
+	terminal *Terminal,
+	chart *tempchart.TempChart,
+	files *sdPanel,
+) *connection {
+	c := &connection{
+		logger:      logger,
+		window:      window,
+		terminal:    terminal,
+		chart:       chart,
+		files:       files,
+		statusLight: canvas.NewCircle(statusColorDisconnected),
+		statusText:  widget.NewLabel("Disconnected"),
+	}
+
+	c.ports = widget.NewSelect(nil, nil)
+	c.refreshPorts = widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), c.discoverPorts)
+
+	baudRates := []string{autoBaudRate}
+	for _, rate := range tty.CommonBaudRates {
+		baudRates = append(baudRates, strconv.Itoa(rate))
+	}
+	c.baudRate = widget.NewSelectEntry(baudRates)
+	c.baudRate.SetText(strconv.Itoa(tty.CommonBaudRates[0]))
+
+	c.reconnect = widget.NewCheck("Reconnect", nil)
+	c.connectButton = widget.NewButtonWithIcon("Connect", theme.LoginIcon(), c.toggle)
+
+	c.toolbar = container.NewBorder(nil, nil,
+		container.NewHBox(
+			widget.NewLabel("Port"),
+			container.NewGridWrap(fyne.NewSize(260, c.ports.MinSize().Height), c.ports),
+			c.refreshPorts,
+			widget.NewLabel("Baud rate"),
+			container.NewGridWrap(fyne.NewSize(130, c.baudRate.MinSize().Height), c.baudRate),
+			c.reconnect,
+			c.connectButton,
+		),
+		nil,
+		container.NewHBox(
+			container.NewCenter(container.NewGridWrap(fyne.NewSize(12, 12), c.statusLight)),
+			c.statusText,
+		),
+	)
+
+	c.discoverPorts()
+	return c
+}
+
+// Printer returns the connected printer, or nil if there is no connection.
+func (c *connection) Printer() *printer.RepRap {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.printer
+}
+
+// Track shows the state of a job printed on the connected printer in the status.
+func (c *connection) Track(job *printer.Job) {
+	c.lock.Lock()
+	session := c.session
+	c.lock.Unlock()
+	if session != nil {
+		session.Track(job)
+	}
+}
+
+// EmergencyStop sends M112 to the printer ahead of all queued commands.
+func (c *connection) EmergencyStop() {
+	p := c.Printer()
+	if p == nil {
+		return
+	}
+	if err := p.EmergencyStop(); err != nil {
+		c.logger.Error("failed to send the emergency stop", "err", err)
+		return
+	}
+	c.logger.Warn("emergency stop sent, the printer must be reset")
+}
+
+// Close disconnects from the printer.
+func (c *connection) Close() {
+	c.disconnect()
+}
+
+func (c *connection) discoverPorts() {
+	c.portPaths = map[string]string{simulatorPort: ""}
+	options := []string{simulatorPort}
+
+	ports, err := tty.Discover()
+	if err != nil {
+		c.logger.Warn("failed to discover serial ports", "err", err)
+	}
+	for _, p := range ports {
+		c.portPaths[p.String()] = p.Path
+		options = append(options, p.String())
+	}
+
+	selected := c.ports.Selected
+	c.ports.Options = options
+	if _, ok := c.portPaths[selected]; !ok {
+		// prefer a real printer over the simulator
+		selected = options[len(options)-1]
+	}
+	c.ports.SetSelected(selected)
+	c.ports.Refresh()
+}
+
+func (c *connection) toggle() {
+	c.lock.Lock()
+	connected := c.session != nil
+	c.lock.Unlock()
+
+	if connected {
+		c.disconnect()
+	} else {
+		c.connect()
+	}
+}
+
+func (c *connection) connect() {
+	port := c.ports.Selected
+	path, ok := c.portPaths[port]
+	if !ok {
+		return
+	}
+
+	session := printer.NewSession(c.dialer(port, path, c.baudRate.Text), printer.SessionOptions{
+		Reconnect: c.reconnect.Checked,
+	})
+	c.lock.Lock()
+	if c.session != nil {
+		c.lock.Unlock()
+		return
+	}
+	c.session = session
+	c.lock.Unlock()
+
+	c.chart.Clear()
+	c.setControlsEnabled(false)
+	c.setConnectButton(true)
+	session.Subscribe(func(status printer.Status) {
+		c.update(session, status)
+	})
+	session.Connect()
+}
+
+// dialer returns a function that opens a port. An empty path selects the simulator.
+// The baud rate is detected once, when the port is opened for the first time.
+func (c *connection) dialer(port, path, baudRate string) printer.Dialer {
+	if path == "" {
+		return func() (io.ReadWriteCloser, error) {
+			conn, err := printer.OpenFake(printer.FakeConfig{})
+			if err == nil {
+				c.logger.Info("connected", "port", port)
+			}
+			return conn, err
+		}
+	}
+
+	var rate int
+	return func() (io.ReadWriteCloser, error) {
+		if rate == 0 && baudRate == autoBaudRate {
+			c.setStatus(statusColorConnecting, "Detecting baud rate...")
+			detected, err := tty.DetectBaudRate(path, tty.Config{}, nil, baudRateTimeout)
+			if err != nil {
+				return nil, err
+			}
+			rate = detected
+		} else if rate == 0 {
+			var err error
+			if rate, err = strconv.Atoi(baudRate); err != nil || rate <= 0 {
+				rate = 0
+				return nil, fmt.Errorf("invalid baud rate %q", baudRate)
+			}
+		}
+
+		conn, err := tty.Open(path, tty.Config{BaudRate: rate})
+		if err != nil {
+			return nil, err
+		}
+		c.logger.Info("connected", "port", port, "baud", rate)
+		return conn, nil
+	}
+}
+
+// update follows the status of a session. Every new printer is connected to the
+// terminal, the chart and the file browser.
+func (c *connection) update(session *printer.Session, status printer.Status) {
+	c.lock.Lock()
+	if c.session != session {
+		c.lock.Unlock()
+		return
+	}
+	previous, monitor := c.printer, c.monitor
+	changed := status.Printer != previous
+	if changed {
+		c.printer, c.monitor = status.Printer, nil
+		if status.Printer != nil {
+			c.monitor = printer.NewTemperatureMonitor(status.Printer, temperatureInterval)
+			c.monitor.Subscribe(c.chart.Add)
+		}
+	}
+	c.lock.Unlock()
+
+	if changed {
+		if monitor != nil {
+			monitor.Close()
+		}
+		c.terminal.SetPrinter(status.Printer)
+		c.files.SetPrinter(status.Printer)
+	}
+	c.files.SetPrinting(status.State == printer.StatePrinting || status.State == printer.StatePaused)
+
+	switch status.State {
+	case printer.StateConnecting:
+		if status.Err != nil {
+			c.logger.Info("reconnecting")
+			c.setStatus(statusColorConnecting, "Reconnecting...")
+		} else {
+			c.setStatus(statusColorConnecting, "Connecting...")
+		}
+	case printer.StateHandshaking:
+		c.setStatus(statusColorConnecting, "Waiting for the printer...")
+	case printer.StateReady, printer.StatePrinting, printer.StatePaused:
+		caps, _ := status.Printer.Capabilities()
+		name := caps.FirmwareName
+		if name == "" {
+			name = "unknown firmware"
+		}
+		text := "Connected: " + name
+		if status.State != printer.StateReady {
+			text = fmt.Sprintf("%s, %v", text, status.State)
+		}
+		c.setStatus(statusColorConnected, text)
+	case printer.StateError:
+		c.fail(status)
+	}
+}
+
+// fail reports the error of a session. Unless the session reconnects, it is disconnected.
+func (c *connection) fail(status printer.Status) {
+	var halt *printer.HaltError
+	text := status.Err.Error()
+	switch {
+	case errors.As(status.Err, &halt):
+		c.logger.Error("printer halted", "message", halt.Message)
+		text = "Halted: " + halt.Message
+		c.showHalt(halt)
+	case status.Reconnecting:
+		c.logger.Error("connection lost, reconnecting", "err", status.Err)
+	default:
+		c.logger.Error("connection failed", "err", status.Err)
+	}
+
+	if status.Reconnecting {
+		c.setStatus(statusColorError, text)
+		return
+	}
+	// the session waits for the status to be delivered, disconnect asynchronously
+	go func() {
+		c.disconnect()
+		c.setStatus(statusColorError, text)
+	}()
+}
+
+// showHalt alerts the user that the firmware has halted.
+func (c *connection) showHalt(halt *printer.HaltError) {
+	title := canvas.NewText("The printer has halted", statusColorError)
+	title.TextSize = 2 * theme.TextSize()
+	title.TextStyle.Bold = true
+	message := widget.NewLabel(halt.Message)
+	message.TextStyle.Monospace = true
+	message.Wrapping = fyne.TextWrapWord
+	content := container.NewVBox(
+		container.NewHBox(widget.NewIcon(theme.ErrorIcon()), title),
+		message,
+		widget.NewLabel("Check the printer, then reset it and connect again."),
+	)
+	d := dialog.NewCustom("Printer halted", "OK", content, c.window)
+	d.Resize(fyne.NewSize(480, 0))
+	d.Show()
+	c.window.RequestFocus()
+}
+
+func (c *connection) disconnect() {
+	c.lock.Lock()
+	session := c.session
+	c.lock.Unlock()
+	if session == nil {
+		return
+	}
+
+	// the final status disconnects the printer from the terminal and the other views
+	session.Disconnect()
+	c.lock.Lock()
+	current := c.session == session
+	if current {
+		c.session = nil
+	}
+	c.lock.Unlock()
+	if !current {
+		return
+	}
+	c.logger.Info("disconnected")
+
+	c.setControlsEnabled(true)
+	c.setConnectButton(false)
+	c.setStatus(statusColorDisconnected, "Disconnected")
+}
+
+func (c *connection) setStatus(color color.Color, text string) {
+	c.statusLight.FillColor = color
+	c.statusLight.Refresh()
+	c.statusText.SetText(text)
+}
+
+func (c *connection) setControlsEnabled(enabled bool) {
+	for _, w := range []fyne.Disableable{c.ports, c.refreshPorts, c.baudRate, c.reconnect} {
+		setEnabled(w, enabled)
+	}
+}
+
+func (c *connection) setConnectButton(connected bool) {
+	if connected {
+		c.connectButton.SetText("Disconnect")
+		c.connectButton.SetIcon(theme.LogoutIcon())
+	} else {
+		c.connectButton.SetText("Connect")
+		c.connectButton.SetIcon(theme.LoginIcon())
+	}
+}
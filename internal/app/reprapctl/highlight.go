@@ -0,0 +1,117 @@
+package reprapctl
+
+import (
+	"log/slog"
+	"regexp"
+	"reprapctl/pkg/doc"
+	"slices"
+	"strings"
+)
+
+var (
+	// gcodeRe splits a sent line into the line number, the command code, the parameters,
+	// the comment and the checksum: N12 G1 X10 ; move*34
+	gcodeRe = regexp.MustCompile(`(?i)^(N-?\d+ *)?([GMT]\d+(?:\.\d+)?)?([^;*]*)(;[^*]*)?(\*\d+)?$`)
+
+	// temperatureRe matches temperatures in reports, e.g. T:210.00 /210.00 or B:60.5
+	temperatureRe = regexp.MustCompile(`\b[TBCPR]\d*:-?\d+(?:\.\d+)? *(?:/-?\d+(?:\.\d+)?)?`)
+
+	// levelTagRe matches the level of a record formatted by viewLogFormatter.
+	levelTagRe = regexp.MustCompile(`^[A-Z]+:`)
+)
+
+// highlightLine styles the lines of the terminal's log view according to their source:
+// G-code sent to the printer, responses received from it, and log records. Spans the line
+// already has are kept.
+func highlightLine(line doc.Line) []doc.Span {
+	spans := slices.Clone(line.Attrs.Spans)
+	switch line.Attrs.Source {
+	case doc.SourceSent:
+		return append(spans, highlightGCode(line.Text)...)
+	case doc.SourceReceived:
+		return append(spans, highlightResponse(line.Text)...)
+	case doc.SourceAppLog:
+		return append(spans, highlightRecord(line.Text, line.Attrs.Level)...)
+	}
+	return spans
+}
+
+// highlightGCode styles a sent line: the command code stands out, the line number, the
+// comment and the checksum are dimmed.
+func highlightGCode(text string) []doc.Span {
+	start := prefixLength(text)
+	m := gcodeRe.FindStringSubmatchIndex(text[start:])
+	if m == nil {
+		return nil
+	}
+
+	var spans []doc.Span
+	group := func(n int, color doc.Color, bold bool) {
+		if m[2*n] != -1 {
+			spans = append(spans, doc.Span{Start: start + m[2*n], End: start + m[2*n+1], Color: color, Bold: bold})
+		}
+	}
+	group(1, doc.ColorDimmed, false)
+	group(2, doc.ColorPrimary, true)
+	group(4, doc.ColorDimmed, false)
+	group(5, doc.ColorDimmed, false)
+	return spans
+}
+
+// highlightResponse styles a received line: errors are red, echo messages use the primary
+// color, "ok" is dimmed and temperatures stand out.
+func highlightResponse(text string) []doc.Span {
+	start := prefixLength(text)
+	response := text[start:]
+	switch {
+	case strings.HasPrefix(response, "Error:"):
+		return []doc.Span{
+			{Start: start, End: start + len("Error:"), Color: doc.ColorError, Bold: true},
+			{Start: start + len("Error:"), End: len(text), Color: doc.ColorError},
+		}
+	case strings.HasPrefix(response, "echo:"):
+		return []doc.Span{{Start: start, End: len(text), Color: doc.ColorPrimary}}
+	}
+
+	var spans []doc.Span
+	if response == "ok" || strings.HasPrefix(response, "ok ") {
+		spans = append(spans, doc.Span{Start: start, End: start + len("ok"), Color: doc.ColorDimmed})
+	}
+	for _, m := range temperatureRe.FindAllStringIndex(response, -1) {
+		spans = append(spans, doc.Span{Start: start + m[0], End: start + m[1], Color: doc.ColorWarning})
+	}
+	return spans
+}
+
+// highlightRecord styles a log record according to its level. Errors and warnings are
+// colored as a whole, debug records are dimmed, and the level tag is bold.
+func highlightRecord(text string, level slog.Level) []doc.Span {
+	var color doc.Color
+	switch {
+	case level >= slog.LevelError:
+		color = doc.ColorError
+	case level >= slog.LevelWarn:
+		color = doc.ColorWarning
+	case level < slog.LevelInfo:
+		color = doc.ColorDimmed
+	}
+
+	tag := len(levelTagRe.FindString(text))
+	var spans []doc.Span
+	if tag > 0 {
+		spans = append(spans, doc.Span{Start: 0, End: tag, Color: color, Bold: true})
+	}
+	if color != "" {
+		spans = append(spans, doc.Span{Start: tag, End: len(text), Color: color})
+	}
+	return spans
+}
+
+// prefixLength returns the length of the prefix that the terminal puts before the lines
+// it shows, see Terminal.
+func prefixLength(text string) int {
+	if len(text) >= 2 && strings.ContainsRune("<>!", rune(text[0])) && text[1] == ' ' {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package reprapctl
+
+import (
+	"log/slog"
+	"reprapctl/pkg/doc"
+	"slices"
+	"testing"
+)
+
+func TestHighlightLine(t *testing.T) {
+	const (
+		dim     = doc.ColorDimmed
+		primary = doc.ColorPrimary
+		red     = doc.ColorError
+		warning = doc.ColorWarning
+	)
+	sent := doc.Attrs{Source: doc.SourceSent}
+	received := doc.Attrs{Source: doc.SourceReceived}
+
+	tests := []struct {
+		text  string
+		attrs doc.Attrs
+		want  []doc.Span
+	}{
+		{
+			text:  "> N12 G1 X10 ; move*34",
+			attrs: sent,
+			want: []doc.Span{
+				{Start: 2, End: 6, Color: dim},
+				{Start: 6, End: 8, Color: primary, Bold: true},
+				{Start: 13, End: 19, Color: dim},
+				{Start: 19, End: 22, Color: dim},
+			},
+		},
+		{
+			text:  "> m105",
+			attrs: sent,
+			want:  []doc.Span{{Start: 2, End: 6, Color: primary, Bold: true}},
+		},
+		{
+			text:  "< Error:Printer halted. kill() called!",
+			attrs: received,
+			want: []doc.Span{
+				{Start: 2, End: 8, Color: red, Bold: true},
+				{Start: 8, End: 38, Color: red},
+			},
+		},
+		{
+			text:  "< echo:busy: processing",
+			attrs: received,
+			want:  []doc.Span{{Start: 2, End: 23, Color: primary}},
+		},
+		{
+			text:  "< ok T:210.00 /210.00 B:60.00 /0.00 @:0 B@:0",
+			attrs: received,
+			want: []doc.Span{
+				{Start: 2, End: 4, Color: dim},
+				{Start: 5, End: 21, Color: warning},
+				{Start: 22, End: 35, Color: warning},
+			},
+		},
+		{
+			text:  "< X:10.00 Y:0.00 Z:0.20 E:1.00 Count X:800 Y:0 Z:80",
+			attrs: received,
+		},
+		{
+			text:  "E: Connection failed",
+			attrs: doc.Attrs{Source: doc.SourceAppLog, Level: slog.LevelError},
+			want: []doc.Span{
+				{Start: 0, End: 2, Color: red, Bold: true},
+				{Start: 2, End: 20, Color: red},
+			},
+		},
+		{
+			text:  "I: Connected",
+			attrs: doc.Attrs{Source: doc.SourceAppLog, Level: slog.LevelInfo},
+			want:  []doc.Span{{Start: 0, End: 2, Bold: true}},
+		},
+		{
+			text:  "! Not connected",
+			attrs: doc.Attrs{Source: doc.SourceAppLog, Level: slog.LevelError},
+			want:  []doc.Span{{Start: 0, End: 15, Color: red}},
+		},
+		{
+			text:  "Error: not from the printer",
+			attrs: doc.Attrs{Spans: []doc.Span{{Start: 0, End: 5, Bold: true}}},
+			want:  []doc.Span{{Start: 0, End: 5, Bold: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		got := highlightLine(doc.Line{Text: tt.text, Attrs: tt.attrs})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("highlightLine(%q): want %+v, got %+v", tt.text, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package reprapctl
+
+import (
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reprapctl/internal/pkg/printer"
+	"reprapctl/pkg/gcode"
+	"sync"
+	"time"
+)
+
+// Preference keys of the scripts run by print jobs. Each is a list of commands.
+const (
+	pauseScriptPreference  = "job.pauseScript"
+	resumeScriptPreference = "job.resumeScript"
+	cancelScriptPreference = "job.cancelScript"
+)
+
+// defaultCancelScript turns off the heaters, the fan and the motors.
+var defaultCancelScript = []string{"M104 S0", "M140 S0", "M107", "M84"}
+
+// jobPanel prints G-code files from the host and shows the progress.
+type jobPanel struct {
+	logger      *slog.Logger
+	window      fyne.Window
+	connection  *connection
+	preferences fyne.Preferences
+
+	openButton   *widget.Button
+	pauseButton  *widget.Button
+	cancelButton *widget.Button
+	fileName     *widget.Label
+	progressBar  *widget.ProgressBar
+	progressText *widget.Label
+	content      fyne.CanvasObject
+
+	job        *printer.Job
+	lastState  printer.JobState
+	lastUpdate time.Time
+	lock       sync.Mutex
+}
+
+// progressUpdateInterval limits how often the progress is shown while printing.
+const progressUpdateInterval = 200 * time.Millisecond
+
+func newJobPanel(
+	logger *slog.Logger,
+	window fyne.Window,
+	connection *connection,
+	preferences fyne.Preferences,
+) *jobPanel {
+	j := &jobPanel{
+		logger:       logger,
+		window:       window,
+		connection:   connection,
+		preferences:  preferences,
+		fileName:     widget.NewLabel("No file"),
+		progressBar:  widget.NewProgressBar(),
+		progressText: widget.NewLabel(""),
+	}
+
+	j.openButton = widget.NewButtonWithIcon("Print...", theme.FolderOpenIcon(), j.chooseFile)
+	j.pauseButton = widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), j.togglePause)
+	j.cancelButton = widget.NewButtonWithIcon("Cancel", theme.MediaStopIcon(), j.cancel)
+	j.pauseButton.Disable()
+	j.cancelButton.Disable()
+
+	j.content = container.NewBorder(nil, nil,
+		container.NewHBox(j.openButton, j.fileName),
+		container.NewHBox(j.progressText, j.pauseButton, j.cancelButton),
+		j.progressBar,
+	)
+	return j
+}
+
+// Close cancels the current job.
+func (j *jobPanel) Close() {
+	j.abort()
+}
+
+// abort cancels the current job without asking.
+func (j *jobPanel) abort() {
+	j.lock.Lock()
+	job := j.job
+	j.lock.Unlock()
+	if job != nil {
+		_ = job.Cancel()
+	}
+}
+
+func (j *jobPanel) chooseFile() {
+	d := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, j.window)
+			return
+		}
+		if r == nil {
+			return
+		}
+		_ = r.Close()
+		j.start(r.URI().Path())
+	}, j.window)
+	d.SetFilter(storage.NewExtensionFileFilter([]string{".gcode", ".gco", ".g"}))
+	d.Show()
+}
+
+func (j *jobPanel) start(path string) {
+	p := j.connection.Printer()
+	if p == nil {
+		dialog.ShowInformation("Print", "Connect to a printer first.", j.window)
+		return
+	}
+
+	job, err := printer.OpenJob(p, path, printer.JobOptions{
+		PauseScript:  j.preferences.StringList(pauseScriptPreference),
+		ResumeScript: j.preferences.StringList(resumeScriptPreference),
+		CancelScript: j.preferences.StringListWithFallback(cancelScriptPreference, defaultCancelScript),
+	})
+	if err != nil {
+		j.logger.Error("failed to open the print job", "path", path, "err", err)
+		dialog.ShowError(err, j.window)
+		return
+	}
+
+	j.lock.Lock()
+	if j.job != nil && !j.job.Progress().State.Ended() {
+		j.lock.Unlock()
+		dialog.ShowInformation("Print", "Another job is in progress.", j.window)
+		return
+	}
+	j.job = job
+	j.lock.Unlock()
+
+	j.fileName.SetText(filepath.Base(path))
+	go j.analyze(path)
+	job.Subscribe(j.update)
+	j.connection.Track(job)
+	if err := job.Start(); err != nil {
+		dialog.ShowError(err, j.window)
+		return
+	}
+	j.logger.Info("print started", "path", path)
+
+	go func() {
+		<-job.Done()
+		if err := job.Err(); err != nil {
+			j.logger.Warn("print stopped", "path", path, "err", err)
+		} else {
+			j.logger.Info("print finished", "path", path, "time", job.Progress().Elapsed.Round(time.Second))
+		}
+	}()
+}
+
+// analyze shows the estimated print time and filament usage next to the file name.
+func (j *jobPanel) analyze(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		j.logger.Warn("failed to analyze the print job", "path", path, "err", err)
+		return
+	}
+	defer f.Close()
+
+	a, err := gcode.Analyze(f, gcode.AnalyzerOptions{})
+	if err != nil {
+		j.logger.Warn("failed to analyze the print job", "path", path, "err", err)
+		return
+	}
+	if len(a.Errors) > 0 {
+		j.logger.Warn("skipped lines of the print job", "path", path, "count", len(a.Errors), "first", a.Errors[0])
+	}
+	filament := a.Filament()
+	j.logger.Info("print job analyzed",
+		"path", path,
+		"time", a.Time.Round(time.Second),
+		"filament", fmt.Sprintf("%.2f m", filament.Length/1000),
+		"weight", fmt.Sprintf("%.1f g", filament.Weight),
+		"layers", len(a.Layers),
+		"size", fmt.Sprintf("%.1f x %.1f x %.1f mm", a.Bounds.Size().X, a.Bounds.Size().Y, a.Bounds.Size().Z),
+	)
+	j.fileName.SetText(fmt.Sprintf("%s (%v, %.2f m)", filepath.Base(path), a.Time.Round(time.Second), filament.Length/1000))
+}
+
+func (j *jobPanel) togglePause() {
+	j.lock.Lock()
+	job := j.job
+	j.lock.Unlock()
+	if job == nil {
+		return
+	}
+
+	var err error
+	if job.Progress().State == printer.JobPaused {
+		err = job.Resume()
+	} else {
+		err = job.Pause()
+	}
+	if err != nil {
+		j.logger.Warn("failed to pause or resume the print", "err", err)
+	}
+}
+
+func (j *jobPanel) cancel() {
+	j.lock.Lock()
+	job := j.job
+	j.lock.Unlock()
+	if job == nil {
+		return
+	}
+
+	dialog.ShowConfirm("Cancel print", "Do you want to cancel the print?", func(ok bool) {
+		if ok {
+			if err := job.Cancel(); err != nil {
+				j.logger.Warn("failed to cancel the print", "err", err)
+			}
+		}
+	}, j.window)
+}
+
+func (j *jobPanel) update(p printer.JobProgress) {
+	j.lock.Lock()
+	skip := p.State == j.lastState && time.Since(j.lastUpdate) < progressUpdateInterval
+	if !skip {
+		j.lastState, j.lastUpdate = p.State, time.Now()
+	}
+	j.lock.Unlock()
+	if skip {
+		return
+	}
+
+	j.progressBar.SetValue(p.Fraction())
+
+	text := fmt.Sprintf("%v, line %d/%d", p.State, p.Lines, p.TotalLines)
+	if p.Remaining > 0 {
+		text += fmt.Sprintf(", %v left", p.Remaining.Round(time.Second))
+	}
+	j.progressText.SetText(text)
+
+	switch p.State {
+	case printer.JobPaused:
+		j.pauseButton.SetText("Resume")
+		j.pauseButton.SetIcon(theme.MediaPlayIcon())
+	default:
+		j.pauseButton.SetText("Pause")
+		j.pauseButton.SetIcon(theme.MediaPauseIcon())
+	}
+
+	if p.State.Ended() {
+		j.openButton.Enable()
+		j.pauseButton.Disable()
+		j.cancelButton.Disable()
+	} else {
+		j.openButton.Disable()
+		j.pauseButton.Enable()
+		j.cancelButton.Enable()
+	}
+}
@@ -0,0 +1,428 @@
+package reprapctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reprapctl/internal/pkg/printer"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sdStatusInterval is how often the printer reports the progress of SD printing.
+const sdStatusInterval = 2 * time.Second
+
+// sdPanel browses the files on the printer's SD card, uploads files and prints them.
+type sdPanel struct {
+	logger *slog.Logger
+	window fyne.Window
+
+	list          *widget.List
+	refreshButton *widget.Button
+	uploadButton  *widget.Button
+	printButton   *widget.Button
+	pauseButton   *widget.Button
+	deleteButton  *widget.Button
+	progressBar   *widget.ProgressBar
+	statusText    *widget.Label
+	content       fyne.CanvasObject
+
+	card     *printer.SDCard
+	files    []printer.SDFile
+	selected int
+	status   printer.SDStatus
+	busy     bool
+	printing bool
+	lock     sync.Mutex
+}
+
+func newSDPanel(logger *slog.Logger, window fyne.Window) *sdPanel {
+	s := &sdPanel{
+		logger:      logger,
+		window:      window,
+		selected:    -1,
+		progressBar: widget.NewProgressBar(),
+		statusText:  widget.NewLabel("Not connected"),
+	}
+
+	s.list = widget.NewList(s.fileCount, s.createItem, s.updateItem)
+	s.list.OnSelected = func(id widget.ListItemID) {
+		s.lock.Lock()
+		s.selected = id
+		s.lock.Unlock()
+		s.updateControls()
+	}
+	s.list.OnUnselected = func(widget.ListItemID) {
+		s.lock.Lock()
+		s.selected = -1
+		s.lock.Unlock()
+		s.updateControls()
+	}
+
+	s.refreshButton = widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), s.refresh)
+	s.uploadButton = widget.NewButtonWithIcon("Upload...", theme.UploadIcon(), s.chooseUpload)
+	s.printButton = widget.NewButtonWithIcon("Print", theme.MediaPlayIcon(), s.print)
+	s.pauseButton = widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), s.togglePause)
+	s.deleteButton = widget.NewButtonWithIcon("", theme.DeleteIcon(), s.delete)
+
+	s.content = container.NewBorder(
+		container.NewHBox(s.refreshButton, s.uploadButton, s.printButton, s.pauseButton, s.deleteButton),
+		container.NewVBox(s.progressBar, s.statusText),
+		nil, nil,
+		s.list,
+	)
+	s.updateControls()
+	return s
+}
+
+// SetPrinter sets the printer whose SD card is shown. The printer can be nil.
+func (s *sdPanel) SetPrinter(p *printer.RepRap) {
+	s.lock.Lock()
+	previous := s.card
+	s.card = nil
+	s.files, s.selected, s.status = nil, -1, printer.SDStatus{}
+	if p != nil {
+		s.card = printer.NewSDCard(p, sdStatusInterval)
+		s.card.Subscribe(s.updateStatus)
+	}
+	card := s.card
+	s.lock.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	s.list.UnselectAll()
+	s.list.Refresh()
+	s.progressBar.SetValue(0)
+	s.updateControls()
+	if p == nil {
+		s.statusText.SetText("Not connected")
+		return
+	}
+
+	s.statusText.SetText("")
+	go func() {
+		select {
+		case <-p.Identified():
+		case <-p.Done():
+			return
+		}
+		if caps, _ := p.Capabilities(); !caps.Has(printer.CapSDCard) {
+			s.statusText.SetText("The printer has no SD card support")
+			return
+		}
+		s.lock.Lock()
+		current := s.card == card
+		s.lock.Unlock()
+		if current {
+			s.refresh()
+		}
+	}()
+}
+
+// SetPrinting tells whether the printer is printing a job from the host. Files are not
+// uploaded meanwhile, because the firmware would write the job's commands to the file.
+func (s *sdPanel) SetPrinting(printing bool) {
+	s.lock.Lock()
+	s.printing = printing
+	s.lock.Unlock()
+	s.updateControls()
+}
+
+// Close stops tracking the SD card.
+func (s *sdPanel) Close() {
+	s.lock.Lock()
+	card := s.card
+	s.card = nil
+	s.lock.Unlock()
+	if card != nil {
+		card.Close()
+	}
+}
+
+func (s *sdPanel) fileCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.files)
+}
+
+func (s *sdPanel) createItem() fyne.CanvasObject {
+	size := widget.NewLabel("")
+	size.Alignment = fyne.TextAlignTrailing
+	return container.NewBorder(nil, nil, nil, size, widget.NewLabel(""))
+}
+
+func (s *sdPanel) updateItem(id widget.ListItemID, item fyne.CanvasObject) {
+	s.lock.Lock()
+	if id >= len(s.files) {
+		s.lock.Unlock()
+		return
+	}
+	f := s.files[id]
+	s.lock.Unlock()
+
+	c := item.(*fyne.Container)
+	c.Objects[0].(*widget.Label).SetText(f.DisplayName())
+	size := ""
+	if f.Size >= 0 {
+		size = formatSize(f.Size)
+	}
+	c.Objects[1].(*widget.Label).SetText(size)
+}
+
+// run calls f with the SD card in a goroutine, disabling the controls meanwhile.
+// Errors are logged and shown.
+func (s *sdPanel) run(action string, f func(card *printer.SDCard) error) {
+	s.lock.Lock()
+	card := s.card
+	if card == nil || s.busy {
+		s.lock.Unlock()
+		return
+	}
+	s.busy = true
+	s.lock.Unlock()
+	s.updateControls()
+
+	go func() {
+		err := f(card)
+
+		s.lock.Lock()
+		s.busy = false
+		s.lock.Unlock()
+		s.updateControls()
+
+		if err != nil {
+			s.logger.Error("SD card: failed to "+action, "err", err)
+			dialog.ShowError(err, s.window)
+		}
+	}()
+}
+
+func (s *sdPanel) refresh() {
+	s.run("list files", s.reload)
+}
+
+// reload lists the files on the card.
+func (s *sdPanel) reload(card *printer.SDCard) error {
+	files, err := card.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.files, s.selected = files, -1
+	s.lock.Unlock()
+	s.list.UnselectAll()
+	s.list.Refresh()
+	return nil
+}
+
+// selectedFile returns the selected file.
+func (s *sdPanel) selectedFile() (printer.SDFile, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.selected < 0 || s.selected >= len(s.files) {
+		return printer.SDFile{}, false
+	}
+	return s.files[s.selected], true
+}
+
+func (s *sdPanel) print() {
+	f, ok := s.selectedFile()
+	if !ok {
+		return
+	}
+	s.run("print", func(card *printer.SDCard) error {
+		if err := card.Print(context.Background(), f.Name); err != nil {
+			return err
+		}
+		s.logger.Info("SD card: print started", "file", f.DisplayName())
+		return nil
+	})
+}
+
+func (s *sdPanel) togglePause() {
+	s.lock.Lock()
+	paused := s.status.Paused
+	s.lock.Unlock()
+
+	s.run("pause or resume", func(card *printer.SDCard) error {
+		if paused {
+			return card.Start(context.Background())
+		}
+		return card.Pause(context.Background())
+	})
+}
+
+func (s *sdPanel) delete() {
+	f, ok := s.selectedFile()
+	if !ok {
+		return
+	}
+	dialog.ShowConfirm("Delete file", fmt.Sprintf("Do you want to delete %s?", f.DisplayName()), func(ok bool) {
+		if !ok {
+			return
+		}
+		s.run("delete", func(card *printer.SDCard) error {
+			if err := card.Delete(context.Background(), f.Name); err != nil {
+				return err
+			}
+			s.logger.Info("SD card: file deleted", "file", f.DisplayName())
+			return s.reload(card)
+		})
+	}, s.window)
+}
+
+func (s *sdPanel) chooseUpload() {
+	d := dialog.NewFileOpen(func(r fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, s.window)
+			return
+		}
+		if r == nil {
+			return
+		}
+		_ = r.Close()
+		path := r.URI().Path()
+
+		name := widget.NewEntry()
+		name.SetText(sdShortName(filepath.Base(path)))
+		dialog.ShowForm("Upload to SD card", "Upload", "Cancel",
+			[]*widget.FormItem{widget.NewFormItem("Name", name)},
+			func(ok bool) {
+				if ok && name.Text != "" {
+					s.upload(path, name.Text)
+				}
+			}, s.window)
+	}, s.window)
+	d.SetFilter(storage.NewExtensionFileFilter([]string{".gcode", ".gco", ".g"}))
+	d.Show()
+}
+
+func (s *sdPanel) upload(path, name string) {
+	s.run("upload", func(card *printer.SDCard) error {
+		s.lock.Lock()
+		printing := s.printing
+		s.lock.Unlock()
+		if printing {
+			return errors.New("cannot upload while printing a job")
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		s.statusText.SetText("Uploading " + name + "...")
+		var lastUpdate time.Time
+		err = card.Upload(context.Background(), name, f, func(bytes int64) {
+			if time.Since(lastUpdate) >= progressUpdateInterval {
+				lastUpdate = time.Now()
+				s.progressBar.SetValue(float64(bytes) / float64(max(info.Size(), 1)))
+			}
+		})
+		s.progressBar.SetValue(0)
+		s.statusText.SetText("")
+		if err != nil {
+			return err
+		}
+
+		s.logger.Info("SD card: file uploaded", "path", path, "name", name)
+		return s.reload(card)
+	})
+}
+
+func (s *sdPanel) updateStatus(status printer.SDStatus) {
+	s.lock.Lock()
+	s.status = status
+	s.lock.Unlock()
+
+	s.progressBar.SetValue(status.Fraction())
+	switch {
+	case status.Printing:
+		s.statusText.SetText(fmt.Sprintf("Printing, %s of %s", formatSize(status.Bytes), formatSize(status.TotalBytes)))
+	case status.Paused:
+		s.statusText.SetText(fmt.Sprintf("Paused, %s of %s", formatSize(status.Bytes), formatSize(status.TotalBytes)))
+	case status.Finished:
+		s.statusText.SetText("Finished")
+	default:
+		s.statusText.SetText("")
+	}
+	s.updateControls()
+}
+
+func (s *sdPanel) updateControls() {
+	s.lock.Lock()
+	connected := s.card != nil && !s.busy
+	selected := s.selected >= 0 && s.selected < len(s.files)
+	status := s.status
+	printing := s.printing
+	s.lock.Unlock()
+
+	active := status.Printing || status.Paused
+	setEnabled(s.refreshButton, connected)
+	setEnabled(s.uploadButton, connected && !active && !printing)
+	setEnabled(s.printButton, connected && selected && !active)
+	setEnabled(s.deleteButton, connected && selected && !active)
+	setEnabled(s.pauseButton, connected && active)
+	if status.Paused {
+		s.pauseButton.SetText("Resume")
+		s.pauseButton.SetIcon(theme.MediaPlayIcon())
+	} else {
+		s.pauseButton.SetText("Pause")
+		s.pauseButton.SetIcon(theme.MediaPauseIcon())
+	}
+}
+
+func setEnabled(w fyne.Disableable, enabled bool) {
+	if enabled {
+		w.Enable()
+	} else {
+		w.Disable()
+	}
+}
+
+// sdShortName suggests a DOS 8.3 name for a file, which is what Marlin accepts in M28.
+func sdShortName(name string) string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	var b strings.Builder
+	for _, r := range strings.ToUpper(base) {
+		if b.Len() == 8 {
+			break
+		}
+		if 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString("UPLOAD")
+	}
+	return b.String() + ".GCO"
+}
+
+// formatSize formats a file size in bytes, kB or MB.
+func formatSize(bytes int64) string {
+	switch {
+	case bytes < 1000:
+		return fmt.Sprintf("%d B", bytes)
+	case bytes < 1000*1000:
+		return fmt.Sprintf("%.1f kB", float64(bytes)/1000)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/1000/1000)
+	}
+}
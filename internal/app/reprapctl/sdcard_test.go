@@ -0,0 +1,34 @@
+package reprapctl
+
+import "testing"
+
+func TestSDShortName(t *testing.T) {
+	tests := map[string]string{
+		"benchy.gcode":              "BENCHY.GCO",
+		"Benchy 0.2mm PLA.gcode":    "BENCHY02.GCO",
+		"part_v2.gco":               "PART_V2.GCO",
+		"überteil.g":                "BERTEIL.GCO",
+		"...gcode":                  "UPLOAD.GCO",
+		"a-very-long-file-name.gco": "A-VERY-L.GCO",
+	}
+	for name, want := range tests {
+		if got := sdShortName(name); got != want {
+			t.Errorf("sdShortName(%q): want %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:         "0 B",
+		999:       "999 B",
+		1000:      "1.0 kB",
+		123456:    "123.5 kB",
+		2_500_000: "2.5 MB",
+	}
+	for size, want := range tests {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d): want %q, got %q", size, want, got)
+		}
+	}
+}
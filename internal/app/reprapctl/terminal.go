@@ -3,29 +3,357 @@ package reprapctl
 import (
 	"fmt"
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+	"log/slog"
 	"reprapctl/internal/pkg/logview"
+	"reprapctl/internal/pkg/printer"
+	"reprapctl/pkg/doc"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-func NewTerminal() fyne.CanvasObject {
-	b := strings.Builder{}
-	for i := 0; i < 35; i++ {
-		b.WriteString(fmt.Sprintf("Line %v\n", i))
+const (
+	// historyPreference is the preference key under which the command history is stored.
+	historyPreference = "terminal.history"
+
+	historySize = 200
+)
+
+var _ fyne.Widget = (*Terminal)(nil)
+
+// Terminal shows the traffic of a printer in a log view and lets the user send commands
+// typed into a command line beneath it. Sent lines are prefixed with "> ", received lines
+// with "< " and local messages with "! ".
+//
+// The command line keeps a history browsable with the up and down keys, which persists
+// in preferences. Tab completes G-code and M-code numbers.
+type Terminal struct {
+	widget.BaseWidget
+
+	logView     *logview.LogView
+	entry       *commandEntry
+	history     *commandHistory
+	preferences fyne.Preferences
+
+	printer     *printer.RepRap
+	unsubscribe func()
+	lock        sync.Mutex
+}
+
+func NewTerminal(logView *logview.LogView, preferences fyne.Preferences) *Terminal {
+	t := &Terminal{
+		logView:     logView,
+		history:     newCommandHistory(preferences.StringList(historyPreference), historySize),
+		preferences: preferences,
+	}
+
+	t.entry = newCommandEntry()
+	t.entry.SetPlaceHolder("G-code command, e.g. M105")
+	t.entry.OnSubmitted = t.submit
+	t.entry.onHistory = func(previous bool) {
+		var s string
+		var ok bool
+		if previous {
+			s, ok = t.history.Previous(t.entry.Text)
+		} else {
+			s, ok = t.history.Next()
+		}
+		if ok {
+			t.entry.setTextAtEnd(s)
+		}
+	}
+
+	t.ExtendBaseWidget(t)
+	return t
+}
+
+func (t *Terminal) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(container.NewBorder(nil, t.entry, nil, nil, t.logView))
+}
+
+// SetPrinter connects the terminal to a printer. Nil disconnects the terminal.
+func (t *Terminal) SetPrinter(p *printer.RepRap) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.unsubscribe != nil {
+		t.unsubscribe()
+		t.unsubscribe = nil
+	}
+	t.printer = p
+	if p != nil {
+		t.unsubscribe = p.Subscribe(t.handleEvent)
+	}
+}
+
+func (t *Terminal) submit(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	t.history.Add(text)
+	t.preferences.SetStringList(historyPreference, t.history.Entries())
+	t.entry.SetText("")
+
+	t.lock.Lock()
+	p := t.printer
+	t.lock.Unlock()
+
+	if p == nil {
+		t.addMessage("Not connected")
+		return
+	}
+	if err := p.Send(printer.NewCommand(text, nil)); err != nil {
+		t.addMessage(err.Error())
+	}
+}
+
+func (t *Terminal) handleEvent(e printer.Event) {
+	switch e := e.(type) {
+	case printer.LineSent:
+		t.addLine("> "+e.Line, doc.Attrs{Source: doc.SourceSent})
+	case printer.LineReceived:
+		t.addLine("< "+e.Line, doc.Attrs{Source: doc.SourceReceived})
+	}
+}
+
+// addMessage adds a local error message.
+func (t *Terminal) addMessage(message string) {
+	t.addLine("! "+message, doc.Attrs{Source: doc.SourceAppLog, Level: slog.LevelError})
+}
+
+func (t *Terminal) addLine(line string, attrs doc.Attrs) {
+	attrs.Time = time.Now()
+	t.logView.AddLines(doc.Line{Text: line, Attrs: attrs})
+	t.logView.Refresh()
+}
+
+var _ fyne.Tabbable = (*commandEntry)(nil)
+
+// commandEntry is a single line entry which browses history with up and down keys and
+// completes commands with the tab key.
+type commandEntry struct {
+	widget.Entry
+	onHistory func(previous bool)
+}
+
+func newCommandEntry() *commandEntry {
+	e := &commandEntry{}
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+func (e *commandEntry) AcceptsTab() bool {
+	return true
+}
+
+func (e *commandEntry) TypedKey(key *fyne.KeyEvent) {
+	switch key.Name {
+	case fyne.KeyUp, fyne.KeyDown:
+		if e.onHistory != nil {
+			e.onHistory(key.Name == fyne.KeyUp)
+		}
+	case fyne.KeyTab:
+		e.complete()
+	default:
+		e.Entry.TypedKey(key)
 	}
+}
 
-	//return container.NewScroll(widget.NewLabel(b.String()))
+func (e *commandEntry) complete() {
+	text, candidates := completeCommand(e.Text)
+	if text != e.Text {
+		e.setTextAtEnd(text)
+		return
+	}
+	if len(candidates) < 2 {
+		return
+	}
 
-	//sb := binding.NewString()
-	//sb.Set(b.String())
-	//
-	//e := widget.NewEntry()
-	//e.MultiLine = true
-	//e.Bind(sb)
-	//return e
+	items := make([]*fyne.MenuItem, len(candidates))
+	for i, c := range candidates {
+		code := c.code
+		items[i] = fyne.NewMenuItem(fmt.Sprintf("%s  %s", c.code, c.description), func() {
+			e.setTextAtEnd(code + " ")
+		})
+	}
+	cv := fyne.CurrentApp().Driver().CanvasForObject(e)
+	if cv == nil {
+		return
+	}
+	popup := widget.NewPopUpMenu(fyne.NewMenu("", items...), cv)
+	pos := fyne.CurrentApp().Driver().AbsolutePositionForObject(e)
+	popup.ShowAtPosition(pos.Add(fyne.NewPos(0, -popup.MinSize().Height)))
+}
 
-	//richText := widget.NewRichTextWithText(b.String())
-	//textGrid := widget.NewTextGridFromString(b.String())
-	//return container.NewScroll(textGrid)
+func (e *commandEntry) setTextAtEnd(text string) {
+	e.SetText(text)
+	e.CursorColumn = len([]rune(text))
+	e.Refresh()
+}
 
-	return logview.New()
+// commandHistory is a list of previously submitted commands with a cursor for browsing.
+// The text being edited before browsing started is kept as a draft.
+type commandHistory struct {
+	entries []string
+	size    int
+	pos     int
+	draft   string
+	lock    sync.Mutex
+}
+
+func newCommandHistory(entries []string, size int) *commandHistory {
+	h := &commandHistory{size: size}
+	for _, e := range entries {
+		h.Add(e)
+	}
+	return h
+}
+
+// Add appends a command and resets the cursor. Repeated commands are stored once.
+func (h *commandHistory) Add(command string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if n := len(h.entries); n == 0 || h.entries[n-1] != command {
+		h.entries = append(h.entries, command)
+		if n := len(h.entries) - h.size; n > 0 {
+			h.entries = slices.Delete(h.entries, 0, n)
+		}
+	}
+	h.pos = len(h.entries)
+	h.draft = ""
+}
+
+// Previous moves the cursor to the previous command. Current is the text being edited,
+// which is saved as the draft when browsing starts.
+func (h *commandHistory) Previous(current string) (string, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.pos == 0 {
+		return "", false
+	}
+	if h.pos == len(h.entries) {
+		h.draft = current
+	}
+	h.pos--
+	return h.entries[h.pos], true
+}
+
+// Next moves the cursor to the next command, or back to the draft after the last one.
+func (h *commandHistory) Next() (string, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.pos == len(h.entries) {
+		return "", false
+	}
+	h.pos++
+	if h.pos == len(h.entries) {
+		return h.draft, true
+	}
+	return h.entries[h.pos], true
+}
+
+// Entries returns all commands, oldest first.
+func (h *commandHistory) Entries() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return slices.Clone(h.entries)
+}
+
+type gcodeInfo struct {
+	code        string
+	description string
+}
+
+// commonGCodes lists the commands offered for completion, sorted by code.
+var commonGCodes = []gcodeInfo{
+	{"G0", "Rapid move"},
+	{"G1", "Linear move"},
+	{"G4", "Dwell"},
+	{"G10", "Retract"},
+	{"G11", "Recover"},
+	{"G20", "Inch units"},
+	{"G21", "Millimeter units"},
+	{"G28", "Auto home"},
+	{"G29", "Bed leveling"},
+	{"G90", "Absolute positioning"},
+	{"G91", "Relative positioning"},
+	{"G92", "Set position"},
+	{"M17", "Enable steppers"},
+	{"M18", "Disable steppers"},
+	{"M20", "List SD card"},
+	{"M21", "Init SD card"},
+	{"M22", "Release SD card"},
+	{"M23", "Select SD file"},
+	{"M24", "Start or resume SD print"},
+	{"M25", "Pause SD print"},
+	{"M27", "Report SD print status"},
+	{"M30", "Delete SD file"},
+	{"M82", "E absolute"},
+	{"M83", "E relative"},
+	{"M84", "Disable steppers"},
+	{"M104", "Set hotend temperature"},
+	{"M105", "Report temperatures"},
+	{"M106", "Set fan speed"},
+	{"M107", "Fan off"},
+	{"M108", "Break and continue"},
+	{"M109", "Wait for hotend temperature"},
+	{"M110", "Set line number"},
+	{"M112", "Emergency stop"},
+	{"M114", "Get current position"},
+	{"M115", "Firmware info"},
+	{"M119", "Endstop states"},
+	{"M140", "Set bed temperature"},
+	{"M155", "Temperature auto-report"},
+	{"M190", "Wait for bed temperature"},
+	{"M220", "Set feedrate percentage"},
+	{"M221", "Set flow percentage"},
+	{"M400", "Finish moves"},
+	{"M410", "Quickstop"},
+	{"M500", "Save settings"},
+	{"M501", "Restore settings"},
+	{"M502", "Factory reset"},
+	{"M503", "Report settings"},
+}
+
+// completeCommand completes the command code being typed. It returns the completed text
+// and the codes matching the typed prefix. A unique match is completed with a trailing
+// space, multiple matches are completed to their longest common prefix. Only the first
+// word of the text is completed.
+func completeCommand(text string) (string, []gcodeInfo) {
+	if text == "" || strings.ContainsAny(text, " \t") {
+		return text, nil
+	}
+	prefix := strings.ToUpper(text)
+
+	var candidates []gcodeInfo
+	for _, c := range commonGCodes {
+		if strings.HasPrefix(c.code, prefix) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return text, nil
+	case 1:
+		return candidates[0].code + " ", candidates
+	}
+
+	common := candidates[0].code
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c.code, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		return common, candidates
+	}
+	return text, candidates
 }
@@ -0,0 +1,64 @@
+package reprapctl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCommandHistory(t *testing.T) {
+	h := newCommandHistory([]string{"G28", "M105", "M105"}, 3)
+	if got, want := h.Entries(), []string{"G28", "M105"}; !slices.Equal(got, want) {
+		t.Fatalf("Entries: want %q, got %q", want, got)
+	}
+
+	h.Add("M114")
+	h.Add("M115")
+	if got, want := h.Entries(), []string{"M105", "M114", "M115"}; !slices.Equal(got, want) {
+		t.Fatalf("Entries after Add: want %q, got %q", want, got)
+	}
+
+	var got []string
+	for {
+		s, ok := h.Previous("G1 X")
+		if !ok {
+			break
+		}
+		got = append(got, s)
+	}
+	for {
+		s, ok := h.Next()
+		if !ok {
+			break
+		}
+		got = append(got, s)
+	}
+	if want := []string{"M115", "M114", "M105", "M114", "M115", "G1 X"}; !slices.Equal(got, want) {
+		t.Errorf("Browsing: want %q, got %q", want, got)
+	}
+}
+
+func TestCompleteCommand(t *testing.T) {
+	tests := []struct {
+		text       string
+		want       string
+		candidates int
+	}{
+		{text: "", want: ""},
+		{text: "g2", want: "g2", candidates: 4},
+		{text: "G28", want: "G28 ", candidates: 1},
+		{text: "M50", want: "M50", candidates: 4},
+		{text: "m5", want: "M50", candidates: 4},
+		{text: "M1", want: "M1", candidates: 16},
+		{text: "M15", want: "M155 ", candidates: 1},
+		{text: "X", want: "X"},
+		{text: "M104 S", want: "M104 S"},
+	}
+
+	for _, tt := range tests {
+		got, candidates := completeCommand(tt.text)
+		if got != tt.want || len(candidates) != tt.candidates {
+			t.Errorf("completeCommand(%q): want %q with %v candidates, got %q with %v",
+				tt.text, tt.want, tt.candidates, got, len(candidates))
+		}
+	}
+}
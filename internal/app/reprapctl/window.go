@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
 	"log/slog"
 	"reprapctl/internal/pkg/logview"
+	"reprapctl/internal/pkg/tempchart"
+	"reprapctl/pkg/doc"
 	"reprapctl/pkg/yall"
-	"time"
 )
 
 func CreateMainWindow(app fyne.App, logger *slog.Logger, logFanOut *yall.FanOutSink) fyne.Window {
@@ -24,35 +28,41 @@ func CreateMainWindow(app fyne.App, logger *slog.Logger, logFanOut *yall.FanOutS
 	w.SetMainMenu(&m)
 	logView := logview.New()
 	logView.SetCapacity(2000)
-	logView.AddLine("foo")
-	logView.AddLine("bar")
-	logView.AddLine("baz")
-	logView.AddLine(
-		"        Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt " +
-			"ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco " +
-			"laboris nisi ut aliquip ex ea commodo consequat.\n" +
-			"        Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat " +
-			"nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia " +
-			"deserunt mollit anim id est laborum.")
-	var i int
-	for ; i < 1990; i++ {
-		logView.AddLine(fmt.Sprint("Line #", i))
-	}
+	logView.SetHighlighter(logview.HighlighterFunc(highlightLine))
+
+	chart := tempchart.New()
+	terminal := NewTerminal(logView, app.Preferences())
+	files := newSDPanel(logger, w)
+	conn := newConnection(logger, w, terminal, chart, files)
+	jobs := newJobPanel(logger, w, conn, app.Preferences())
 
 	lvs := &logViewSink{logView: logView}
 	logFanOut.AddSink(lvs)
 	w.SetOnClosed(func() {
+		jobs.Close()
+		conn.Close()
+		files.Close()
 		logFanOut.RemoveSink(lvs)
 	})
 
-	go func() {
-		for {
-			<-time.After(1 * time.Second)
-			logger.Info("Line", "n", i)
-			i++
-		}
-	}()
-	w.SetContent(logView)
+	tabs := container.NewAppTabs(
+		container.NewTabItemWithIcon("Temperature", theme.ColorChromaticIcon(), chart),
+		container.NewTabItemWithIcon("SD card", theme.StorageIcon(), files.content),
+	)
+	split := container.NewHSplit(terminal, tabs)
+	split.SetOffset(0.6)
+
+	// the emergency stop is big and red, and does not ask for confirmation
+	stop := widget.NewButtonWithIcon("EMERGENCY STOP", theme.ErrorIcon(), func() {
+		jobs.abort()
+		conn.EmergencyStop()
+	})
+	stop.Importance = widget.DangerImportance
+	top := container.NewBorder(nil, nil, nil,
+		container.NewGridWrap(fyne.NewSize(220, 2*stop.MinSize().Height), stop),
+		conn.toolbar,
+	)
+	w.SetContent(container.NewBorder(top, jobs.content, nil, nil, split))
 	return w
 }
 
@@ -67,7 +77,10 @@ func (s *logViewSink) Enabled(_ context.Context, level slog.Level) bool {
 func (s *logViewSink) Handle(context context.Context, record slog.Record) error {
 	var buf []byte
 	buf = viewLogFormatter.Append(buf, context, record)
-	s.logView.AddLine(string(buf))
+	s.logView.AddLines(doc.Line{
+		Text:  string(buf),
+		Attrs: doc.Attrs{Time: record.Time, Level: record.Level, Source: doc.SourceAppLog},
+	})
 	s.logView.Refresh()
 	return nil
 }
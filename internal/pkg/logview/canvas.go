@@ -5,8 +5,10 @@ import (
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"regexp"
 	"reprapctl/pkg/alg"
 	"reprapctl/pkg/doc"
+	"slices"
 	"sync"
 	"sync/atomic"
 )
@@ -104,6 +106,7 @@ type logCanvasRenderer struct {
 	wrappedLines      []Box
 	visibleItems      map[int]*logCanvasItem
 	visibleSelections map[int]*logSelectionRect
+	matchRects        []*logSelectionRect
 	refreshId         int
 	itemsLock         sync.RWMutex
 
@@ -112,12 +115,13 @@ type logCanvasRenderer struct {
 	objects   atomic.Value
 }
 
+// wrapContext is what the wrapped lines depend on besides the document. The whole
+// document is wrapped again when the context changes.
 type wrapContext struct {
-	documentVersion uint64
-	width           float32
-	wrap            fyne.TextWrap
-	textSize        float32
-	textStyle       fyne.TextStyle
+	width     float32
+	wrap      fyne.TextWrap
+	textSize  float32
+	textStyle fyne.TextStyle
 }
 
 func newLogCanvasRenderer(logView *LogView) *logCanvasRenderer {
@@ -128,7 +132,7 @@ func newLogCanvasRenderer(logView *LogView) *logCanvasRenderer {
 	}
 
 	r.itemCache.New = func() any {
-		return newLogCanvasItem(theme.ForegroundColor())
+		return newLogCanvasItem()
 	}
 
 	r.rectCache.New = func() any {
@@ -173,11 +177,10 @@ func (r *logCanvasRenderer) Refresh() {
 	scrollOffset, scrollSize := r.logView.scroller.Offset, r.logView.scroller.Size()
 
 	context := wrapContext{
-		documentVersion: r.logView.document.Version(),
-		width:           scrollSize.Width - theme.InnerPadding()*2,
-		wrap:            r.logView.Wrapping(),
-		textSize:        r.logView.TextSize(),
-		textStyle:       r.logView.TextStyle(),
+		width:     scrollSize.Width - theme.InnerPadding()*2,
+		wrap:      r.logView.Wrapping(),
+		textSize:  r.logView.TextSize(),
+		textStyle: r.logView.TextStyle(),
 	}
 
 	r.itemsLock.Lock()
@@ -187,6 +190,7 @@ func (r *logCanvasRenderer) Refresh() {
 
 	dirty := r.rewrap(context)
 	r.renderItems(scrollOffset.Y, scrollSize.Height, dirty)
+	r.renderMatches(r.logView.searchPattern())
 	r.renderSelection()
 	r.cacheObjects()
 }
@@ -283,8 +287,44 @@ func (r *logCanvasRenderer) renderSelection() {
 	}
 }
 
+// renderMatches highlights the matches of the search pattern in the visible items. The
+// pattern can be nil. renderMatches assumes a write lock on r.itemsLock.
+func (r *logCanvasRenderer) renderMatches(search *regexp.Regexp) {
+	for _, rect := range r.matchRects {
+		r.rectCache.Put(rect)
+	}
+	r.matchRects = r.matchRects[:0]
+	if search == nil {
+		return
+	}
+
+	// lines wrapped into several visible items are searched once
+	lineMatches := make(map[int][][]int)
+	matchColor := theme.FocusColor()
+	for _, item := range r.visibleItems {
+		box := item.box
+		matches, ok := lineMatches[box.Start.LineIndex]
+		if !ok {
+			matches = findMatches(search, box.Line)
+			lineMatches[box.Start.LineIndex] = matches
+		}
+		for _, m := range boxMatches(matches, box.Start.LineOffset, box.End.LineOffset) {
+			x1, x2 := item.CharToX(m[0]), item.CharToX(m[1])
+			rect := r.rectCache.Get().(*logSelectionRect)
+			rect.rect.FillColor = matchColor
+			rect.Move(box.Position().Add(fyne.NewPos(x1, 0)))
+			rect.Resize(fyne.NewSize(x2-x1, box.Size().Height))
+			rect.Refresh()
+			r.matchRects = append(r.matchRects, rect)
+		}
+	}
+}
+
 func (r *logCanvasRenderer) cacheObjects() {
-	objects := make([]fyne.CanvasObject, 0, len(r.visibleSelections)+len(r.visibleItems))
+	objects := make([]fyne.CanvasObject, 0, len(r.matchRects)+len(r.visibleSelections)+len(r.visibleItems))
+	for _, rect := range r.matchRects {
+		objects = append(objects, rect)
+	}
 	for _, rect := range r.visibleSelections {
 		objects = append(objects, rect)
 	}
@@ -294,23 +334,40 @@ func (r *logCanvasRenderer) cacheObjects() {
 	r.objects.Store(objects)
 }
 
+// rewrap updates the wrapped lines after the document or the context changed. Only the
+// appended lines are wrapped, and the boxes of lines trimmed from the top are dropped,
+// unless the context changed. It returns true if existing boxes changed.
+// rewrap assumes a write lock on r.itemsLock.
 func (r *logCanvasRenderer) rewrap(context wrapContext) bool {
-	// no need to rewrap if context didn't change
-	if context.width <= 0 || context == r.wrapContext {
+	if context.width <= 0 {
 		return false
 	}
+	changes, reset := r.logView.takeChanges(context != r.wrapContext)
 	r.wrapContext = context
 
-	var lines []string
-	r.logView.document.Read(func(ll []string) {
-		lines = make([]string, len(ll))
-		copy(lines, ll)
-	})
+	dirty := reset
+	if reset {
+		r.wrappedLines = make([]Box, 0, len(changes[0].Lines))
+	}
+	for _, c := range changes {
+		if c.Removed > 0 {
+			r.dropLines(c.Removed)
+			dirty = true
+		}
+		r.wrapLines(c.Start, c.Lines, c.Attrs)
+	}
+	return dirty
+}
 
+// wrapLines appends the boxes of lines starting at the line index first. Attrs are the
+// attributes of the lines. wrapLines assumes a write lock on r.itemsLock.
+func (r *logCanvasRenderer) wrapLines(first int, lines []string, attrs []doc.Attrs) {
+	context := r.wrapContext
 	lineSpacing := theme.LineSpacing()
-	padding := theme.InnerPadding()
-	pos := fyne.Position{X: padding, Y: padding}
-	r.wrappedLines = make([]Box, 0, len(lines))
+	pos := fyne.Position{X: theme.InnerPadding(), Y: theme.InnerPadding()}
+	if n := len(r.wrappedLines); n > 0 {
+		pos.Y = r.wrappedLines[n-1].Position().Y + r.wrappedLines[n-1].Size().Height
+	}
 
 	for i, line := range lines {
 		doc.WrapString(
@@ -321,16 +378,39 @@ func (r *logCanvasRenderer) rewrap(context wrapContext) bool {
 			func(s string, o int, z fyne.Size) {
 				// round height down to avoid pixel artifacts
 				z.Height = float32(int(z.Height)) + lineSpacing
-				start := doc.Anchor{LineIndex: i, LineOffset: o}
-				end := doc.Anchor{LineIndex: i, LineOffset: o + len(s)}
-				r.wrappedLines = append(
-					r.wrappedLines, NewTextBox(pos, z, start, end, s, context.textSize, context.textStyle))
+				start := doc.Anchor{LineIndex: first + i, LineOffset: o}
+				end := doc.Anchor{LineIndex: first + i, LineOffset: o + len(s)}
+				box := NewTextBox(pos, z, start, end, s, context.textSize, context.textStyle)
+				box.Line = line
+				box.Spans = boxSpans(attrs[i].Spans, o, o+len(s))
+				r.wrappedLines = append(r.wrappedLines, box)
 				pos.Y += z.Height
 			},
 		)
 	}
+}
+
+// dropLines removes the boxes of lines trimmed from the top of the document, and moves
+// the remaining boxes up. dropLines assumes a write lock on r.itemsLock.
+func (r *logCanvasRenderer) dropLines(n int) {
+	i, _ := slices.BinarySearchFunc(r.wrappedLines, n, func(box Box, line int) int {
+		return box.StartAnchor().LineIndex - line
+	})
+	if i == len(r.wrappedLines) {
+		r.wrappedLines = r.wrappedLines[:0]
+		return
+	}
 
-	return true
+	dy := r.wrappedLines[i].Position().Y - theme.InnerPadding()
+	kept := copy(r.wrappedLines, r.wrappedLines[i:])
+	clear(r.wrappedLines[kept:])
+	r.wrappedLines = r.wrappedLines[:kept]
+	for _, box := range r.wrappedLines {
+		t := box.(*TextBox)
+		t.BoxPosition.Y -= dy
+		t.Start.LineIndex -= n
+		t.End.LineIndex -= n
+	}
 }
 
 func (r *logCanvasRenderer) getAnchorAtPoint(p fyne.Position) doc.Anchor {
@@ -0,0 +1,88 @@
+package logview
+
+import (
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"reflect"
+	"reprapctl/pkg/doc"
+	"strings"
+	"testing"
+)
+
+func TestLogCanvasRenderer_Rewrap(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	l := New()
+	l.SetCapacity(6)
+	r := newLogCanvasRenderer(l)
+	context := wrapContext{width: 100, wrap: fyne.TextWrapWord, textSize: 14, textStyle: fyne.TextStyle{Monospace: true}}
+
+	n := 0
+	line := func(words int) doc.Line {
+		n++
+		text := fmt.Sprintf("line%d", n) + strings.Repeat(" word", words)
+		return doc.Line{Text: text, Attrs: doc.Attrs{Spans: []doc.Span{{Start: 0, End: len(text), Color: doc.ColorError}}}}
+	}
+	add := func(words ...int) func() {
+		return func() {
+			for _, w := range words {
+				l.AddLines(line(w))
+			}
+		}
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+	}{
+		{name: "Empty", change: func() {}},
+		{name: "Append", change: add(0, 1)},
+		{name: "AppendWrapped", change: add(12, 0)},
+		{name: "AppendAndTrim", change: add(3, 20, 0)},
+		{name: "TrimWrapped", change: add(1)},
+		{name: "TrimAddedLines", change: func() {
+			lines := make([]doc.Line, 8)
+			for i := range lines {
+				lines[i] = line(i * 3)
+			}
+			l.AddLines(lines...)
+		}},
+		{name: "SeveralChanges", change: add(5, 0, 7, 2)},
+		{name: "ShrinkCapacity", change: func() { l.SetCapacity(2) }},
+		{name: "GrowCapacity", change: func() {
+			l.SetCapacity(10)
+			add(4, 4, 0)()
+		}},
+	}
+	for _, step := range steps {
+		step.change()
+		r.rewrap(context)
+
+		// the incrementally wrapped lines match the whole document wrapped anew
+		full := newLogCanvasRenderer(l)
+		full.rewrap(context)
+		if !reflect.DeepEqual(r.wrappedLines, full.wrappedLines) {
+			t.Errorf("%s: incremental rewrap differs from a full rewrap", step.name)
+			for i := 0; i < max(len(r.wrappedLines), len(full.wrappedLines)); i++ {
+				var got, want Box
+				if i < len(r.wrappedLines) {
+					got = r.wrappedLines[i]
+				}
+				if i < len(full.wrappedLines) {
+					want = full.wrappedLines[i]
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Logf("box %d: want %+v, got %+v", i, want, got)
+				}
+			}
+		}
+		if step.name != "Empty" && len(r.wrappedLines) == 0 {
+			t.Errorf("%s: no boxes", step.name)
+		}
+	}
+	if lines := r.wrappedLines[len(r.wrappedLines)-1].EndAnchor().LineIndex + 1; len(r.wrappedLines) <= lines {
+		t.Errorf("No lines were wrapped: %d boxes for %d lines", len(r.wrappedLines), lines)
+	}
+}
@@ -0,0 +1,184 @@
+package logview
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"regexp"
+	"reprapctl/pkg/doc"
+)
+
+// findBar is the bar above the log view which searches the document. Matches are
+// highlighted, and the current match is selected.
+type findBar struct {
+	logView       *LogView
+	entry         *findEntry
+	regex         *widget.Check
+	caseSensitive *widget.Check
+	status        *widget.Label
+	content       *fyne.Container
+}
+
+func newFindBar(l *LogView) *findBar {
+	b := &findBar{logView: l}
+
+	b.entry = newFindEntry()
+	b.entry.SetPlaceHolder("Find")
+	b.entry.OnChanged = func(string) { b.update() }
+	b.entry.OnSubmitted = func(string) { b.find(false) }
+	b.entry.onEscape = b.hide
+	b.regex = widget.NewCheck("Regex", func(bool) { b.update() })
+	b.caseSensitive = widget.NewCheck("Match case", func(bool) { b.update() })
+	b.status = widget.NewLabel("")
+
+	previous := widget.NewButtonWithIcon("", theme.MoveUpIcon(), func() { b.find(true) })
+	next := widget.NewButtonWithIcon("", theme.MoveDownIcon(), func() { b.find(false) })
+	closeButton := widget.NewButtonWithIcon("", theme.CancelIcon(), b.hide)
+	closeButton.Importance = widget.LowImportance
+
+	b.content = container.NewBorder(nil, nil, nil,
+		container.NewHBox(b.status, b.caseSensitive, b.regex, previous, next, closeButton),
+		b.entry,
+	)
+	b.content.Hide()
+	return b
+}
+
+// show shows the bar and focuses the entry.
+func (b *findBar) show() {
+	b.content.Show()
+	b.update()
+	if c := fyne.CurrentApp().Driver().CanvasForObject(b.entry); c != nil {
+		c.Focus(b.entry)
+	}
+}
+
+// hide hides the bar and stops highlighting matches.
+func (b *findBar) hide() {
+	b.content.Hide()
+	_ = b.logView.setSearch("", false, false)
+	b.logView.Refresh()
+	b.logView.requestFocus()
+}
+
+// update applies the search options and highlights the matches.
+func (b *findBar) update() {
+	if err := b.logView.setSearch(b.entry.Text, b.regex.Checked, b.caseSensitive.Checked); err != nil {
+		b.status.SetText("Invalid pattern")
+	} else {
+		b.status.SetText("")
+	}
+	b.logView.Refresh()
+}
+
+// find selects the next or previous match.
+func (b *findBar) find(backward bool) {
+	if b.logView.searchPattern() == nil {
+		return
+	}
+	if b.logView.findNext(backward) {
+		b.status.SetText("")
+	} else {
+		b.status.SetText("Not found")
+	}
+}
+
+// findEntry is an entry which hides the find bar on escape.
+type findEntry struct {
+	widget.Entry
+	onEscape func()
+}
+
+func newFindEntry() *findEntry {
+	e := &findEntry{}
+	e.ExtendBaseWidget(e)
+	return e
+}
+
+func (e *findEntry) TypedKey(key *fyne.KeyEvent) {
+	if key.Name == fyne.KeyEscape && e.onEscape != nil {
+		e.onEscape()
+		return
+	}
+	e.Entry.TypedKey(key)
+}
+
+// compileSearch compiles the text of the find bar into a pattern. Plain text is matched
+// literally. compileSearch returns nil if the text is empty.
+func compileSearch(text string, regex, caseSensitive bool) (*regexp.Regexp, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if !regex {
+		text = regexp.QuoteMeta(text)
+	}
+	if !caseSensitive {
+		text = "(?i)" + text
+	}
+	return regexp.Compile(text)
+}
+
+// findMatches returns the non-empty matches of the pattern in a line.
+func findMatches(re *regexp.Regexp, line string) [][]int {
+	matches := re.FindAllStringIndex(line, -1)
+	n := 0
+	for _, m := range matches {
+		if m[0] < m[1] {
+			matches[n] = m
+			n++
+		}
+	}
+	return matches[:n]
+}
+
+// findMatch searches the lines for the first match after the anchor, or the last match
+// before it if backward is set. The search wraps around the end of the lines.
+func findMatch(lines []string, re *regexp.Regexp, from doc.Anchor, backward bool) (start, end doc.Anchor, ok bool) {
+	n := len(lines)
+	if n == 0 {
+		return
+	}
+	from.LineIndex = min(max(from.LineIndex, 0), n-1)
+
+	// the line of the anchor is searched twice: after the anchor first, before it last
+	for k := 0; k <= n; k++ {
+		i := (from.LineIndex + k) % n
+		if backward {
+			i = (from.LineIndex - k + n) % n
+		}
+		var found []int
+		for _, m := range findMatches(re, lines[i]) {
+			switch {
+			case k == 0 && backward && m[0] >= from.LineOffset,
+				k == 0 && !backward && m[0] < from.LineOffset,
+				k == n && backward && m[0] < from.LineOffset,
+				k == n && !backward && m[0] >= from.LineOffset:
+				continue
+			}
+			found = m
+			if !backward {
+				break
+			}
+		}
+		if found != nil {
+			start = doc.Anchor{LineIndex: i, LineOffset: found[0]}
+			end = doc.Anchor{LineIndex: i, LineOffset: found[1]}
+			return start, end, true
+		}
+	}
+	return
+}
+
+// boxMatches returns the matches in a line that fall into the part of the line from
+// offset start to end, relative to start.
+func boxMatches(matches [][]int, start, end int) [][]int {
+	var r [][]int
+	for _, m := range matches {
+		if m[1] <= start || m[0] >= end {
+			continue
+		}
+		r = append(r, []int{max(m[0], start) - start, min(m[1], end) - start})
+	}
+	return r
+}
@@ -0,0 +1,178 @@
+package logview
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"reflect"
+	"regexp"
+	"reprapctl/pkg/doc"
+	"testing"
+)
+
+func TestCompileSearch(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		regex         bool
+		caseSensitive bool
+		line          string
+		want          [][]int
+	}{
+		{name: "Literal", text: "a.c", line: "abc a.c", want: [][]int{{4, 7}}},
+		{name: "LiteralParenthesis", text: "(", line: "G1 X1 (move)", want: [][]int{{6, 7}}},
+		{name: "Regex", text: "a.c", regex: true, line: "abc a.c", want: [][]int{{0, 3}, {4, 7}}},
+		{name: "IgnoreCase", text: "OK", line: "ok Ok", want: [][]int{{0, 2}, {3, 5}}},
+		{name: "MatchCase", text: "OK", caseSensitive: true, line: "ok Ok"},
+		{name: "RegexIgnoreCase", text: "^ok", regex: true, line: "OK ok", want: [][]int{{0, 2}}},
+		{name: "NoEmptyMatches", text: "x*", regex: true, line: "axxb", want: [][]int{{1, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := compileSearch(tt.text, tt.regex, tt.caseSensitive)
+			if err != nil {
+				t.Fatalf("compileSearch failed: %v", err)
+			}
+			if got := findMatches(re, tt.line); len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if re, err := compileSearch("", true, false); re != nil || err != nil {
+		t.Errorf("Empty text: want nil, got %v, %v", re, err)
+	}
+	if _, err := compileSearch("(", true, false); err == nil {
+		t.Errorf("Invalid regex compiled")
+	}
+}
+
+func TestFindMatch(t *testing.T) {
+	lines := []string{"ok T:20", "echo: ok", "error", "ok ok"}
+	at := func(line, offset int) doc.Anchor { return doc.Anchor{LineIndex: line, LineOffset: offset} }
+
+	tests := []struct {
+		name     string
+		lines    []string
+		pattern  string
+		from     doc.Anchor
+		backward bool
+		want     doc.Anchor
+		wantEnd  doc.Anchor
+		wantOk   bool
+	}{
+		{name: "AtAnchor", from: at(0, 0), want: at(0, 0), wantEnd: at(0, 2), wantOk: true},
+		{name: "AfterSelection", from: at(0, 2), want: at(1, 6), wantEnd: at(1, 8), wantOk: true},
+		{name: "SkipLines", from: at(1, 8), want: at(3, 0), wantEnd: at(3, 2), wantOk: true},
+		{name: "SameLine", from: at(3, 1), want: at(3, 3), wantEnd: at(3, 5), wantOk: true},
+		{name: "WrapAround", from: at(3, 5), want: at(0, 0), wantEnd: at(0, 2), wantOk: true},
+		{name: "BeyondEnd", from: at(10, 0), want: at(3, 0), wantEnd: at(3, 2), wantOk: true},
+		{name: "Backward", from: at(3, 3), backward: true, want: at(3, 0), wantEnd: at(3, 2), wantOk: true},
+		{name: "BackwardSkipLines", from: at(3, 0), backward: true, want: at(1, 6), wantEnd: at(1, 8), wantOk: true},
+		{name: "BackwardLastInLine", from: at(3, 5), backward: true, want: at(3, 3), wantEnd: at(3, 5), wantOk: true},
+		{name: "BackwardWrapAround", from: at(0, 0), backward: true, want: at(3, 3), wantEnd: at(3, 5), wantOk: true},
+		{
+			name:    "OnlyBeforeAnchor",
+			lines:   []string{"ok", "x"},
+			from:    at(0, 1),
+			want:    at(0, 0),
+			wantEnd: at(0, 2),
+			wantOk:  true,
+		},
+		{
+			name:     "BackwardOnlyAfterAnchor",
+			lines:    []string{"x ok"},
+			from:     at(0, 1),
+			backward: true,
+			want:     at(0, 2),
+			wantEnd:  at(0, 4),
+			wantOk:   true,
+		},
+		{name: "NoMatch", pattern: "zzz", from: at(1, 0)},
+		{name: "NoLines", lines: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.lines == nil {
+				tt.lines = lines
+			}
+			if tt.pattern == "" {
+				tt.pattern = "ok"
+			}
+			start, end, ok := findMatch(tt.lines, regexp.MustCompile(tt.pattern), tt.from, tt.backward)
+			if ok != tt.wantOk || start != tt.want || end != tt.wantEnd {
+				t.Errorf("want %v to %v, %v, got %v to %v, %v", tt.want, tt.wantEnd, tt.wantOk, start, end, ok)
+			}
+		})
+	}
+}
+
+func TestBoxMatches(t *testing.T) {
+	matches := [][]int{{2, 5}, {8, 12}, {15, 16}}
+	tests := []struct {
+		start, end int
+		want       [][]int
+	}{
+		{start: 0, end: 20, want: matches},
+		{start: 4, end: 10, want: [][]int{{0, 1}, {4, 6}}},
+		{start: 5, end: 8},
+		{start: 9, end: 11, want: [][]int{{0, 2}}},
+	}
+	for _, tt := range tests {
+		if got := boxMatches(matches, tt.start, tt.end); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d to %d: want %v, got %v", tt.start, tt.end, tt.want, got)
+		}
+	}
+}
+
+func TestLogView_FindNext_Trim(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	l := New()
+	l.SetCapacity(4)
+	for _, line := range []string{"ok 1", "busy", "ok 2", "busy", "ok 3"} {
+		l.AddLine(line)
+	}
+	r := newLogCanvasRenderer(l)
+	context := wrapContext{width: 100, wrap: fyne.TextWrapWord, textSize: 14, textStyle: fyne.TextStyle{Monospace: true}}
+	r.rewrap(context)
+	if err := l.setSearch("OK", false, false); err != nil {
+		t.Fatalf("setSearch failed: %v", err)
+	}
+	// matches are highlighted when rendering, a new search pattern does not wrap the lines anew
+	if r.rewrap(context) {
+		t.Errorf("Search rewrapped the lines")
+	}
+	selected := func() string {
+		s, _ := l.document.String(bookmarkSelectionStart, bookmarkSelectionEnd, "\n")
+		start, _, _ := l.selection()
+		line := ""
+		l.document.Read(func(lines []string) { line = lines[start.LineIndex] })
+		return s + " in " + line
+	}
+
+	// the first line was trimmed already
+	if !l.findNext(false) || selected() != "ok in ok 2" {
+		t.Fatalf("First match: got %q", selected())
+	}
+
+	// the selection follows its line when lines are trimmed from the top
+	l.AddLine("ok 4")
+	l.AddLine("busy")
+	if !l.findNext(false) || selected() != "ok in ok 3" {
+		t.Errorf("Next match after trimming: got %q", selected())
+	}
+	if !l.findNext(false) || selected() != "ok in ok 4" {
+		t.Errorf("Next match: got %q", selected())
+	}
+	if !l.findNext(false) || selected() != "ok in ok 3" {
+		t.Errorf("Next match after wrapping around: got %q", selected())
+	}
+
+	// the selected line itself is trimmed
+	l.AddLine("busy")
+	l.AddLine("busy")
+	if !l.findNext(true) || selected() != "ok in ok 4" {
+		t.Errorf("Previous match after trimming the selection: got %q", selected())
+	}
+}
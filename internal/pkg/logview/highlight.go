@@ -0,0 +1,33 @@
+package logview
+
+import (
+	"reprapctl/pkg/doc"
+)
+
+// Highlighter styles the lines added to a LogView, see LogView.SetHighlighter.
+type Highlighter interface {
+	// Highlight returns the styled spans of a line. The spans replace line.Attrs.Spans, so
+	// a highlighter which keeps the spans of the caller must include them in the result.
+	Highlight(line doc.Line) []doc.Span
+}
+
+// HighlighterFunc is a function that implements Highlighter.
+type HighlighterFunc func(line doc.Line) []doc.Span
+
+func (f HighlighterFunc) Highlight(line doc.Line) []doc.Span {
+	return f(line)
+}
+
+// boxSpans returns the non-empty spans of a line that fall into the part of the line from
+// offset start to end, relative to start.
+func boxSpans(spans []doc.Span, start, end int) []doc.Span {
+	var r []doc.Span
+	for _, s := range spans {
+		if s.Start >= s.End || s.End <= start || s.Start >= end {
+			continue
+		}
+		s.Start, s.End = max(s.Start, start)-start, min(s.End, end)-start
+		r = append(r, s)
+	}
+	return r
+}
@@ -0,0 +1,48 @@
+package logview
+
+import (
+	"reflect"
+	"reprapctl/pkg/doc"
+	"testing"
+)
+
+func TestBoxSpans(t *testing.T) {
+	red := doc.Span{Color: doc.ColorError}
+	span := func(s doc.Span, start, end int) doc.Span {
+		s.Start, s.End = start, end
+		return s
+	}
+
+	// the box is the part of the line from offset 10 to 20
+	tests := []struct {
+		name  string
+		spans []doc.Span
+		want  []doc.Span
+	}{
+		{name: "None"},
+		{name: "Before", spans: []doc.Span{span(red, 0, 10)}},
+		{name: "After", spans: []doc.Span{span(red, 20, 30)}},
+		{name: "Inside", spans: []doc.Span{span(red, 12, 15)}, want: []doc.Span{span(red, 2, 5)}},
+		{name: "CrossingStart", spans: []doc.Span{span(red, 5, 15)}, want: []doc.Span{span(red, 0, 5)}},
+		{name: "CrossingEnd", spans: []doc.Span{span(red, 15, 25)}, want: []doc.Span{span(red, 5, 10)}},
+		{name: "CoveringBox", spans: []doc.Span{span(red, 0, 30)}, want: []doc.Span{span(red, 0, 10)}},
+		{name: "Empty", spans: []doc.Span{span(red, 15, 15), span(red, 10, 10)}},
+		{
+			name:  "Overlapping",
+			spans: []doc.Span{span(red, 5, 15), {Start: 12, End: 25, Bold: true}},
+			want:  []doc.Span{span(red, 0, 5), {Start: 2, End: 10, Bold: true}},
+		},
+		{
+			name:  "Several",
+			spans: []doc.Span{span(red, 0, 11), {Start: 13, End: 14, Bold: true}, span(red, 19, 21)},
+			want:  []doc.Span{span(red, 0, 1), {Start: 3, End: 4, Bold: true}, span(red, 9, 10)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := boxSpans(tt.spans, 10, 20); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package logview
 
 import (
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
 	"reprapctl/pkg/alg"
 	"reprapctl/pkg/doc"
 )
@@ -20,6 +21,13 @@ type TextBox struct {
 	Text        string
 	TextSize    float32
 	TextStyle   fyne.TextStyle
+
+	// Line is the whole line that the text is a part of. Matches of the search pattern
+	// are found in the line, so that they can cross the boxes of a wrapped line.
+	Line string
+
+	// Spans are the styled spans of the text, with offsets relative to the box.
+	Spans []doc.Span
 }
 
 func NewTextBox(
@@ -54,5 +62,66 @@ func (t *TextBox) AnchorAtX(x float32) doc.Anchor {
 }
 
 func (t *TextBox) CharToX(offset int) float32 {
-	return fyne.MeasureText(t.Text[:offset], t.TextSize, t.TextStyle).Width
+	var x float32
+	for _, s := range t.Segments() {
+		if offset <= s.Start {
+			break
+		}
+		x += fyne.MeasureText(t.Text[s.Start:min(offset, s.End)], t.TextSize, s.TextStyle).Width
+	}
+	return x
+}
+
+// themeColor returns the theme color for the color of a span.
+func themeColor(c doc.Color) fyne.ThemeColorName {
+	switch c {
+	case doc.ColorPrimary:
+		return theme.ColorNamePrimary
+	case doc.ColorError:
+		return theme.ColorNameError
+	case doc.ColorWarning:
+		return theme.ColorNameWarning
+	case doc.ColorSuccess:
+		return theme.ColorNameSuccess
+	case doc.ColorDimmed:
+		return theme.ColorNameDisabled
+	default:
+		return theme.ColorNameForeground
+	}
+}
+
+// TextSegment is a part of a text box with a single style.
+type TextSegment struct {
+	Start, End int
+	Color      fyne.ThemeColorName
+	TextStyle  fyne.TextStyle
+}
+
+// Segments splits the text into segments according to the spans. The text outside of
+// spans has the default foreground color. Spans must be sorted by Start; empty spans and
+// the parts of spans overlapping previous spans are ignored.
+func (t *TextBox) Segments() []TextSegment {
+	plain := func(start, end int) TextSegment {
+		return TextSegment{Start: start, End: end, Color: theme.ColorNameForeground, TextStyle: t.TextStyle}
+	}
+
+	r := make([]TextSegment, 0, 2*len(t.Spans)+1)
+	pos := 0
+	for _, s := range t.Spans {
+		start, end := max(s.Start, pos), min(s.End, len(t.Text))
+		if start >= end {
+			continue
+		}
+		if start > pos {
+			r = append(r, plain(pos, start))
+		}
+		style := t.TextStyle
+		style.Bold = style.Bold || s.Bold
+		r = append(r, TextSegment{Start: start, End: end, Color: themeColor(s.Color), TextStyle: style})
+		pos = end
+	}
+	if pos < len(t.Text) || len(r) == 0 {
+		r = append(r, plain(pos, len(t.Text)))
+	}
+	return r
 }
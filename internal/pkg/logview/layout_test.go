@@ -0,0 +1,141 @@
+package logview
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/theme"
+	"reflect"
+	"reprapctl/pkg/doc"
+	"testing"
+)
+
+func TestTextBox_Segments(t *testing.T) {
+	mono := fyne.TextStyle{Monospace: true}
+	bold := fyne.TextStyle{Monospace: true, Bold: true}
+	fg, red, yellow := theme.ColorNameForeground, theme.ColorNameError, theme.ColorNameWarning
+
+	tests := []struct {
+		name  string
+		text  string
+		spans []doc.Span
+		want  []TextSegment
+	}{
+		{
+			name: "NoSpans",
+			text: "0123456789",
+			want: []TextSegment{{0, 10, fg, mono}},
+		},
+		{
+			name: "EmptyText",
+			want: []TextSegment{{0, 0, fg, mono}},
+		},
+		{
+			name:  "Middle",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 2, End: 5, Color: doc.ColorError, Bold: true}},
+			want:  []TextSegment{{0, 2, fg, mono}, {2, 5, red, bold}, {5, 10, fg, mono}},
+		},
+		{
+			name:  "WholeText",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 0, End: 10, Color: doc.ColorError}},
+			want:  []TextSegment{{0, 10, red, mono}},
+		},
+		{
+			name:  "Adjacent",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 0, End: 4, Color: doc.ColorError}, {Start: 4, End: 10, Bold: true}},
+			want:  []TextSegment{{0, 4, red, mono}, {4, 10, fg, bold}},
+		},
+		{
+			name:  "Empty",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 3, End: 3, Color: doc.ColorError}},
+			want:  []TextSegment{{0, 10, fg, mono}},
+		},
+		{
+			name:  "Overlapping",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 2, End: 6, Color: doc.ColorError}, {Start: 4, End: 8, Color: doc.ColorWarning}},
+			want:  []TextSegment{{0, 2, fg, mono}, {2, 6, red, mono}, {6, 8, yellow, mono}, {8, 10, fg, mono}},
+		},
+		{
+			name:  "Contained",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 2, End: 8, Color: doc.ColorError}, {Start: 3, End: 5, Color: doc.ColorWarning}},
+			want:  []TextSegment{{0, 2, fg, mono}, {2, 8, red, mono}, {8, 10, fg, mono}},
+		},
+		{
+			name:  "PastEnd",
+			text:  "0123456789",
+			spans: []doc.Span{{Start: 8, End: 15, Color: doc.ColorDimmed}},
+			want:  []TextSegment{{0, 8, fg, mono}, {8, 10, theme.ColorNameDisabled, mono}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box := NewTextBox(fyne.Position{}, fyne.Size{}, doc.Anchor{}, doc.Anchor{LineOffset: len(tt.text)}, tt.text, 14, mono)
+			box.Spans = tt.spans
+			if got := box.Segments(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTextBox_CharToX(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	mono := fyne.TextStyle{Monospace: true}
+	bold := fyne.TextStyle{Monospace: true, Bold: true}
+	text := "G1 X10 Y20 ; comment"
+
+	tests := []struct {
+		name  string
+		spans []doc.Span
+		// styles are the styles of the characters
+		styles func(i int) fyne.TextStyle
+	}{
+		{
+			name:   "NoSpans",
+			styles: func(int) fyne.TextStyle { return mono },
+		},
+		{
+			name:  "BoldCommand",
+			spans: []doc.Span{{Start: 0, End: 2, Bold: true}, {Start: 11, End: 20, Color: doc.ColorDimmed}},
+			styles: func(i int) fyne.TextStyle {
+				if i < 2 {
+					return bold
+				}
+				return mono
+			},
+		},
+		{
+			name:  "Overlapping",
+			spans: []doc.Span{{Start: 3, End: 8, Bold: true}, {Start: 5, End: 12, Color: doc.ColorError}},
+			styles: func(i int) fyne.TextStyle {
+				if i >= 3 && i < 8 {
+					return bold
+				}
+				return mono
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box := NewTextBox(fyne.Position{}, fyne.Size{}, doc.Anchor{}, doc.Anchor{LineOffset: len(text)}, text, 14, mono)
+			box.Spans = tt.spans
+
+			var want float32
+			for i := 0; i <= len(text); i++ {
+				if got := box.CharToX(i); got != want {
+					t.Errorf("CharToX(%d): want %v, got %v", i, want, got)
+				}
+				if i < len(text) {
+					want += fyne.MeasureText(text[i:i+1], 14, tt.styles(i)).Width
+				}
+			}
+		})
+	}
+}
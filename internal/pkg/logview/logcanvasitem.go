@@ -3,6 +3,7 @@ package logview
 import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/widget"
 	"image/color"
 	"reprapctl/pkg/alg"
@@ -11,31 +12,54 @@ import (
 
 var _ fyne.Widget = (*logCanvasItem)(nil)
 
+// logCanvasItem renders a text box with a canvas.Text for every segment of the box.
 type logCanvasItem struct {
 	widget.BaseWidget
-	box  *TextBox
-	text *canvas.Text
-	size fyne.Size
+	box     *TextBox
+	content *fyne.Container
+	texts   []*canvas.Text
+	size    fyne.Size
 }
 
-func newLogCanvasItem(color color.Color) *logCanvasItem {
-	i := &logCanvasItem{text: canvas.NewText("", color)}
+func newLogCanvasItem() *logCanvasItem {
+	i := &logCanvasItem{content: container.NewWithoutLayout()}
 	i.ExtendBaseWidget(i)
 	return i
 }
 
 func (i *logCanvasItem) CreateRenderer() fyne.WidgetRenderer {
-	return widget.NewSimpleRenderer(i.text)
+	return widget.NewSimpleRenderer(i.content)
 }
 
 func (i *logCanvasItem) MinSize() fyne.Size { return i.size }
 
 func (i *logCanvasItem) Set(box *TextBox) {
 	i.box = box
-	i.text.Text = box.Text
-	i.text.TextSize = box.TextSize
-	i.text.TextStyle = box.TextStyle
-	i.size = i.text.MinSize()
+	segments := box.Segments()
+	for len(i.texts) < len(segments) {
+		i.texts = append(i.texts, canvas.NewText("", color.Black))
+	}
+
+	settings := fyne.CurrentApp().Settings()
+	th, variant := settings.Theme(), settings.ThemeVariant()
+
+	i.size = fyne.Size{}
+	objects := make([]fyne.CanvasObject, len(segments))
+	for n, s := range segments {
+		text := i.texts[n]
+		text.Text = box.Text[s.Start:s.End]
+		text.Color = th.Color(s.Color, variant)
+		text.TextSize = box.TextSize
+		text.TextStyle = s.TextStyle
+		size := text.MinSize()
+		text.Move(fyne.NewPos(i.size.Width, 0))
+		text.Resize(size)
+		i.size = fyne.NewSize(i.size.Width+size.Width, max(i.size.Height, size.Height))
+		objects[n] = text
+	}
+	i.content.Objects = objects
+	i.content.Resize(i.size)
+	i.Resize(i.size)
 }
 
 func (i *logCanvasItem) AnchorAtX(x float32) doc.Anchor {
@@ -46,7 +70,7 @@ func (i *logCanvasItem) AnchorAtX(x float32) doc.Anchor {
 }
 
 func (i *logCanvasItem) CharToX(offset int) float32 {
-	return fyne.MeasureText(i.text.Text[:offset], i.text.TextSize, i.text.TextStyle).Width
+	return i.box.CharToX(offset)
 }
 
 var _ fyne.Widget = (*logSelectionRect)(nil)
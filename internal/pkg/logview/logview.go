@@ -7,8 +7,10 @@ import (
 	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"regexp"
 	"reprapctl/pkg/alg"
 	"reprapctl/pkg/doc"
+	"slices"
 	"sync"
 )
 
@@ -16,6 +18,7 @@ var shortcutCut = &fyne.ShortcutCut{}
 var shortcutCopy = &fyne.ShortcutCopy{}
 var shortcutSelectAll = &fyne.ShortcutSelectAll{}
 var shortcutWordWrap = &desktop.CustomShortcut{KeyName: fyne.KeyW, Modifier: fyne.KeyModifierShortcutDefault}
+var shortcutFind = &desktop.CustomShortcut{KeyName: fyne.KeyF, Modifier: fyne.KeyModifierShortcutDefault}
 
 var _ fyne.Widget = (*LogView)(nil)
 var _ fyne.Focusable = (*LogView)(nil)
@@ -27,15 +30,23 @@ type LogView struct {
 	border   *canvas.Rectangle
 	scroller *container.Scroll
 	canvas   *logCanvas
+	findBar  *findBar
 
 	textSize      float32
 	textStyle     fyne.TextStyle
 	wrapping      fyne.TextWrap
 	autoScroll    bool
 	viewTopOffset float32
+	highlighter   Highlighter
+	search        *regexp.Regexp
 	document      doc.Document
 	propertyLock  sync.RWMutex
 
+	// changes of the document not rendered yet, unless resync is set
+	changes     []doc.Change
+	resync      bool
+	changesLock sync.Mutex
+
 	shortcutHandler fyne.ShortcutHandler
 }
 
@@ -51,9 +62,12 @@ func New() *LogView {
 		wrapping:   fyne.TextWrapWord,
 		autoScroll: true,
 		document:   doc.New(),
+		resync:     true,
 	}
+	l.document.Subscribe(l.trackChange)
 
 	l.canvas = newLogCanvas(&l)
+	l.findBar = newFindBar(&l)
 	l.scroller = container.NewScroll(l.canvas)
 
 	l.scroller.OnScrolled = func(_ fyne.Position) {
@@ -104,6 +118,9 @@ func New() *LogView {
 		}
 		l.Refresh()
 	})
+	l.shortcutHandler.AddShortcut(shortcutFind, func(_ fyne.Shortcut) {
+		l.findBar.show()
+	})
 
 	l.ExtendBaseWidget(&l)
 
@@ -111,7 +128,7 @@ func New() *LogView {
 }
 
 func (l *LogView) CreateRenderer() fyne.WidgetRenderer {
-	r := NewStackRenderer(l.scroller, l.border)
+	r := NewStackRenderer(container.NewBorder(l.findBar.content, nil, nil, nil, l.scroller), l.border)
 	r.OnLayout = func(_ fyne.Size) {
 		l.Refresh()
 	}
@@ -187,6 +204,20 @@ func (l *LogView) SetWrapping(wrapping fyne.TextWrap) {
 	l.wrapping = wrapping
 }
 
+func (l *LogView) Highlighter() Highlighter {
+	l.propertyLock.RLock()
+	defer l.propertyLock.RUnlock()
+	return l.highlighter
+}
+
+// SetHighlighter sets the highlighter which styles the lines added from now on. If h is
+// nil, lines keep the spans they are added with.
+func (l *LogView) SetHighlighter(h Highlighter) {
+	l.propertyLock.Lock()
+	defer l.propertyLock.Unlock()
+	l.highlighter = h
+}
+
 func (l *LogView) Capacity() int {
 	return l.document.Capacity()
 }
@@ -196,7 +227,58 @@ func (l *LogView) SetCapacity(c int) {
 }
 
 func (l *LogView) AddLine(line string) {
-	l.document.Add(line)
+	l.AddLines(doc.Line{Text: line})
+}
+
+// AddLines adds lines along with their metadata and styled spans. The highlighter, if
+// any, styles the lines first.
+func (l *LogView) AddLines(lines ...doc.Line) {
+	if h := l.Highlighter(); h != nil {
+		lines = slices.Clone(lines)
+		for i := range lines {
+			lines[i].Attrs.Spans = h.Highlight(lines[i])
+		}
+	}
+	l.document.AddLines(lines...)
+}
+
+// maxPendingChanges limits the changes of the document kept for rendering. If there are
+// more, the whole document is rendered anew.
+const maxPendingChanges = 1000
+
+func (l *LogView) trackChange(c doc.Change) {
+	l.changesLock.Lock()
+	defer l.changesLock.Unlock()
+	if l.resync {
+		return
+	}
+	if len(l.changes) == maxPendingChanges {
+		l.changes, l.resync = nil, true
+		return
+	}
+	l.changes = append(l.changes, c)
+}
+
+// takeChanges returns the changes of the document since the previous call. If the changes
+// were not tracked, or if all is set, it returns a single change with all lines of the
+// document instead, and reset is true.
+func (l *LogView) takeChanges(all bool) (changes []doc.Change, reset bool) {
+	l.changesLock.Lock()
+	if !all && !l.resync {
+		defer l.changesLock.Unlock()
+		changes, l.changes = l.changes, nil
+		return changes, false
+	}
+	l.changesLock.Unlock()
+
+	// the document is locked while reading, so no changes can be missed or repeated
+	l.document.ReadAttrs(func(lines []string, attrs []doc.Attrs) {
+		l.changesLock.Lock()
+		defer l.changesLock.Unlock()
+		changes = []doc.Change{{Lines: slices.Clone(lines), Attrs: slices.Clone(attrs)}}
+		l.changes, l.resync = nil, false
+	})
+	return changes, true
 }
 
 func (l *LogView) requestFocus() {
@@ -232,17 +314,95 @@ func (l *LogView) showContextMenu(absolutePos fyne.Position) {
 		},
 	}
 
+	findItem := &fyne.MenuItem{
+		Label:    "Find",
+		Shortcut: shortcutFind,
+		Action: func() {
+			l.shortcutHandler.TypedShortcut(shortcutFind)
+		},
+	}
+
 	selStart, haveSelStart := l.document.GetBookmark(bookmarkSelectionStart)
 	selEnd, haveSelEnd := l.document.GetBookmark(bookmarkSelectionEnd)
 	copyItem.Disabled = !haveSelStart || !haveSelEnd || selStart.Compare(selEnd) == 0
 
-	menu := fyne.NewMenu("", copyItem, selectAllItem, wordWrapItem)
+	menu := fyne.NewMenu("", copyItem, selectAllItem, findItem, wordWrapItem)
 
 	cv := driver.CanvasForObject(l)
 	popup := widget.NewPopUpMenu(menu, cv)
 	popup.ShowAtPosition(absolutePos)
 }
 
+// searchPattern returns the pattern of the find bar, or nil if there is none.
+func (l *LogView) searchPattern() *regexp.Regexp {
+	l.propertyLock.RLock()
+	defer l.propertyLock.RUnlock()
+	return l.search
+}
+
+// setSearch sets the pattern of the find bar, see compileSearch. If the pattern is
+// invalid, setSearch clears it and returns the error.
+func (l *LogView) setSearch(text string, regex, caseSensitive bool) error {
+	re, err := compileSearch(text, regex, caseSensitive)
+	l.propertyLock.Lock()
+	defer l.propertyLock.Unlock()
+	l.search = re
+	return err
+}
+
+// findNext selects the next or previous match of the search pattern, starting at the
+// selection or at the top of the view, and scrolls the match into view. It returns false
+// if there is no match.
+func (l *LogView) findNext(backward bool) bool {
+	re := l.searchPattern()
+	if re == nil {
+		return false
+	}
+
+	var from doc.Anchor
+	if selStart, selEnd, ok := l.selection(); ok {
+		from = selEnd
+		if backward {
+			from = selStart
+		}
+	} else if top, ok := l.document.GetBookmark(bookmarkViewTop); ok {
+		from = top
+	} else if backward {
+		from, _ = l.document.GetBookmark(doc.BookmarkEnd)
+	}
+
+	var start, end doc.Anchor
+	var ok bool
+	l.document.Read(func(lines []string) {
+		start, end, ok = findMatch(lines, re, from, backward)
+	})
+	if !ok {
+		return false
+	}
+	l.document.SetBookmark(bookmarkSelectionStart, start)
+	l.document.SetBookmark(bookmarkSelectionEnd, end)
+	l.Refresh()
+
+	if box, ok := l.canvas.getBoxAtAnchor(start).(*TextBox); ok {
+		p := box.Position()
+		p.X += box.CharToX(start.LineOffset - box.StartAnchor().LineOffset)
+		l.scrollPointToVisible(p.Add(fyne.NewPos(0, box.Size().Height)))
+		l.scrollPointToVisible(p)
+		l.Refresh()
+	}
+	return true
+}
+
+// selection returns the ordered bounds of the selection, if there is a non-empty one.
+func (l *LogView) selection() (start, end doc.Anchor, ok bool) {
+	start, haveStart := l.document.GetBookmark(bookmarkSelectionStart)
+	end, haveEnd := l.document.GetBookmark(bookmarkSelectionEnd)
+	if start.Compare(end) > 0 {
+		start, end = end, start
+	}
+	return start, end, haveStart && haveEnd && start != end
+}
+
 func (l *LogView) scrollPointToVisible(p fyne.Position) {
 	startOffset, viewSize, canvasSize := l.scroller.Offset, l.scroller.Size(), l.canvas.Size()
 	var newOffset fyne.Position
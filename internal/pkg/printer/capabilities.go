@@ -0,0 +1,94 @@
+package printer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Capability names reported by Marlin in "Cap:NAME:0/1" lines of the M115 response.
+const (
+	CapAutoReportTemp     = "AUTOREPORT_TEMP"
+	CapAutoReportPos      = "AUTOREPORT_POS"
+	CapAutoReportSDStatus = "AUTOREPORT_SD_STATUS"
+	CapEmergencyParser    = "EMERGENCY_PARSER"
+	CapHostActionCommands = "HOST_ACTION_COMMANDS"
+	CapSDCard             = "SDCARD"
+	CapLongFilename       = "LONG_FILENAME"
+	CapExtendedM20        = "EXTENDED_M20"
+	CapEEPROM             = "EEPROM"
+	CapThermalProtection  = "THERMAL_PROTECTION"
+)
+
+// Capabilities describe the firmware as reported in response to M115.
+type Capabilities struct {
+	// FirmwareName is the name and usually the version of the firmware,
+	// e.g. "Marlin 2.1.2 (Github)".
+	FirmwareName string
+
+	// FirmwareVersion is the firmware version if reported separately from the name,
+	// as done by RepRapFirmware and Klipper.
+	FirmwareVersion string
+
+	// ProtocolVersion is the version of the communication protocol, e.g. "1.0".
+	ProtocolVersion string
+
+	// MachineType is the printer model configured in the firmware.
+	MachineType string
+
+	// ExtruderCount is the number of extruders, or zero if not reported.
+	ExtruderCount int
+
+	// Fields contains all KEY:value pairs of the firmware information line,
+	// including the ones above.
+	Fields map[string]string
+
+	// Caps contains the capabilities reported in "Cap:NAME:0/1" lines.
+	Caps map[string]bool
+}
+
+var capabilityKeyRe = regexp.MustCompile(`\b[A-Z][A-Z0-9_]*:`)
+
+// ParseCapabilities parses the response to M115. Lines that are not part of the firmware
+// information, such as "ok" or temperature reports, are ignored.
+func ParseCapabilities(lines []string) Capabilities {
+	c := Capabilities{
+		Fields: make(map[string]string),
+		Caps:   make(map[string]bool),
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "ok "))
+		if capability, ok := strings.CutPrefix(line, "Cap:"); ok {
+			name, value, _ := strings.Cut(capability, ":")
+			c.Caps[name] = strings.TrimSpace(value) == "1"
+		} else if strings.HasPrefix(line, "FIRMWARE_NAME:") {
+			parseCapabilityFields(line, c.Fields)
+		}
+	}
+
+	c.FirmwareName = c.Fields["FIRMWARE_NAME"]
+	c.FirmwareVersion = c.Fields["FIRMWARE_VERSION"]
+	c.ProtocolVersion = c.Fields["PROTOCOL_VERSION"]
+	c.MachineType = c.Fields["MACHINE_TYPE"]
+	c.ExtruderCount, _ = strconv.Atoi(c.Fields["EXTRUDER_COUNT"])
+	return c
+}
+
+// parseCapabilityFields splits a line like "FIRMWARE_NAME:Marlin 2.1 EXTRUDER_COUNT:1"
+// into KEY:value pairs. Values may contain spaces.
+func parseCapabilityFields(line string, fields map[string]string) {
+	keys := capabilityKeyRe.FindAllStringIndex(line, -1)
+	for i, k := range keys {
+		end := len(line)
+		if i+1 < len(keys) {
+			end = keys[i+1][0]
+		}
+		fields[line[k[0]:k[1]-1]] = strings.TrimSpace(line[k[1]:end])
+	}
+}
+
+// Has reports whether the firmware has the given capability enabled.
+func (c Capabilities) Has(capability string) bool {
+	return c.Caps[capability]
+}
@@ -0,0 +1,149 @@
+package printer
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  Capabilities
+	}{
+		{
+			name: "Marlin",
+			lines: []string{
+				"FIRMWARE_NAME:Marlin 2.1.2 (Sep  5 2023 12:00:00) SOURCE_CODE_URL:https://github.com/MarlinFirmware/Marlin " +
+					"PROTOCOL_VERSION:1.0 MACHINE_TYPE:Ender-3 V2 EXTRUDER_COUNT:1 UUID:cede2a2f-41a2-4748-9b12-c55c62f367ff",
+				"Cap:SERIAL_XON_XOFF:0",
+				"Cap:AUTOREPORT_TEMP:1",
+				"Cap:EMERGENCY_PARSER:0",
+				"ok",
+			},
+			want: Capabilities{
+				FirmwareName:    "Marlin 2.1.2 (Sep  5 2023 12:00:00)",
+				ProtocolVersion: "1.0",
+				MachineType:     "Ender-3 V2",
+				ExtruderCount:   1,
+				Fields: map[string]string{
+					"FIRMWARE_NAME":    "Marlin 2.1.2 (Sep  5 2023 12:00:00)",
+					"SOURCE_CODE_URL":  "https://github.com/MarlinFirmware/Marlin",
+					"PROTOCOL_VERSION": "1.0",
+					"MACHINE_TYPE":     "Ender-3 V2",
+					"EXTRUDER_COUNT":   "1",
+					"UUID":             "cede2a2f-41a2-4748-9b12-c55c62f367ff",
+				},
+				Caps: map[string]bool{
+					"SERIAL_XON_XOFF":  false,
+					"AUTOREPORT_TEMP":  true,
+					"EMERGENCY_PARSER": false,
+				},
+			},
+		},
+		{
+			name: "RepRapFirmware",
+			lines: []string{
+				"FIRMWARE_NAME: RepRapFirmware for Duet 2 WiFi/Ethernet FIRMWARE_VERSION: 3.4.5 " +
+					"ELECTRONICS: Duet WiFi 1.02 or later FIRMWARE_DATE: 2022-11-30 19:36:12",
+				"ok",
+			},
+			want: Capabilities{
+				FirmwareName:    "RepRapFirmware for Duet 2 WiFi/Ethernet",
+				FirmwareVersion: "3.4.5",
+				Fields: map[string]string{
+					"FIRMWARE_NAME":    "RepRapFirmware for Duet 2 WiFi/Ethernet",
+					"FIRMWARE_VERSION": "3.4.5",
+					"ELECTRONICS":      "Duet WiFi 1.02 or later",
+					"FIRMWARE_DATE":    "2022-11-30 19:36:12",
+				},
+				Caps: map[string]bool{},
+			},
+		},
+		{
+			name: "Repetier",
+			lines: []string{
+				"FIRMWARE_NAME:Repetier_1.0.4 FIRMWARE_URL:https://github.com/repetier/Repetier-Firmware/ " +
+					"PROTOCOL_VERSION:1.0 MACHINE_TYPE:Mendel EXTRUDER_COUNT:2 REPETIER_PROTOCOL:3",
+				"Cap:AUTOREPORT_TEMP:1",
+				"ok",
+			},
+			want: Capabilities{
+				FirmwareName:    "Repetier_1.0.4",
+				ProtocolVersion: "1.0",
+				MachineType:     "Mendel",
+				ExtruderCount:   2,
+				Fields: map[string]string{
+					"FIRMWARE_NAME":     "Repetier_1.0.4",
+					"FIRMWARE_URL":      "https://github.com/repetier/Repetier-Firmware/",
+					"PROTOCOL_VERSION":  "1.0",
+					"MACHINE_TYPE":      "Mendel",
+					"EXTRUDER_COUNT":    "2",
+					"REPETIER_PROTOCOL": "3",
+				},
+				Caps: map[string]bool{"AUTOREPORT_TEMP": true},
+			},
+		},
+		{
+			name:  "Klipper",
+			lines: []string{"ok FIRMWARE_NAME:Klipper FIRMWARE_VERSION:v0.11.0-257"},
+			want: Capabilities{
+				FirmwareName:    "Klipper",
+				FirmwareVersion: "v0.11.0-257",
+				Fields: map[string]string{
+					"FIRMWARE_NAME":    "Klipper",
+					"FIRMWARE_VERSION": "v0.11.0-257",
+				},
+				Caps: map[string]bool{},
+			},
+		},
+		{
+			name:  "Empty",
+			lines: []string{"ok"},
+			want:  Capabilities{Fields: map[string]string{}, Caps: map[string]bool{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCapabilities(tt.lines)
+			if got.FirmwareName != tt.want.FirmwareName ||
+				got.FirmwareVersion != tt.want.FirmwareVersion ||
+				got.ProtocolVersion != tt.want.ProtocolVersion ||
+				got.MachineType != tt.want.MachineType ||
+				got.ExtruderCount != tt.want.ExtruderCount {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+			if !maps.Equal(got.Fields, tt.want.Fields) {
+				t.Errorf("Fields: want %q, got %q", tt.want.Fields, got.Fields)
+			}
+			if !maps.Equal(got.Caps, tt.want.Caps) {
+				t.Errorf("Caps: want %v, got %v", tt.want.Caps, got.Caps)
+			}
+		})
+	}
+}
+
+func TestRepRap_Capabilities(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Extruders: 2})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+
+	select {
+	case <-p.Identified():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for capabilities")
+	}
+
+	c, ok := p.Capabilities()
+	if !ok {
+		t.Fatalf("Capabilities not known after Identified")
+	}
+	if c.ExtruderCount != 2 {
+		t.Errorf("ExtruderCount: want 2, got %v", c.ExtruderCount)
+	}
+	if !c.Has(CapAutoReportTemp) || c.Has(CapEEPROM) {
+		t.Errorf("Unexpected caps %v", c.Caps)
+	}
+}
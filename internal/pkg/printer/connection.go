@@ -2,12 +2,14 @@ package printer
 
 import (
 	"bufio"
-	"fmt"
 	"io"
-	"regexp"
 )
 
-func OpenFake() (io.ReadWriteCloser, error) {
+// OpenFake creates a connection to a simulated printer. The simulator imitates Marlin
+// firmware: it validates line numbers and checksums, models heating, movement and an SD
+// card, and responds to common commands such as M105, M114 and M115. See FakeConfig for
+// ways to customize it.
+func OpenFake(config FakeConfig) (io.ReadWriteCloser, error) {
 	inReader, inWriter := io.Pipe()
 	outReader, outWriter := io.Pipe()
 	c := connection{
@@ -16,7 +18,7 @@ func OpenFake() (io.ReadWriteCloser, error) {
 		outReader: outReader,
 		outWriter: outWriter,
 	}
-	go c.run()
+	go c.run(newFakeFirmware(config, outWriter))
 	return &c, nil
 }
 
@@ -27,21 +29,22 @@ type connection struct {
 	outWriter *io.PipeWriter
 }
 
-func (c *connection) run() {
-	s := bufio.NewScanner(c.inReader)
-	m20Re := regexp.MustCompile(`^[mM]20\b`)
-	for s.Scan() {
-		t := s.Text()
-		switch {
-		case m20Re.MatchString(t):
-			fmt.Fprintln(c.outWriter, "Begin file list:")
-			fmt.Fprintln(c.outWriter, "Foo bar baz.gcode")
-			fmt.Fprintln(c.outWriter, "End file list")
-			fmt.Fprintln(c.outWriter, "ok")
-		default:
-			fmt.Fprintln(c.outWriter, "ok")
+func (c *connection) run(f *fakeFirmware) {
+	// lines models the receive buffer so that reading never blocks the host
+	lines := make(chan string, 1024)
+	go func() {
+		s := bufio.NewScanner(c.inReader)
+		for n := 0; s.Scan(); n++ {
+			t := s.Text()
+			if f.config.Corrupt != nil {
+				t = f.config.Corrupt(n, t)
+			}
+			f.emergency(t)
+			lines <- t
 		}
-	}
+		close(lines)
+	}()
+	f.run(lines)
 	c.outWriter.Close()
 }
 
@@ -54,5 +57,6 @@ func (c *connection) Write(p []byte) (int, error) {
 }
 
 func (c *connection) Close() error {
+	c.outReader.Close()
 	return c.inWriter.Close()
 }
@@ -3,13 +3,17 @@ package printer
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpenFake(t *testing.T) {
 	var err error
 
-	c, err := OpenFake()
+	c, err := OpenFake(FakeConfig{})
 
 	if err != nil {
 		t.Fatalf("OpenFake failed: %v", err)
@@ -37,3 +41,254 @@ func TestOpenFake(t *testing.T) {
 
 	c.Close()
 }
+
+// fakeSession talks to a simulated printer directly, without RepRap.
+type fakeSession struct {
+	t     *testing.T
+	conn  io.ReadWriteCloser
+	lines chan string
+}
+
+func openFakeSession(t *testing.T, config FakeConfig) *fakeSession {
+	c, err := OpenFake(config)
+	if err != nil {
+		t.Fatalf("OpenFake failed: %v", err)
+	}
+	s := &fakeSession{t: t, conn: c, lines: make(chan string, 1024)}
+	go func() {
+		sc := bufio.NewScanner(c)
+		for sc.Scan() {
+			s.lines <- sc.Text()
+		}
+		close(s.lines)
+	}()
+	t.Cleanup(func() { c.Close() })
+	return s
+}
+
+// exchange sends a line and returns all responses up to and including "ok".
+func (s *fakeSession) exchange(line string) []string {
+	s.t.Helper()
+	if _, err := fmt.Fprintln(s.conn, line); err != nil {
+		s.t.Fatalf("Write failed: %v", err)
+	}
+	var r []string
+	for {
+		l, ok := s.next(5 * time.Second)
+		if !ok {
+			s.t.Fatalf("%v: no ok, got %q", line, r)
+		}
+		r = append(r, l)
+		if isOk(l) {
+			return r
+		}
+	}
+}
+
+func (s *fakeSession) next(timeout time.Duration) (string, bool) {
+	select {
+	case l, ok := <-s.lines:
+		return l, ok
+	case <-time.After(timeout):
+		return "", false
+	}
+}
+
+func TestOpenFake_LineValidation(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{})
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{
+			name: "Valid",
+			line: strings.TrimSpace(formatLine(1, "G90")),
+			want: []string{"ok"},
+		},
+		{
+			name: "Checksum",
+			line: "N2 G90*0",
+			want: []string{"Error:checksum mismatch, Last Line: 1", "Resend: 2", "ok"},
+		},
+		{
+			name: "LineNumber",
+			line: strings.TrimSpace(formatLine(3, "G90")),
+			want: []string{"Error:Line Number is not Last Line Number+1, Last Line: 1", "Resend: 2", "ok"},
+		},
+		{
+			name: "NoChecksum",
+			line: "N2 G90",
+			want: []string{"Error:No Checksum with line number, Last Line: 1", "Resend: 2", "ok"},
+		},
+		{
+			name: "Reset",
+			line: strings.TrimSpace(formatLine(10, "M110 N10")),
+			want: []string{"ok"},
+		},
+		{
+			name: "AfterReset",
+			line: strings.TrimSpace(formatLine(11, "G90")),
+			want: []string{"ok"},
+		},
+		{
+			name: "Unknown",
+			line: "FOO",
+			want: []string{"echo:Unknown command: \"FOO\"", "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.exchange(tt.line); !slices.Equal(got, tt.want) {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOpenFake_Temperature(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{Speed: 1000, HeatingTime: time.Second})
+
+	if got, want := s.exchange("M105"), "ok T:25.00 /0.00 B:25.00 /0.00 @:0 B@:0"; got[0] != want {
+		t.Errorf("M105: want %q, got %q", want, got[0])
+	}
+
+	r := s.exchange("M109 S200")
+	if len(r) < 2 || !strings.HasSuffix(r[0], "/200.00 B:25.00 /0.00 @:127 B@:0 W:?") {
+		t.Errorf("M109: want temperature reports, got %q", r)
+	}
+
+	r = s.exchange("M190 S60")
+	if len(r) < 2 || !strings.Contains(r[0], "B:") || !strings.Contains(r[0], "/60.00") {
+		t.Errorf("M190: want temperature reports, got %q", r)
+	}
+
+	var hotend, bed float64
+	if _, err := fmt.Sscanf(s.exchange("M105")[0], "ok T:%f /200.00 B:%f /60.00", &hotend, &bed); err != nil {
+		t.Fatalf("M105: %v", err)
+	}
+	if hotend < 199 || bed < 59 {
+		t.Errorf("M105: want T >= 199 and B >= 59, got %v and %v", hotend, bed)
+	}
+}
+
+func TestOpenFake_AutoReport(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{Speed: 100})
+	s.exchange("M155 S1")
+	for i := 0; i < 3; i++ {
+		l, ok := s.next(time.Second)
+		if !ok || !strings.HasPrefix(l, " T:") {
+			t.Fatalf("Want a temperature report, got %q", l)
+		}
+	}
+}
+
+func TestOpenFake_Position(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{Speed: 100})
+
+	r := s.exchange("G28")
+	if want := []string{"echo:busy: processing", "ok"}; !slices.Equal(r, want) {
+		t.Errorf("G28: want %q, got %q", want, r)
+	}
+	for _, cmd := range []string{"G1 X10 Y20 Z5 F3000", "G91", "G1 X5 E2", "G1 E3", "G90", "G92 E0"} {
+		s.exchange(cmd)
+	}
+	if got, want := s.exchange("M114")[0], "X:15.00 Y:20.00 Z:5.00 E:0.00 Count X:1200 Y:1600 Z:2000"; got != want {
+		t.Errorf("M114: want %q, got %q", want, got)
+	}
+}
+
+func TestOpenFake_FirmwareInfo(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{Extruders: 2})
+	r := s.exchange("M115")
+	if !strings.HasPrefix(r[0], "FIRMWARE_NAME:Marlin") || !strings.Contains(r[0], "EXTRUDER_COUNT:2") {
+		t.Errorf("M115: unexpected firmware info %q", r[0])
+	}
+	if !slices.Contains(r, "Cap:AUTOREPORT_TEMP:1") {
+		t.Errorf("M115: no AUTOREPORT_TEMP capability in %q", r)
+	}
+}
+
+func TestOpenFake_SDCard(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{Speed: 100, Files: []FakeFile{}})
+
+	steps := []struct {
+		line string
+		want []string
+	}{
+		{line: "M20", want: []string{"Begin file list", "End file list", "ok"}},
+		{line: "M28 test.gco", want: []string{"Writing to file: TEST.GCO", "ok"}},
+		{line: "G28", want: []string{"ok"}},
+		{line: "G1 X10", want: []string{"ok"}},
+		{line: "M29", want: []string{"Done saving file.", "ok"}},
+		{line: "M20 L", want: []string{"Begin file list", "TEST.GCO 11", "End file list", "ok"}},
+		{line: "M23 TEST.GCO", want: []string{"File opened: TEST.GCO Size: 11", "File selected", "ok"}},
+		{line: "M27", want: []string{"Not SD printing", "ok"}},
+		{line: "M24", want: []string{"ok"}},
+	}
+	for _, step := range steps {
+		if got := s.exchange(step.line); !slices.Equal(got, step.want) {
+			t.Errorf("%v: want %q, got %q", step.line, step.want, got)
+		}
+	}
+
+	if l, ok := s.next(time.Second); l != "Done printing file" {
+		t.Errorf("Want Done printing file, got %q, %v", l, ok)
+	}
+
+	if got, want := s.exchange("M27"), []string{"SD printing byte 11/11", "ok"}; !slices.Equal(got, want) {
+		t.Errorf("M27: want %q, got %q", want, got)
+	}
+	if got, want := s.exchange("M30 TEST.GCO"), []string{"File deleted:TEST.GCO", "ok"}; !slices.Equal(got, want) {
+		t.Errorf("M30: want %q, got %q", want, got)
+	}
+	if got, want := s.exchange("M23 TEST.GCO"), []string{"open failed, File: TEST.GCO.", "ok"}; !slices.Equal(got, want) {
+		t.Errorf("M23: want %q, got %q", want, got)
+	}
+}
+
+func TestOpenFake_Script(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{
+		Script: func(command string) ([]string, bool) {
+			if command == "M999" {
+				return []string{"echo:Scripted", "ok"}, true
+			}
+			return nil, false
+		},
+	})
+
+	if got, want := s.exchange("M999"), []string{"echo:Scripted", "ok"}; !slices.Equal(got, want) {
+		t.Errorf("M999: want %q, got %q", want, got)
+	}
+	if got, want := s.exchange("G90"), []string{"ok"}; !slices.Equal(got, want) {
+		t.Errorf("G90: want %q, got %q", want, got)
+	}
+}
+
+func TestOpenFake_Corrupt(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{
+		Corrupt: func(n int, line string) string {
+			return strings.Replace(line, "X", "Y", 1)
+		},
+	})
+
+	got := s.exchange(strings.TrimSpace(formatLine(1, "G1 X10")))
+	if want := "Resend: 1"; !slices.Contains(got, want) {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+}
+
+func TestOpenFake_Halt(t *testing.T) {
+	s := openFakeSession(t, FakeConfig{})
+
+	fmt.Fprintln(s.conn, "M112")
+	if l, _ := s.next(time.Second); l != "Error:Printer halted. kill() called!" {
+		t.Errorf("M112: unexpected response %q", l)
+	}
+	fmt.Fprintln(s.conn, "M105")
+	if l, ok := s.next(100 * time.Millisecond); ok {
+		t.Errorf("Want no response after halt, got %q", l)
+	}
+}
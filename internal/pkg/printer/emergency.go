@@ -0,0 +1,102 @@
+package printer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ErrCancelled is the error of a response to a queued command that was discarded by
+// EmergencyStop or Quickstop before it was acknowledged.
+var ErrCancelled = errors.New("printer: command cancelled")
+
+// EmergencyStop sends M112 ahead of all queued commands, and discards the queue and the
+// lines waiting for resending. The firmware turns off the heaters and motors at once and
+// halts, so that it must be reset before it accepts commands again.
+func (p *RepRap) EmergencyStop() error {
+	return p.sendImmediately("M112", true)
+}
+
+// Quickstop sends M410 ahead of all queued commands, and discards the queue and the lines
+// waiting for resending. The firmware stops all moves at once, without deceleration, and
+// the position is lost.
+func (p *RepRap) Quickstop() error {
+	return p.sendImmediately("M410", true)
+}
+
+// CancelWait sends M108 ahead of all queued commands. The firmware stops waiting for the
+// heaters, as in M109 or M190, or for the user, as in M0. The queue is kept.
+func (p *RepRap) CancelWait() error {
+	return p.sendImmediately("M108", false)
+}
+
+// sendImmediately writes a command without a line number directly to the connection,
+// ahead of the queued commands and of the lines waiting for resending. Firmwares with the
+// EMERGENCY_PARSER capability execute such a command as soon as it is received, even while
+// busy, and acknowledge it later in turn with the other lines. Other firmwares execute it
+// after the lines sent before. If clearQueue is set, queued commands and lines waiting for
+// resending are completed with ErrCancelled, and their cancel handlers are called.
+func (p *RepRap) sendImmediately(command string, clearQueue bool) error {
+	text := command + "\n"
+
+	p.lock.Lock()
+	if p.err != nil {
+		defer p.lock.Unlock()
+		return p.err
+	}
+	var cancelled []*queueEntry
+	if clearQueue {
+		cancelled = p.discard()
+	}
+	// M112 halts the firmware, so it is never acknowledged
+	if command != "M112" {
+		p.inFlight = append(p.inFlight, &transmission{entry: &queueEntry{
+			cmd:  NewCommand(command, nil),
+			line: -1,
+			text: text,
+		}})
+		p.inFlightBytes += len(text)
+		if len(p.inFlight) == 1 {
+			p.armTimeout()
+		}
+	}
+	p.writeLock.Lock()
+	p.lock.Unlock()
+
+	p.events.publish(LineSent{Line: command})
+	_, err := io.WriteString(p.conn, text)
+	p.writeLock.Unlock()
+
+	for _, e := range cancelled {
+		if e.cmd.cancelHandler != nil {
+			e.cmd.cancelHandler()
+		}
+	}
+	if err != nil {
+		p.fail(err)
+		return fmt.Errorf("printer: %w", err)
+	}
+	return nil
+}
+
+// discard completes the queued commands and the lines waiting for resending with
+// ErrCancelled, and returns them. The firmware still expects the first line to be resent
+// next, so its number goes to the next command. discard assumes a lock.
+func (p *RepRap) discard() []*queueEntry {
+	cancelled := append(slices.Clone(p.resend), p.queue...)
+	for _, e := range cancelled {
+		e.complete(ErrCancelled)
+	}
+	if len(p.resend) > 0 {
+		p.lastLine = p.resend[0].line - 1
+		n := len(p.history) - len(p.resend)
+		clear(p.history[n:])
+		p.history = p.history[:n]
+	}
+	clear(p.resend)
+	p.resend = p.resend[:0]
+	clear(p.queue)
+	p.queue = p.queue[:0]
+	return cancelled
+}
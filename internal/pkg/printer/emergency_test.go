@@ -0,0 +1,148 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectLine starts watching the lines received by the printer, and returns a function
+// which waits until a line matching the condition is received.
+func expectLine(t *testing.T, p *RepRap, condition func(string) bool) (wait func()) {
+	lines := make(chan string, 1)
+	unsubscribe := p.Subscribe(func(e Event) {
+		if e, ok := e.(LineReceived); ok && condition(e.Line) {
+			select {
+			case lines <- e.Line:
+			default:
+			}
+		}
+	})
+	return func() {
+		t.Helper()
+		defer unsubscribe()
+		select {
+		case <-lines:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a line")
+		}
+	}
+}
+
+func TestRepRap_EmergencyStop(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Speed: 10})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	<-p.Identified()
+
+	waitBusy := expectLine(t, p, isBusy)
+	waitHalted := expectLine(t, p, func(l string) bool { return l == "Error:Printer halted. kill() called!" })
+	first, _ := p.Request("G4 S10")
+	queued, _ := p.Request("M105")
+	waitBusy()
+	if err := p.EmergencyStop(); err != nil {
+		t.Fatalf("EmergencyStop: %v", err)
+	}
+	if _, err := queued.Wait(context.Background()); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Queued command: want ErrCancelled, got %v", err)
+	}
+	waitHalted()
+	var halt *HaltError
+	if _, err := first.Wait(context.Background()); !errors.As(err, &halt) {
+		t.Errorf("Command in flight: want HaltError, got %v", err)
+	}
+}
+
+func TestRepRap_Quickstop(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Speed: 10})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	ctx := context.Background()
+	<-p.Identified()
+
+	// homing takes 3 s of simulated time, and reports busy every 2 s
+	waitBusy := expectLine(t, p, isBusy)
+	homing, _ := p.Request("G28")
+	queued, _ := p.Request("G1 X10")
+	waitBusy()
+	if err := p.Quickstop(); err != nil {
+		t.Fatalf("Quickstop: %v", err)
+	}
+	if _, err := queued.Wait(ctx); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Queued command: want ErrCancelled, got %v", err)
+	}
+	if _, err := homing.Wait(ctx); err != nil {
+		t.Errorf("Homing: %v", err)
+	}
+
+	// the acknowledgement of M410 does not go to the next command
+	r, err := p.SendContext(ctx, "M114")
+	if err != nil || len(r.Lines) != 2 || !strings.HasPrefix(r.Lines[0], "X:") {
+		t.Errorf("SendContext after a quickstop: %q, %v", r.Lines, err)
+	}
+}
+
+func TestRepRap_Discard(t *testing.T) {
+	// lines 4 and 5 wait for resending, and line 6 is queued
+	p := &RepRap{lastLine: 5}
+	for line := 1; line <= 6; line++ {
+		e := &queueEntry{cmd: Command{command: "G4", future: newFuture()}, line: line}
+		if line <= 5 {
+			e.text = formatLine(line, "G4")
+			p.history = append(p.history, e)
+		} else {
+			p.queue = append(p.queue, e)
+		}
+	}
+	p.resend = slices.Clone(p.history[3:])
+	entries := append(slices.Clone(p.history), p.queue...)
+
+	cancelled := p.discard()
+	if !slices.Equal(cancelled, entries[3:]) {
+		t.Errorf("Cancelled: want lines 4 to 6, got %d entries", len(cancelled))
+	}
+	for _, e := range entries {
+		err := ErrCancelled
+		if e.line <= 3 {
+			err = nil
+		}
+		if done := e.cmd.future.isDone(); done != (err != nil) || done && !errors.Is(e.cmd.future.response.Err, err) {
+			t.Errorf("Line %d: done %v, response %+v", e.line, done, e.cmd.future.response)
+		}
+	}
+	// the firmware expects line 4, so the next command gets its number
+	if p.lastLine != 3 || len(p.history) != 3 || len(p.resend) != 0 || len(p.queue) != 0 {
+		t.Errorf("Unexpected state: last line %d, history %d, resend %d, queue %d",
+			p.lastLine, len(p.history), len(p.resend), len(p.queue))
+	}
+}
+
+func TestRepRap_CancelWait(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Speed: 10, HeatingTime: time.Hour})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	ctx := context.Background()
+	<-p.Identified()
+
+	waitHeating := expectLine(t, p, func(l string) bool { return strings.HasSuffix(l, "W:?") })
+	heating, _ := p.Request("M109 S200")
+	queued, _ := p.Request("M104 S0")
+	waitHeating()
+	if err := p.CancelWait(); err != nil {
+		t.Fatalf("CancelWait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := heating.Wait(ctx); err != nil {
+		t.Errorf("Heating: %v", err)
+	}
+	if _, err := queued.Wait(ctx); err != nil {
+		t.Errorf("Queued command: %v", err)
+	}
+	if _, err := p.SendContext(ctx, "M105"); err != nil {
+		t.Errorf("SendContext after cancelling the wait: %v", err)
+	}
+}
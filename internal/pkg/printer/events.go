@@ -0,0 +1,73 @@
+package printer
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Event is a notification about printer activity. Use a type switch to tell events apart.
+type Event interface {
+	event()
+}
+
+// LineSent is published when a line is written to the printer. Line is the text
+// as sent, including the line number and checksum, but without the EOL.
+type LineSent struct {
+	Line string
+}
+
+// LineReceived is published for every non-empty line received from the printer,
+// regardless of which command, if any, it belongs to.
+type LineReceived struct {
+	Line string
+}
+
+// Halted is published once when the firmware reports that it has halted. Message is the
+// line that reported it. Communication stops with a HaltError.
+type Halted struct {
+	Message string
+}
+
+func (LineSent) event()     {}
+func (LineReceived) event() {}
+func (Halted) event()       {}
+
+// broadcaster delivers values to a dynamic list of subscribers. Subscribers are called
+// synchronously and must not block.
+type broadcaster[T any] struct {
+	subscribers atomic.Value
+	writeLock   sync.Mutex
+}
+
+type subscriber[T any] struct {
+	handler func(T)
+}
+
+// subscribe adds a handler and returns a function that removes it.
+func (b *broadcaster[T]) subscribe(handler func(T)) (unsubscribe func()) {
+	s := &subscriber[T]{handler: handler}
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+	b.subscribers.Store(append(slices.Clone(b.get()), s))
+
+	return func() {
+		b.writeLock.Lock()
+		defer b.writeLock.Unlock()
+		b.subscribers.Store(slices.DeleteFunc(slices.Clone(b.get()), func(x *subscriber[T]) bool {
+			return x == s
+		}))
+	}
+}
+
+func (b *broadcaster[T]) publish(v T) {
+	for _, s := range b.get() {
+		s.handler(v)
+	}
+}
+
+func (b *broadcaster[T]) get() []*subscriber[T] {
+	s, _ := b.subscribers.Load().([]*subscriber[T])
+	return s
+}
@@ -0,0 +1,74 @@
+package printer
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrHeld is returned by RepRap.Acquire when the printer is already held.
+var ErrHeld = errors.New("printer: held by another sender")
+
+// Exclusive is a printer held for exclusive use, see RepRap.Acquire.
+type Exclusive struct {
+	printer *RepRap
+}
+
+var _ Printer = (*Exclusive)(nil)
+
+// Acquire holds the printer for exclusive use until Release is called. Meanwhile only the
+// commands sent through the returned Exclusive are sent. Commands sent directly to the
+// printer, e.g. by TemperatureMonitor, a Job or the user, wait in the queue until the
+// printer is released. Lines requested for resending, and the commands of EmergencyStop,
+// Quickstop and CancelWait are sent regardless.
+//
+// Acquire returns ErrHeld if the printer is already held.
+func (p *RepRap) Acquire() (*Exclusive, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.holder != nil {
+		return nil, ErrHeld
+	}
+	p.holder = &Exclusive{printer: p}
+	return p.holder, nil
+}
+
+// Release ends the exclusive use of the printer, and the commands of others are sent
+// again. Commands sent through e afterwards are queued like the commands of others.
+// Further calls have no effect.
+func (e *Exclusive) Release() {
+	p := e.printer
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.holder == e {
+		p.holder = nil
+		p.cond.Broadcast()
+	}
+}
+
+// Send queues a command like RepRap.Send, ahead of the commands of others.
+func (e *Exclusive) Send(cmd Command) error {
+	return e.printer.send(cmd, e)
+}
+
+// Request queues a command like RepRap.Request, ahead of the commands of others.
+func (e *Exclusive) Request(command string) (*Future, error) {
+	return e.printer.request(command, e)
+}
+
+// SendContext queues a command like RepRap.SendContext, ahead of the commands of others.
+func (e *Exclusive) SendContext(ctx context.Context, command string) (Response, error) {
+	return e.printer.sendContext(ctx, command, e)
+}
+
+// Done returns the channel of the held printer, see RepRap.Done.
+func (e *Exclusive) Done() <-chan struct{} {
+	return e.printer.Done()
+}
+
+// Err returns the error of the held printer, see RepRap.Err.
+func (e *Exclusive) Err() error {
+	return e.printer.Err()
+}
@@ -0,0 +1,708 @@
+package printer
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeConfig configures the simulated printer created by OpenFake.
+// Zero values select reasonable defaults.
+type FakeConfig struct {
+	// Speed is the speed of the simulated time relative to the real time. For example,
+	// with Speed set to 10 a minute of heating is simulated in 6 seconds. The default is 1.
+	Speed float64
+
+	// Extruders is the number of simulated hotends. The default is 1.
+	Extruders int
+
+	// AmbientTemperature is the initial temperature of all heaters in °C. The default is 25.
+	AmbientTemperature float64
+
+	// HeatingTime is the time constant of the heating curve. A heater covers about 63% of
+	// the distance to its target temperature in this time. The default is 10 seconds.
+	HeatingTime time.Duration
+
+	// Files is the initial content of the SD card. If nil, the card contains a sample file.
+	Files []FakeFile
+
+	// Script, if not nil, is called for every valid command before the built-in processing.
+	// If Script returns handled, the responses are sent verbatim and the built-in processing
+	// is skipped. In this case the responses must include the final "ok" if one is needed.
+	Script func(command string) (responses []string, handled bool)
+
+	// Corrupt, if not nil, can modify the n-th received line before it is processed.
+	// This allows to simulate transmission errors.
+	Corrupt func(n int, line string) string
+}
+
+// FakeFile is a file on the simulated SD card.
+type FakeFile struct {
+	// Name is the short DOS name of the file, e.g. "BENCHY~1.GCO".
+	Name string
+
+	// LongName is the long name of the file, e.g. "Benchy 0.2mm.gcode".
+	// LongName can be empty.
+	LongName string
+
+	// Content is the content of the file.
+	Content string
+}
+
+const (
+	fakeDefaultBusyInterval = 2 * time.Second
+	fakeHomingTime          = 3 * time.Second
+	fakeSDPrintRate         = 200 // bytes per second
+	fakeTemperatureWindow   = 1.0
+)
+
+var fakeLineRe = regexp.MustCompile(`^N(-?\d+)\s*(.*?)(?:\*(\d+))?$`)
+
+// fakeFirmware simulates Marlin firmware.
+type fakeFirmware struct {
+	config FakeConfig
+	out    io.Writer
+	start  time.Time
+	done   chan struct{}
+
+	lastLine   int
+	absolute   bool
+	absoluteE  bool
+	position   [4]float64 // X, Y, Z, E
+	hotends    []fakeHeater
+	bed        fakeHeater
+	tool       int
+	halted     bool
+	busyPeriod time.Duration
+
+	// waitForHeatup is cleared by M108 to stop waiting for the heaters, and quickstops
+	// counts M410 commands which stop moves in progress.
+	waitForHeatup bool
+	quickstops    int
+
+	tempReportPeriod time.Duration
+	nextTempReport   time.Duration
+	sdReportPeriod   time.Duration
+	nextSDReport     time.Duration
+
+	files      []FakeFile
+	selected   int
+	sdPrinting bool
+	sdPosition int
+	sdResumed  time.Duration
+	writing    *FakeFile
+
+	lock    sync.Mutex
+	outLock sync.Mutex
+}
+
+// fakeHeater models heating and cooling as an exponential approach to the target.
+type fakeHeater struct {
+	initial float64
+	since   time.Duration
+	target  float64
+}
+
+func newFakeFirmware(config FakeConfig, out io.Writer) *fakeFirmware {
+	if config.Speed <= 0 {
+		config.Speed = 1
+	}
+	if config.Extruders <= 0 {
+		config.Extruders = 1
+	}
+	if config.AmbientTemperature == 0 {
+		config.AmbientTemperature = 25
+	}
+	if config.HeatingTime <= 0 {
+		config.HeatingTime = 10 * time.Second
+	}
+	files := config.Files
+	if files == nil {
+		files = []FakeFile{
+			{
+				Name:     "FOOBAR~1.GCO",
+				LongName: "Foo bar baz.gcode",
+				Content:  "G28\nG1 Z0.2 F1200\nG1 X10 Y10 E1 F1800\nG1 X20 Y10 E2\nM84\n",
+			},
+		}
+	}
+
+	f := &fakeFirmware{
+		config:     config,
+		out:        out,
+		start:      time.Now(),
+		done:       make(chan struct{}),
+		absolute:   true,
+		absoluteE:  true,
+		hotends:    make([]fakeHeater, config.Extruders),
+		busyPeriod: fakeDefaultBusyInterval,
+		files:      append([]FakeFile(nil), files...),
+		selected:   -1,
+	}
+	for i := range f.hotends {
+		f.hotends[i].initial = config.AmbientTemperature
+	}
+	f.bed.initial = config.AmbientTemperature
+	return f
+}
+
+// run processes lines until the lines channel is closed.
+func (f *fakeFirmware) run(lines <-chan string) {
+	go f.tick()
+	for line := range lines {
+		f.handleLine(line)
+	}
+	close(f.done)
+}
+
+// now returns the simulated time since start.
+func (f *fakeFirmware) now() time.Duration {
+	return time.Duration(float64(time.Since(f.start)) * f.config.Speed)
+}
+
+// sleep waits for a simulated duration. It returns false if the firmware is shutting down.
+func (f *fakeFirmware) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(time.Duration(float64(d) / f.config.Speed)):
+		return true
+	case <-f.done:
+		return false
+	}
+}
+
+func (f *fakeFirmware) send(lines ...string) {
+	f.outLock.Lock()
+	defer f.outLock.Unlock()
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(f.out, l); err != nil {
+			return
+		}
+	}
+}
+
+// tick sends periodic reports.
+func (f *fakeFirmware) tick() {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.done:
+			return
+		}
+
+		var reports []string
+		func() {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			if f.halted {
+				return
+			}
+			now := f.now()
+			if f.tempReportPeriod > 0 && now >= f.nextTempReport {
+				reports = append(reports, " "+f.temperatureReport(now))
+				f.nextTempReport = now + f.tempReportPeriod
+			}
+			if f.sdPrinting && f.sdProgress(now) >= len(f.files[f.selected].Content) {
+				f.sdPrinting = false
+				f.sdPosition = len(f.files[f.selected].Content)
+				reports = append(reports, "Done printing file")
+			}
+			if f.sdReportPeriod > 0 && f.sdPrinting && now >= f.nextSDReport {
+				reports = append(reports, f.sdStatus(now))
+				f.nextSDReport = now + f.sdReportPeriod
+			}
+		}()
+		f.send(reports...)
+	}
+}
+
+// emergency executes the commands which Marlin's emergency parser handles as soon as they
+// are received, ahead of the lines waiting in the receive buffer. M108 and M410 are
+// acknowledged later in turn by handleLine.
+func (f *fakeFirmware) emergency(line string) {
+	command := strings.TrimSpace(line)
+	if m := fakeLineRe.FindStringSubmatch(command); m != nil {
+		command = m[2]
+	}
+
+	f.lock.Lock()
+	killed := false
+	if !f.halted {
+		switch strings.ToUpper(command) {
+		case "M108":
+			f.waitForHeatup = false
+		case "M112":
+			f.halted, killed = true, true
+		case "M410":
+			f.quickstops++
+		}
+	}
+	f.lock.Unlock()
+
+	if killed {
+		f.send("Error:Printer halted. kill() called!")
+	}
+}
+
+func (f *fakeFirmware) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	f.lock.Lock()
+	halted := f.halted
+	f.lock.Unlock()
+	if halted {
+		return
+	}
+
+	command := line
+	if m := fakeLineRe.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		command = m[2]
+		switch {
+		case m[3] == "":
+			f.reject("No Checksum with line number")
+			return
+		case strconv.Itoa(int(checksum(line[:strings.LastIndexByte(line, '*')]))) != m[3]:
+			f.reject("checksum mismatch")
+			return
+		case n != f.lastLine+1 && !strings.HasPrefix(strings.ToUpper(command), "M110"):
+			f.reject("Line Number is not Last Line Number+1")
+			return
+		}
+		f.lastLine = n
+	} else if strings.ContainsRune(line, '*') {
+		f.reject("No Line Number with checksum")
+		return
+	}
+
+	if i := strings.IndexByte(command, ';'); i != -1 {
+		command = strings.TrimSpace(command[:i])
+	}
+	if command == "" {
+		f.send("ok")
+		return
+	}
+
+	if f.writing != nil && !strings.EqualFold(command, "M29") {
+		f.lock.Lock()
+		f.writing.Content += command + "\n"
+		f.lock.Unlock()
+		f.send("ok")
+		return
+	}
+
+	if f.config.Script != nil {
+		if responses, handled := f.config.Script(command); handled {
+			f.send(responses...)
+			return
+		}
+	}
+
+	f.send(f.execute(command)...)
+}
+
+func (f *fakeFirmware) reject(message string) {
+	f.send(
+		fmt.Sprintf("Error:%s, Last Line: %d", message, f.lastLine),
+		fmt.Sprintf("Resend: %d", f.lastLine+1),
+		"ok",
+	)
+}
+
+// execute runs a command and returns the responses, including the final "ok".
+func (f *fakeFirmware) execute(command string) []string {
+	code, args, _ := strings.Cut(command, " ")
+	code = strings.ToUpper(code)
+	params := parseFakeParams(args)
+
+	switch code {
+	case "G0", "G1":
+		f.move(params)
+	case "G4":
+		d := time.Duration(params.float('P', 0)*float64(time.Millisecond)) +
+			time.Duration(params.float('S', 0)*float64(time.Second))
+		f.busy(d)
+	case "G28":
+		f.busy(fakeHomingTime)
+		f.lock.Lock()
+		homeAll := !params.has('X') && !params.has('Y') && !params.has('Z')
+		for i, axis := range "XYZ" {
+			if homeAll || params.has(byte(axis)) {
+				f.position[i] = 0
+			}
+		}
+		f.lock.Unlock()
+	case "G90", "G91":
+		f.lock.Lock()
+		f.absolute, f.absoluteE = code == "G90", code == "G90"
+		f.lock.Unlock()
+	case "G92":
+		f.lock.Lock()
+		for i, axis := range "XYZE" {
+			if params.has(byte(axis)) {
+				f.position[i] = params.float(byte(axis), 0)
+			}
+		}
+		f.lock.Unlock()
+	case "M20":
+		return f.listFiles(params.has('L'))
+	case "M21":
+		return []string{"echo:SD card ok", "ok"}
+	case "M22":
+		return []string{"echo:SD card released", "ok"}
+	case "M23":
+		return f.selectFile(strings.TrimSpace(args))
+	case "M24":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.selected != -1 {
+			f.sdPrinting, f.sdResumed = true, f.now()
+		}
+	case "M25":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.sdPrinting {
+			f.sdPosition, f.sdPrinting = f.sdProgress(f.now()), false
+		}
+	case "M26":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.selected != -1 {
+			f.sdPosition, f.sdResumed = int(params.float('S', 0)), f.now()
+		}
+	case "M27":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if params.has('S') {
+			f.sdReportPeriod = time.Duration(params.float('S', 0) * float64(time.Second))
+			f.nextSDReport = f.now()
+		} else {
+			return []string{f.sdStatus(f.now()), "ok"}
+		}
+	case "M28":
+		return f.writeFile(strings.TrimSpace(args))
+	case "M29":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.writing = nil
+		return []string{"Done saving file.", "ok"}
+	case "M30":
+		return f.deleteFile(strings.TrimSpace(args))
+	case "M82", "M83":
+		f.lock.Lock()
+		f.absoluteE = code == "M82"
+		f.lock.Unlock()
+	case "M104", "M109":
+		return f.setTemperature(params, false, code == "M109")
+	case "M140", "M190":
+		return f.setTemperature(params, true, code == "M190")
+	case "M105":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return []string{"ok " + f.temperatureReport(f.now())}
+	case "M110":
+		f.lastLine = int(params.float('N', float64(f.lastLine)))
+	case "M113":
+		f.lock.Lock()
+		f.busyPeriod = time.Duration(params.float('S', 2) * float64(time.Second))
+		f.lock.Unlock()
+	case "M114":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return []string{
+			fmt.Sprintf("X:%.2f Y:%.2f Z:%.2f E:%.2f Count X:%d Y:%d Z:%d",
+				f.position[0], f.position[1], f.position[2], f.position[3],
+				int(f.position[0]*80), int(f.position[1]*80), int(f.position[2]*400)),
+			"ok",
+		}
+	case "M115":
+		return f.firmwareInfo()
+	case "M155":
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.tempReportPeriod = time.Duration(params.float('S', 0) * float64(time.Second))
+		f.nextTempReport = f.now()
+	default:
+		switch {
+		case code[0] == 'T':
+			tool, err := strconv.Atoi(code[1:])
+			if err != nil || tool < 0 || tool >= len(f.hotends) {
+				return []string{fmt.Sprintf("echo:Invalid extruder %s", code[1:]), "ok"}
+			}
+			f.lock.Lock()
+			f.tool = tool
+			f.lock.Unlock()
+		case code[0] != 'G' && code[0] != 'M':
+			return []string{fmt.Sprintf("echo:Unknown command: \"%s\"", command), "ok"}
+		}
+	}
+
+	return []string{"ok"}
+}
+
+func (f *fakeFirmware) move(params fakeParams) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, axis := range "XYZE" {
+		if !params.has(byte(axis)) {
+			continue
+		}
+		v := params.float(byte(axis), 0)
+		if (axis == 'E' && !f.absoluteE) || (axis != 'E' && !f.absolute) {
+			v += f.position[i]
+		}
+		f.position[i] = v
+	}
+}
+
+// busy waits for a simulated duration and sends busy messages to the host meanwhile.
+func (f *fakeFirmware) busy(d time.Duration) {
+	f.lock.Lock()
+	period, quickstops := f.busyPeriod, f.quickstops
+	f.lock.Unlock()
+
+	for d > 0 {
+		step := d
+		if period > 0 {
+			step = min(d, period)
+		}
+		if !f.sleep(step) {
+			return
+		}
+		f.lock.Lock()
+		stopped := f.halted || f.quickstops != quickstops
+		f.lock.Unlock()
+		if stopped {
+			return
+		}
+		d -= step
+		if d > 0 && period > 0 {
+			f.send("echo:busy: processing")
+		}
+	}
+}
+
+func (f *fakeFirmware) setTemperature(params fakeParams, bed, wait bool) []string {
+	target := params.float('S', 0)
+	waitCooling := false
+	if params.has('R') {
+		target, waitCooling = params.float('R', 0), true
+	}
+
+	f.lock.Lock()
+	var heater *fakeHeater
+	if bed {
+		heater = &f.bed
+	} else {
+		tool := int(params.float('T', float64(f.tool)))
+		if tool < 0 || tool >= len(f.hotends) {
+			f.lock.Unlock()
+			return []string{fmt.Sprintf("echo:Invalid extruder %d", tool), "ok"}
+		}
+		heater = &f.hotends[tool]
+	}
+	now := f.now()
+	heater.initial = heater.temperature(now, f.config)
+	heater.since = now
+	heater.target = target
+	f.waitForHeatup = wait && target > 0
+	f.lock.Unlock()
+
+	if !wait || target <= 0 {
+		return []string{"ok"}
+	}
+
+	for {
+		f.lock.Lock()
+		now := f.now()
+		t := heater.temperature(now, f.config)
+		reached := t >= target-fakeTemperatureWindow &&
+			(!waitCooling || t <= target+fakeTemperatureWindow)
+		report := f.temperatureReport(now)
+		stopped := f.halted || !f.waitForHeatup
+		f.lock.Unlock()
+
+		if reached || stopped {
+			break
+		}
+		f.send(" " + report + " W:?")
+		if !f.sleep(time.Second) {
+			break
+		}
+	}
+	return []string{"ok"}
+}
+
+// temperatureReport assumes a lock.
+func (f *fakeFirmware) temperatureReport(now time.Duration) string {
+	var b strings.Builder
+	active := &f.hotends[f.tool]
+	fmt.Fprintf(&b, "T:%.2f /%.2f", active.temperature(now, f.config), active.target)
+	fmt.Fprintf(&b, " B:%.2f /%.2f", f.bed.temperature(now, f.config), f.bed.target)
+	if len(f.hotends) > 1 {
+		for i := range f.hotends {
+			h := &f.hotends[i]
+			fmt.Fprintf(&b, " T%d:%.2f /%.2f", i, h.temperature(now, f.config), h.target)
+		}
+	}
+	fmt.Fprintf(&b, " @:%d B@:%d", active.power(now, f.config), f.bed.power(now, f.config))
+	if len(f.hotends) > 1 {
+		for i := range f.hotends {
+			fmt.Fprintf(&b, " @%d:%d", i, f.hotends[i].power(now, f.config))
+		}
+	}
+	return b.String()
+}
+
+func (f *fakeFirmware) firmwareInfo() []string {
+	return []string{
+		fmt.Sprintf("FIRMWARE_NAME:Marlin 2.1.2 (Simulator) "+
+			"SOURCE_CODE_URL:github.com/MarlinFirmware/Marlin PROTOCOL_VERSION:1.0 "+
+			"MACHINE_TYPE:RepRapCtl Simulator EXTRUDER_COUNT:%d "+
+			"UUID:cede2a2f-41a2-4748-9b12-c55c62f367ff", len(f.hotends)),
+		"Cap:SERIAL_XON_XOFF:0",
+		"Cap:BINARY_FILE_TRANSFER:0",
+		"Cap:EEPROM:0",
+		"Cap:AUTOREPORT_TEMP:1",
+		"Cap:AUTOREPORT_POS:0",
+		"Cap:AUTOLEVEL:0",
+		"Cap:EMERGENCY_PARSER:1",
+		"Cap:SDCARD:1",
+		"Cap:AUTOREPORT_SD_STATUS:1",
+		"Cap:LONG_FILENAME:1",
+		"Cap:EXTENDED_M20:1",
+		"Cap:THERMAL_PROTECTION:1",
+		"Cap:HOST_ACTION_COMMANDS:0",
+		"ok",
+	}
+}
+
+func (f *fakeFirmware) listFiles(long bool) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r := []string{"Begin file list"}
+	for _, file := range f.files {
+		s := fmt.Sprintf("%s %d", file.Name, len(file.Content))
+		if long && file.LongName != "" {
+			s += " " + file.LongName
+		}
+		r = append(r, s)
+	}
+	return append(r, "End file list", "ok")
+}
+
+func (f *fakeFirmware) selectFile(name string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	i := f.findFile(name)
+	if i == -1 {
+		return []string{fmt.Sprintf("open failed, File: %s.", name), "ok"}
+	}
+	f.selected, f.sdPrinting, f.sdPosition = i, false, 0
+	return []string{
+		fmt.Sprintf("File opened: %s Size: %d", f.files[i].Name, len(f.files[i].Content)),
+		"File selected",
+		"ok",
+	}
+}
+
+func (f *fakeFirmware) writeFile(name string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if name == "" {
+		return []string{"open failed, File: .", "ok"}
+	}
+	if i := f.findFile(name); i != -1 {
+		f.files[i].Content = ""
+		f.writing = &f.files[i]
+	} else {
+		f.files = append(f.files, FakeFile{Name: strings.ToUpper(name)})
+		f.writing = &f.files[len(f.files)-1]
+	}
+	f.selected, f.sdPrinting = -1, false
+	return []string{"Writing to file: " + f.writing.Name, "ok"}
+}
+
+func (f *fakeFirmware) deleteFile(name string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	i := f.findFile(name)
+	if i == -1 {
+		return []string{fmt.Sprintf("Deletion failed, File: %s.", name), "ok"}
+	}
+	f.files = append(f.files[:i], f.files[i+1:]...)
+	f.selected, f.sdPrinting = -1, false
+	return []string{"File deleted:" + name, "ok"}
+}
+
+// findFile assumes a lock.
+func (f *fakeFirmware) findFile(name string) int {
+	for i, file := range f.files {
+		if strings.EqualFold(file.Name, name) || (file.LongName != "" && file.LongName == name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sdProgress assumes a lock.
+func (f *fakeFirmware) sdProgress(now time.Duration) int {
+	if !f.sdPrinting {
+		return f.sdPosition
+	}
+	p := f.sdPosition + int((now-f.sdResumed).Seconds()*fakeSDPrintRate)
+	return min(p, len(f.files[f.selected].Content))
+}
+
+// sdStatus assumes a lock.
+func (f *fakeFirmware) sdStatus(now time.Duration) string {
+	if f.selected == -1 || (!f.sdPrinting && f.sdPosition == 0) {
+		return "Not SD printing"
+	}
+	return fmt.Sprintf("SD printing byte %d/%d", f.sdProgress(now), len(f.files[f.selected].Content))
+}
+
+func (h *fakeHeater) temperature(now time.Duration, config FakeConfig) float64 {
+	goal := max(h.target, config.AmbientTemperature)
+	return goal + (h.initial-goal)*math.Exp(-float64(now-h.since)/float64(config.HeatingTime))
+}
+
+// power returns the heater PWM value in Marlin units, 0 to 127.
+func (h *fakeHeater) power(now time.Duration, config FakeConfig) int {
+	if h.target > 0 && h.temperature(now, config) < h.target {
+		return 127
+	}
+	return 0
+}
+
+// fakeParams maps parameter letters to their values.
+type fakeParams map[byte]string
+
+func parseFakeParams(args string) fakeParams {
+	p := make(fakeParams)
+	for _, field := range strings.Fields(args) {
+		p[strings.ToUpper(field[:1])[0]] = field[1:]
+	}
+	return p
+}
+
+func (p fakeParams) has(letter byte) bool {
+	_, ok := p[letter]
+	return ok
+}
+
+func (p fakeParams) float(letter byte, def float64) float64 {
+	if v, err := strconv.ParseFloat(p[letter], 64); err == nil {
+		return v
+	}
+	return def
+}
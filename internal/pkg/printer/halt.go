@@ -0,0 +1,44 @@
+package printer
+
+import (
+	"regexp"
+)
+
+// HaltError is the error that stops a RepRap printer when the firmware reports that it
+// has halted, for example because of a thermal runaway or after M112. The firmware must be
+// reset before it accepts commands again, which usually happens when reconnecting.
+type HaltError struct {
+	// Message is the line received from the firmware, e.g. "Error:Thermal Runaway".
+	Message string
+}
+
+func (e *HaltError) Error() string {
+	return "printer: firmware halted: " + e.Message
+}
+
+// haltRe matches the messages that firmwares send when they halt:
+//
+//	Error:Printer halted. kill() called!
+//	Error:Thermal Runaway, system stopped! Heater_ID: 0
+//	Error:MINTEMP triggered, system stopped! Heater_ID: E0
+//	!! Shutdown due to webhooks request
+var haltRe = regexp.MustCompile(`(?i)^(?:Error:\s*(?:.*\bhalted\b|.*kill\(\) called|` +
+	`thermal runaway|(?:min|max)temp|heating failed|.*system stopped)|!!\s*.*\bshutdown\b)`)
+
+// isHalt reports whether a response reports that the firmware has halted.
+func isHalt(response string) bool {
+	return haltRe.MatchString(response)
+}
+
+// halt stops communication with a HaltError and publishes the Halted event, unless
+// communication has already stopped.
+func (p *RepRap) halt(response string) {
+	p.lock.Lock()
+	stopped := p.err != nil
+	p.stop(&HaltError{Message: response})
+	p.lock.Unlock()
+
+	if !stopped {
+		p.events.publish(Halted{Message: response})
+	}
+}
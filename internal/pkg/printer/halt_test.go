@@ -0,0 +1,78 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsHalt(t *testing.T) {
+	tests := map[string]bool{
+		"Error:Printer halted. kill() called!":                  true,
+		"Error:Thermal Runaway, system stopped! Heater_ID: bed": true,
+		"Error:MINTEMP triggered, system stopped! Heater_ID: 0": true,
+		"Error:MAXTEMP triggered, system stopped! Heater_ID: 0": true,
+		"Error: Heating failed, system stopped! Heater_ID: 0":   true,
+		"!! Shutdown due to webhooks request":                   true,
+		"Error:Unknown command: \"X1\"":                         false,
+		"Error:checksum mismatch, Last Line: 1":                 false,
+		"!! Must home axis first: 10.000 0.000 0.000 [0.000]":   false,
+		"echo:Thermal Runaway protection enabled":               false,
+		"ok": false,
+	}
+	for response, want := range tests {
+		if got := isHalt(response); got != want {
+			t.Errorf("isHalt(%q): want %v, got %v", response, want, got)
+		}
+	}
+}
+
+func TestRepRap_Halt(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string {
+			if command == "M104 S300" {
+				return []string{"Error:Thermal Runaway, system stopped! Heater_ID: 0", "Error:Printer halted. kill() called!"}
+			}
+			return nil
+		},
+	}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	halts := make(chan Halted, 10)
+	p.Subscribe(func(e Event) {
+		if e, ok := e.(Halted); ok {
+			halts <- e
+		}
+	})
+
+	j, _ := NewJob(p, strings.NewReader(strings.Repeat("G1 X10\nM104 S300\n", 100)), JobOptions{})
+	if err := j.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	<-j.Done()
+
+	var halt *HaltError
+	if err := j.Err(); !errors.As(err, &halt) || halt.Message != "Error:Thermal Runaway, system stopped! Heater_ID: 0" {
+		t.Errorf("Job: want HaltError, got %v", err)
+	}
+	if err := p.Err(); !errors.As(err, &halt) {
+		t.Errorf("Err: want HaltError, got %v", err)
+	}
+	if _, err := p.SendContext(context.Background(), "M105"); !errors.As(err, &halt) {
+		t.Errorf("SendContext after the halt: want HaltError, got %v", err)
+	}
+	select {
+	case e := <-halts:
+		if e.Message != halt.Message {
+			t.Errorf("Halted: want %q, got %q", halt.Message, e.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Halted event")
+	}
+	if len(halts) != 0 {
+		t.Errorf("Want a single Halted event, got %d more", len(halts))
+	}
+}
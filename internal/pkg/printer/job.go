@@ -0,0 +1,463 @@
+package printer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrJobCancelled is returned by Job.Err when the job was cancelled.
+var ErrJobCancelled = errors.New("printer: job cancelled")
+
+// DefaultJobWindow is the default value of JobOptions.Window.
+const DefaultJobWindow = 4
+
+// JobState is the state of a print job.
+type JobState int
+
+const (
+	// JobReady is the state of a job that has not been started yet.
+	JobReady = JobState(iota)
+
+	// JobPrinting is the state of a job whose commands are being sent.
+	JobPrinting
+
+	// JobPausing is the state of a job waiting for the printer to acknowledge the commands
+	// sent before the job was paused.
+	JobPausing
+
+	// JobPaused is the state of a paused job.
+	JobPaused
+
+	// JobFinished is the state of a job whose commands were all acknowledged.
+	JobFinished
+
+	// JobCancelled is the state of a cancelled job.
+	JobCancelled
+
+	// JobFailed is the state of a job that stopped because of an error.
+	JobFailed
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobReady:
+		return "ready"
+	case JobPrinting:
+		return "printing"
+	case JobPausing:
+		return "pausing"
+	case JobPaused:
+		return "paused"
+	case JobFinished:
+		return "finished"
+	case JobCancelled:
+		return "cancelled"
+	case JobFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("JobState(%d)", int(s))
+	}
+}
+
+// Ended reports whether the state is final.
+func (s JobState) Ended() bool {
+	return s >= JobFinished
+}
+
+// JobOptions configure a print job. Zero values select reasonable defaults.
+type JobOptions struct {
+	// PauseScript is sent when the job is paused, after the printer acknowledges all commands
+	// sent before pausing. Use it to park the print head, for example.
+	PauseScript []string
+
+	// ResumeScript is sent when the job is resumed, before the job continues.
+	ResumeScript []string
+
+	// CancelScript is sent when the job is cancelled. Use it to turn off heaters and
+	// motors, for example.
+	CancelScript []string
+
+	// Window is the number of the job's commands that can wait in the printer's queue.
+	// A small window makes pause and cancel take effect sooner. The default is
+	// DefaultJobWindow.
+	Window int
+}
+
+// JobProgress is a snapshot of a job's progress. Only acknowledged commands count as done.
+type JobProgress struct {
+	State JobState
+
+	// Lines is the number of commands done, TotalLines the number of commands in the file.
+	// Comments and blank lines are not counted.
+	Lines, TotalLines int
+
+	// Bytes is the position in the file after the last command done, TotalBytes is the size
+	// of the file.
+	Bytes, TotalBytes int64
+
+	// Elapsed is the printing time, not including pauses.
+	Elapsed time.Duration
+
+	// Remaining is the estimated printing time left, or zero if not known yet.
+	Remaining time.Duration
+}
+
+// Fraction returns the progress by bytes as a number between 0 and 1.
+func (p JobProgress) Fraction() float64 {
+	if p.TotalBytes == 0 {
+		return 1
+	}
+	return float64(p.Bytes) / float64(p.TotalBytes)
+}
+
+// Job streams a G-code file to a printer. Comments and blank lines are removed. Only a few
+// commands are queued in the printer at a time, see JobOptions.Window, so that the job
+// can be paused and resumed at the exact line.
+//
+// Use NewJob or OpenJob to create instances of Job.
+type Job struct {
+	printer Printer
+	reader  *bufio.Reader
+	closer  io.Closer
+	options JobOptions
+
+	state       JobState
+	totalLines  int
+	totalBytes  int64
+	readBytes   int64
+	lines       int
+	bytes       int64
+	outstanding []int64
+	elapsed     time.Duration
+	resumed     time.Time
+	err         error
+
+	progress broadcaster[JobProgress]
+	done     chan struct{}
+	lock     sync.Mutex
+	cond     *sync.Cond
+}
+
+// NewJob creates a job which prints G-code read from source. NewJob reads the whole source
+// to count the commands.
+func NewJob(printer Printer, source io.ReadSeeker, options JobOptions) (*Job, error) {
+	j := &Job{
+		printer: printer,
+		options: options,
+		done:    make(chan struct{}),
+	}
+	if j.options.Window <= 0 {
+		j.options.Window = DefaultJobWindow
+	}
+	j.cond = sync.NewCond(&j.lock)
+
+	r := bufio.NewReader(source)
+	for {
+		line, err := r.ReadString('\n')
+		j.totalBytes += int64(len(line))
+		if stripComment(line) != "" {
+			j.totalLines++
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	j.reader = bufio.NewReader(source)
+
+	return j, nil
+}
+
+// OpenJob creates a job which prints a G-code file. The file is closed when the job ends.
+func OpenJob(printer Printer, path string, options JobOptions) (*Job, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	j, err := NewJob(printer, f, options)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	j.closer = f
+	return j, nil
+}
+
+// Start starts sending the job's commands.
+func (j *Job) Start() error {
+	err := func() error {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		if j.state != JobReady {
+			return fmt.Errorf("printer: cannot start a job that is %v", j.state)
+		}
+		j.state = JobPrinting
+		j.resumed = time.Now()
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	go j.run()
+	go j.watchPrinter()
+	j.publish()
+	return nil
+}
+
+// Pause stops sending the job's commands. The job becomes paused when the printer
+// acknowledges the commands already sent, and then the pause script is sent.
+func (j *Job) Pause() error {
+	j.lock.Lock()
+	if j.state != JobPrinting {
+		defer j.lock.Unlock()
+		return fmt.Errorf("printer: cannot pause a job that is %v", j.state)
+	}
+	j.setState(JobPausing)
+	j.lock.Unlock()
+
+	j.publish()
+	return nil
+}
+
+// Resume sends the resume script and continues sending the job's commands at the line
+// following the last command sent before pausing.
+func (j *Job) Resume() error {
+	j.lock.Lock()
+	if j.state != JobPaused {
+		defer j.lock.Unlock()
+		return fmt.Errorf("printer: cannot resume a job that is %v", j.state)
+	}
+	// the script is queued before the state changes, so that it goes before the job
+	err := j.sendScript(j.options.ResumeScript)
+	if err != nil {
+		j.fail(err)
+	} else {
+		j.setState(JobPrinting)
+	}
+	j.lock.Unlock()
+
+	j.publish()
+	return err
+}
+
+// Cancel stops sending the job's commands and sends the cancel script. Commands already
+// queued in the printer are still executed.
+func (j *Job) Cancel() error {
+	j.lock.Lock()
+	if j.state == JobReady || j.state.Ended() {
+		defer j.lock.Unlock()
+		return fmt.Errorf("printer: cannot cancel a job that is %v", j.state)
+	}
+	j.setState(JobCancelled)
+	j.err = ErrJobCancelled
+	err := j.sendScript(j.options.CancelScript)
+	j.lock.Unlock()
+
+	j.publish()
+	return err
+}
+
+// Progress returns the current progress of the job.
+func (j *Job) Progress() JobProgress {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.progressLocked()
+}
+
+// Subscribe adds a handler which receives the job's progress whenever a command is done
+// or the state changes. The handler is called synchronously and must not block. Call
+// unsubscribe to remove the handler.
+func (j *Job) Subscribe(handler func(JobProgress)) (unsubscribe func()) {
+	return j.progress.subscribe(handler)
+}
+
+// Done returns a channel that is closed when the job ends.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns the reason why the job ended prematurely: ErrJobCancelled if it was
+// cancelled, or the error that made it fail. Err returns nil while the job is running
+// and after it finishes successfully.
+func (j *Job) Err() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.err
+}
+
+func (j *Job) run() {
+	j.lock.Lock()
+	defer func() {
+		j.lock.Unlock()
+		if j.closer != nil {
+			_ = j.closer.Close()
+		}
+		j.publish()
+		close(j.done)
+	}()
+
+	eof := false
+	for !j.state.Ended() {
+		switch j.state {
+		case JobPrinting:
+			if eof && len(j.outstanding) == 0 {
+				j.bytes = j.totalBytes
+				j.setState(JobFinished)
+				continue
+			}
+			if eof || len(j.outstanding) >= j.options.Window {
+				j.cond.Wait()
+				continue
+			}
+			command, err := j.nextCommand()
+			if err == io.EOF {
+				eof = true
+				continue
+			} else if err != nil {
+				j.fail(err)
+				continue
+			}
+			j.outstanding = append(j.outstanding, j.readBytes)
+			if err := j.printer.Send(NewCommand(command, j.acknowledge).OnCancel(j.cancelled)); err != nil {
+				j.fail(err)
+			}
+		case JobPausing:
+			if len(j.outstanding) > 0 {
+				j.cond.Wait()
+				continue
+			}
+			j.setState(JobPaused)
+			if err := j.sendScript(j.options.PauseScript); err != nil {
+				j.fail(err)
+				continue
+			}
+			j.lock.Unlock()
+			j.publish()
+			j.lock.Lock()
+		default:
+			j.cond.Wait()
+		}
+	}
+}
+
+// nextCommand reads the next non-empty command. nextCommand assumes a lock.
+func (j *Job) nextCommand() (string, error) {
+	for {
+		line, err := j.reader.ReadString('\n')
+		j.readBytes += int64(len(line))
+		if command := stripComment(line); command != "" {
+			return command, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// acknowledge is the response handler of the job's commands.
+func (j *Job) acknowledge(response string) {
+	if !isOk(response) {
+		return
+	}
+
+	func() {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+		if len(j.outstanding) == 0 {
+			return
+		}
+		j.lines++
+		j.bytes = j.outstanding[0]
+		j.outstanding = j.outstanding[1:]
+		j.cond.Broadcast()
+	}()
+
+	j.publish()
+}
+
+// cancelled is the cancel handler of the job's commands. The job cannot continue without
+// the discarded commands, e.g. after a quickstop, so it fails.
+func (j *Job) cancelled() {
+	j.lock.Lock()
+	j.fail(ErrCancelled)
+	j.lock.Unlock()
+
+	j.publish()
+}
+
+func (j *Job) watchPrinter() {
+	select {
+	case <-j.printer.Done():
+		j.lock.Lock()
+		j.fail(j.printer.Err())
+		j.lock.Unlock()
+	case <-j.done:
+	}
+}
+
+// sendScript assumes a lock.
+func (j *Job) sendScript(script []string) error {
+	for _, command := range script {
+		if stripComment(command) == "" {
+			continue
+		}
+		if err := j.printer.Send(NewCommand(command, nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setState assumes a lock.
+func (j *Job) setState(state JobState) {
+	if j.state == JobPrinting {
+		j.elapsed += time.Since(j.resumed)
+	}
+	if state == JobPrinting {
+		j.resumed = time.Now()
+	}
+	j.state = state
+	j.cond.Broadcast()
+}
+
+// fail assumes a lock.
+func (j *Job) fail(err error) {
+	if j.state.Ended() {
+		return
+	}
+	j.err = err
+	j.setState(JobFailed)
+}
+
+func (j *Job) publish() {
+	j.progress.publish(j.Progress())
+}
+
+// progressLocked assumes a lock.
+func (j *Job) progressLocked() JobProgress {
+	p := JobProgress{
+		State:      j.state,
+		Lines:      j.lines,
+		TotalLines: j.totalLines,
+		Bytes:      j.bytes,
+		TotalBytes: j.totalBytes,
+		Elapsed:    j.elapsed,
+	}
+	if j.state == JobPrinting {
+		p.Elapsed += time.Since(j.resumed)
+	}
+	if p.Bytes > 0 && !j.state.Ended() {
+		p.Remaining = time.Duration(float64(p.Elapsed) * float64(p.TotalBytes-p.Bytes) / float64(p.Bytes))
+	}
+	return p
+}
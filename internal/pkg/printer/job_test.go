@@ -0,0 +1,261 @@
+package printer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testJobGCode = `; generated by a slicer
+G28 ; home
+
+G1 Z0.2 F3000
+G1 X10 Y10 E1
+
+G1 X20 Y10 E2
+G1 X20 Y20 E3
+G1 X10 Y20 E4
+;end
+M84`
+
+var testJobCommands = []string{"G28", "G1 Z0.2 F3000", "G1 X10 Y10 E1", "G1 X20 Y10 E2", "G1 X20 Y20 E3", "G1 X10 Y20 E4", "M84"}
+
+// waitForJobState waits until the job reaches the state and returns its progress.
+func waitForJobState(t *testing.T, j *Job, state JobState) JobProgress {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		p := j.Progress()
+		if p.State == state {
+			return p
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Timed out waiting for state %v, got %v", state, p.State)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestJob(t *testing.T) {
+	f := &testFirmware{}
+	p := NewRepRap(f.open(), Options{FlowControl: CharacterCounting})
+	defer p.Close()
+
+	j, err := NewJob(p, strings.NewReader(testJobGCode), JobOptions{})
+	if err != nil {
+		t.Fatalf("NewJob failed: %v", err)
+	}
+	if got := j.Progress(); got.State != JobReady || got.TotalLines != 7 || got.TotalBytes != int64(len(testJobGCode)) {
+		t.Errorf("Unexpected initial progress %+v", got)
+	}
+
+	var updates []JobProgress
+	var lock sync.Mutex
+	j.Subscribe(func(p JobProgress) {
+		lock.Lock()
+		defer lock.Unlock()
+		updates = append(updates, p)
+	})
+	if err := j.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the job")
+	}
+
+	if err := j.Err(); err != nil {
+		t.Errorf("Err: want nil, got %v", err)
+	}
+	got := j.Progress()
+	if got.State != JobFinished || got.Lines != 7 || got.Bytes != got.TotalBytes || got.Fraction() != 1 {
+		t.Errorf("Unexpected final progress %+v", got)
+	}
+	if commands := f.received()[2:]; !slices.Equal(commands, testJobCommands) {
+		t.Errorf("Unexpected commands: want %q, got %q", testJobCommands, commands)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for i := 1; i < len(updates); i++ {
+		if updates[i].Lines < updates[i-1].Lines || updates[i].Bytes < updates[i-1].Bytes {
+			t.Errorf("Progress went back from %+v to %+v", updates[i-1], updates[i])
+		}
+	}
+	if err := j.Start(); err == nil {
+		t.Errorf("Start of a finished job succeeded")
+	}
+}
+
+func TestJob_PauseResume(t *testing.T) {
+	f := &testFirmware{delay: 5 * time.Millisecond}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	j, _ := NewJob(p, strings.NewReader(testJobGCode), JobOptions{
+		PauseScript:  []string{"G91", "G1 Z10", "G90"},
+		ResumeScript: []string{"G1 Z-10 ; unpark"},
+		Window:       2,
+	})
+	paused := make(chan struct{})
+	j.Subscribe(func(p JobProgress) {
+		if p.Lines == 3 && p.State == JobPrinting {
+			_ = j.Pause()
+		}
+		if p.State == JobPaused {
+			close(paused)
+		}
+	})
+	_ = j.Start()
+
+	select {
+	case <-paused:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for pause")
+	}
+	progress := j.Progress()
+	if err := j.Pause(); err == nil {
+		t.Errorf("Pause of a paused job succeeded")
+	}
+
+	// nothing more is sent while paused
+	time.Sleep(50 * time.Millisecond)
+	before := f.received()[2:]
+	if got := j.Progress(); got.Lines != progress.Lines || got.Elapsed != progress.Elapsed {
+		t.Errorf("Progress changed while paused: %+v, then %+v", progress, got)
+	}
+
+	if err := j.Resume(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	<-j.Done()
+
+	// the commands acknowledged before pausing are followed by the pause script, then
+	// the resume script and the rest of the job
+	n := progress.Lines
+	want := append(slices.Clone(testJobCommands[:n]), "G91", "G1 Z10", "G90", "G1 Z-10")
+	want = append(want, testJobCommands[n:]...)
+	if got := f.received()[2:]; !slices.Equal(got, want) {
+		t.Errorf("Unexpected commands: want %q, got %q", want, got)
+	}
+	if len(before) != n+3 {
+		t.Errorf("Unexpected commands while paused: %q", before)
+	}
+	if n < 3 || n > 3+2 {
+		t.Errorf("Paused after %v lines", n)
+	}
+}
+
+func TestJob_Cancel(t *testing.T) {
+	f := &testFirmware{delay: 5 * time.Millisecond}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	j, _ := NewJob(p, strings.NewReader(testJobGCode), JobOptions{
+		CancelScript: []string{"M104 S0", "M84"},
+		Window:       1,
+	})
+	if err := j.Cancel(); err == nil {
+		t.Errorf("Cancel of a job that is not started succeeded")
+	}
+	j.Subscribe(func(p JobProgress) {
+		if p.Lines == 2 {
+			_ = j.Cancel()
+		}
+	})
+	_ = j.Start()
+	<-j.Done()
+
+	if err := j.Err(); !errors.Is(err, ErrJobCancelled) {
+		t.Errorf("Err: want ErrJobCancelled, got %v", err)
+	}
+
+	// wait for the cancel script to be processed
+	c := newResponseCollector()
+	_ = p.Send(NewCommand("M400", c.handle))
+	c.wait(t)
+
+	want := append(slices.Clone(testJobCommands[:2]), "M104 S0", "M84", "M400")
+	if got := f.received()[2:]; !slices.Equal(got, want) {
+		t.Errorf("Unexpected commands: want %q, got %q", want, got)
+	}
+	if got := j.Progress(); got.State != JobCancelled || got.Remaining != 0 {
+		t.Errorf("Unexpected progress %+v", got)
+	}
+}
+
+func TestJob_PrinterClosed(t *testing.T) {
+	f := &testFirmware{delay: 5 * time.Millisecond}
+	p := NewRepRap(f.open(), Options{})
+
+	j, _ := NewJob(p, strings.NewReader(testJobGCode), JobOptions{})
+	j.Subscribe(func(progress JobProgress) {
+		if progress.Lines == 1 {
+			_ = p.Close()
+		}
+	})
+	_ = j.Start()
+	waitForJobState(t, j, JobFailed)
+	<-j.Done()
+
+	if err := j.Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err: want ErrClosed, got %v", err)
+	}
+}
+
+func TestJob_Quickstop(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Speed: 10})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	<-p.Identified()
+
+	// homing takes 3 s of simulated time, and reports busy every 2 s
+	waitBusy := expectLine(t, p, isBusy)
+	j, _ := NewJob(p, strings.NewReader(testJobGCode), JobOptions{})
+	_ = j.Start()
+	waitBusy()
+	if err := p.Quickstop(); err != nil {
+		t.Fatalf("Quickstop: %v", err)
+	}
+	select {
+	case <-j.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the job, progress %+v", j.Progress())
+	}
+
+	if err := j.Err(); !errors.Is(err, ErrCancelled) {
+		t.Errorf("Err: want ErrCancelled, got %v", err)
+	}
+	if got := j.Progress(); got.State != JobFailed || got.Lines > 1 {
+		t.Errorf("Unexpected progress %+v", got)
+	}
+}
+
+func TestOpenJob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.gcode")
+	if err := os.WriteFile(path, []byte(testJobGCode), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := OpenFake(FakeConfig{Speed: 100})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+
+	j, err := OpenJob(p, path, JobOptions{})
+	if err != nil {
+		t.Fatalf("OpenJob failed: %v", err)
+	}
+	_ = j.Start()
+	waitForJobState(t, j, JobFinished)
+
+	if _, err := OpenJob(p, filepath.Join(t.TempDir(), "missing.gcode"), JobOptions{}); err == nil {
+		t.Errorf("OpenJob of a missing file succeeded")
+	}
+}
@@ -1,10 +1,50 @@
 package printer
 
+import "context"
+
+// Printer accepts G-code commands for execution.
 type Printer interface {
+	// Send queues a command for sending to the printer. Send does not wait for the command
+	// to be sent or executed.
 	Send(cmd Command) error
+
+	// Request queues a command like Send, and returns the future response to the command.
+	Request(command string) (*Future, error)
+
+	// SendContext queues a command and waits for the response. It returns the response
+	// and its error, or the context's error if the context is done first.
+	SendContext(ctx context.Context, command string) (Response, error)
+
+	// Done returns a channel that is closed when communication with the printer stops.
+	Done() <-chan struct{}
+
+	// Err returns the error that stopped communication, or nil if the printer is running.
+	Err() error
 }
 
+// Command is a single G-code command along with the means to receive the printer's response.
 type Command struct {
 	command         string
 	responseHandler func(response string)
+	cancelHandler   func()
+	future          *Future
+}
+
+// NewCommand creates a Command. Every line that the printer sends in response to the command,
+// including the final "ok", is passed to responseHandler. The responseHandler may be nil.
+func NewCommand(command string, responseHandler func(response string)) Command {
+	return Command{command: command, responseHandler: responseHandler}
+}
+
+// OnCancel returns a copy of the command which calls handler if the command is discarded
+// by EmergencyStop or Quickstop before it is acknowledged. The response handler is not
+// called for discarded commands. The handler is called synchronously and must not block.
+func (c Command) OnCancel(handler func()) Command {
+	c.cancelHandler = handler
+	return c
+}
+
+// String returns the G-code text of the command.
+func (c Command) String() string {
+	return c.command
 }
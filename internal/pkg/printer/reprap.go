@@ -0,0 +1,579 @@
+package printer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Printer = (*RepRap)(nil)
+
+// ErrClosed is returned when sending to a printer that has been closed.
+var ErrClosed = errors.New("printer: closed")
+
+// RepRap is a Printer that talks to RepRap compatible firmware such as Marlin over a byte
+// stream, usually a serial port.
+//
+// Each command is prefixed with a line number and suffixed with a checksum, e.g.
+//
+//	N12 G1 X10 Y10*26
+//
+// The firmware acknowledges every line it receives with "ok". How many lines can be sent
+// ahead of acknowledgements depends on the FlowControl option. The lines received between
+// a command's transmission and its "ok", including the "ok" itself, are passed to the
+// command's response handler. The handlers are called sequentially from a single
+// goroutine. Alternatively, Request collects the lines into a Response which callers can
+// wait for.
+//
+// If the firmware detects a corrupted line it requests to resend it, and all lines after it,
+// with a "Resend: N" or "rs N" response. RepRap keeps a bounded history of sent lines and
+// replays them automatically. Such resend requests, together with the accompanying errors
+// and acknowledgements, are not passed to the response handlers.
+//
+// Keepalive lines like "echo:busy: processing", which the firmware sends while executing
+// long commands, are not passed to the response handlers either. They restart the
+// timeout of the command, see Options.Timeout.
+//
+// EmergencyStop, Quickstop and CancelWait bypass the queue, so that the firmware receives
+// them even while busy executing a long command.
+//
+// Acquire holds the printer for exclusive use, e.g. while uploading a file to the SD card,
+// so that the commands of other senders wait in the queue meanwhile.
+//
+// When the firmware reports that it has halted, e.g. "Error:Thermal Runaway", communication
+// stops with a HaltError and the Halted event is published.
+//
+// Use NewRepRap to create instances of RepRap.
+type RepRap struct {
+	conn        io.ReadWriteCloser
+	historySize int
+	flowControl FlowControl
+	bufferSize  int
+	timeout     func(command string) time.Duration
+
+	queue           []*queueEntry
+	history         []*queueEntry
+	resend          []*queueEntry
+	holder          *Exclusive
+	inFlight        []*transmission
+	inFlightBytes   int
+	lastLine        int
+	capabilities    Capabilities
+	identified      chan struct{}
+	done            chan struct{}
+	events          broadcaster[Event]
+	timer           *time.Timer
+	timerGeneration int
+	err             error
+	lock            sync.Mutex
+	cond            *sync.Cond
+
+	// writeLock serializes writes to conn. It is acquired while holding lock, so that
+	// lines are written in the order of inFlight.
+	writeLock sync.Mutex
+}
+
+// Options configure a RepRap printer. Zero values select reasonable defaults.
+type Options struct {
+	// HistorySize is the number of most recently sent lines kept for resending.
+	// The default is DefaultHistorySize.
+	HistorySize int
+
+	// FlowControl selects how commands are sent ahead of acknowledgements.
+	// The default is PingPong.
+	FlowControl FlowControl
+
+	// BufferSize is the size in bytes of the firmware's receive buffer used with
+	// CharacterCounting. The default is DefaultBufferSize.
+	BufferSize int
+
+	// Timeout returns how long to wait for the response to a command sent with Request
+	// or SendContext. The time starts when the firmware starts executing the command, and
+	// starts anew whenever the firmware reports that it is busy. Zero disables the
+	// timeout. The default is DefaultTimeout.
+	Timeout func(command string) time.Duration
+}
+
+const (
+	// DefaultHistorySize is the default value of Options.HistorySize.
+	DefaultHistorySize = 64
+
+	// DefaultBufferSize is the default value of Options.BufferSize. It matches the
+	// receive buffer of Marlin and most other AVR based firmwares.
+	DefaultBufferSize = 127
+)
+
+// FlowControl selects how many lines can be sent to the firmware without waiting for
+// acknowledgements.
+type FlowControl int
+
+const (
+	// PingPong sends a line only after the previous line is acknowledged.
+	PingPong = FlowControl(iota)
+
+	// CharacterCounting sends lines as long as the total size of all unacknowledged lines
+	// fits the firmware's receive buffer. This keeps the firmware busy when executing many
+	// short commands, at the cost of longer recovery from transmission errors.
+	CharacterCounting
+)
+
+type queueEntry struct {
+	cmd  Command
+	line int
+	text string
+
+	// holder is the Exclusive that sent the command, if any.
+	holder *Exclusive
+}
+
+// complete completes the future response of the entry's command, if any.
+func (e *queueEntry) complete(err error) {
+	if e.cmd.future != nil {
+		e.cmd.future.complete(err)
+	}
+}
+
+// transmission is a single attempt to send a queue entry.
+type transmission struct {
+	entry *queueEntry
+
+	// rejected is set when the firmware requests a resend instead of acknowledging
+	// the transmission.
+	rejected bool
+
+	// stale is set on transmissions that were sent before the latest resend request.
+	// The firmware is expected to reject them, and their resend requests are ignored.
+	stale bool
+}
+
+var resendRe = regexp.MustCompile(`^(?:Resend:|rs )\s*N?(\d+)`)
+
+// NewRepRap creates a RepRap printer communicating over conn. RepRap takes ownership of
+// conn and closes it when the printer is closed.
+//
+// The first command sent is always M110, which resets the firmware's line counter.
+// It is followed by M115 to find out the firmware capabilities.
+func NewRepRap(conn io.ReadWriteCloser, options Options) *RepRap {
+	p := &RepRap{
+		conn:        conn,
+		historySize: options.HistorySize,
+		flowControl: options.FlowControl,
+		bufferSize:  options.BufferSize,
+		timeout:     options.Timeout,
+		lastLine:    -1,
+		identified:  make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if p.historySize <= 0 {
+		p.historySize = DefaultHistorySize
+	}
+	if p.bufferSize <= 0 {
+		p.bufferSize = DefaultBufferSize
+	}
+	if p.timeout == nil {
+		p.timeout = DefaultTimeout
+	}
+	p.cond = sync.NewCond(&p.lock)
+	p.queue = append(p.queue,
+		&queueEntry{cmd: NewCommand("M110 N0", nil)},
+		&queueEntry{cmd: NewCommand("M115", p.identify())},
+	)
+	go p.writeLoop()
+	go p.readLoop()
+	return p
+}
+
+// Send queues a command for sending. Comments are removed from the command before sending.
+// Send returns an error if the command is empty or spans multiple lines, or if the printer
+// has failed or has been closed.
+func (p *RepRap) Send(cmd Command) error {
+	return p.send(cmd, nil)
+}
+
+// send queues a command sent by the holder, which is nil for commands sent directly.
+func (p *RepRap) send(cmd Command, holder *Exclusive) error {
+	text := stripComment(cmd.command)
+	if text == "" {
+		return fmt.Errorf("printer: empty command %q", cmd.command)
+	}
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("printer: multi-line command %q", cmd.command)
+	}
+	cmd.command = text
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.queue = append(p.queue, &queueEntry{cmd: cmd, holder: holder})
+	p.cond.Broadcast()
+	return nil
+}
+
+// Request queues a command like Send and returns the future response, which collects all
+// lines received up to and including the "ok". If the printer stops before acknowledging
+// the command, the response is completed with the error that stopped it.
+func (p *RepRap) Request(command string) (*Future, error) {
+	return p.request(command, nil)
+}
+
+func (p *RepRap) request(command string, holder *Exclusive) (*Future, error) {
+	f := newFuture()
+	if err := p.send(Command{command: command, future: f}, holder); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SendContext queues a command and waits for the response like Request. If the context is
+// done before the command is sent, the command is removed from the queue. Once sent, the
+// command is executed anyway.
+//
+// The response fails with ErrTimeout if the firmware does not acknowledge the command in
+// time, see Options.Timeout.
+func (p *RepRap) SendContext(ctx context.Context, command string) (Response, error) {
+	return p.sendContext(ctx, command, nil)
+}
+
+func (p *RepRap) sendContext(ctx context.Context, command string, holder *Exclusive) (Response, error) {
+	f, err := p.request(command, holder)
+	if err != nil {
+		return Response{}, err
+	}
+
+	select {
+	case <-f.Done():
+		r := f.Response()
+		return r, r.Err
+	case <-ctx.Done():
+		p.cancel(f, ctx.Err())
+		return Response{}, ctx.Err()
+	}
+}
+
+// cancel removes a command which has not been sent yet from the queue, and completes
+// its response with err.
+func (p *RepRap) cancel(f *Future, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, e := range p.queue {
+		if e.cmd.future == f {
+			p.queue = slices.Delete(p.queue, i, i+1)
+			f.complete(err)
+			return
+		}
+	}
+}
+
+// Subscribe adds a handler which receives events about the printer's activity, such as
+// LineSent and LineReceived. The handler is called synchronously from internal goroutines,
+// possibly concurrently, and must not block. LineSent is delivered while the line is being
+// written, so the handler must not send commands. Call unsubscribe to remove the handler.
+func (p *RepRap) Subscribe(handler func(Event)) (unsubscribe func()) {
+	return p.events.subscribe(handler)
+}
+
+// Capabilities returns the firmware capabilities reported in response to M115.
+// The second return value is false until the response is received.
+func (p *RepRap) Capabilities() (Capabilities, bool) {
+	select {
+	case <-p.identified:
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.capabilities, true
+	default:
+		return Capabilities{}, false
+	}
+}
+
+// Identified returns a channel that is closed when the firmware capabilities are known.
+func (p *RepRap) Identified() <-chan struct{} {
+	return p.identified
+}
+
+// identify creates a response handler for M115 which records the firmware capabilities.
+func (p *RepRap) identify() func(string) {
+	var lines []string
+	return func(response string) {
+		lines = append(lines, response)
+		if isOk(response) {
+			p.lock.Lock()
+			p.capabilities = ParseCapabilities(lines)
+			p.lock.Unlock()
+			close(p.identified)
+		}
+	}
+}
+
+// Close stops communication and closes the underlying connection. Queued commands are
+// discarded.
+func (p *RepRap) Close() error {
+	p.lock.Lock()
+	p.stop(ErrClosed)
+	p.lock.Unlock()
+	return p.conn.Close()
+}
+
+// Err returns the error that stopped communication, or nil if the printer is still running.
+func (p *RepRap) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.err
+}
+
+// Done returns a channel that is closed when communication stops, either because the
+// printer was closed or because of an error. Err returns the reason.
+func (p *RepRap) Done() <-chan struct{} {
+	return p.done
+}
+
+func (p *RepRap) writeLoop() {
+	for {
+		p.lock.Lock()
+		for p.err == nil && !p.canTransmit() {
+			p.cond.Wait()
+		}
+		if p.err != nil {
+			p.lock.Unlock()
+			return
+		}
+		e := p.nextEntry()
+		p.inFlight = append(p.inFlight, &transmission{entry: e})
+		p.inFlightBytes += len(e.text)
+		if len(p.inFlight) == 1 {
+			p.armTimeout()
+		}
+		p.writeLock.Lock()
+		p.lock.Unlock()
+
+		// published first, so that LineSent always precedes the response to the line
+		p.events.publish(LineSent{Line: strings.TrimSuffix(e.text, "\n")})
+		_, err := io.WriteString(p.conn, e.text)
+		p.writeLock.Unlock()
+		if err != nil {
+			p.fail(err)
+			return
+		}
+	}
+}
+
+// canTransmit assumes a lock.
+func (p *RepRap) canTransmit() bool {
+	var size int
+	next := p.nextQueued()
+	switch {
+	case len(p.resend) > 0:
+		size = len(p.resend[0].text)
+	case next >= 0:
+		size = len(formatLine(p.lastLine+1, p.queue[next].cmd.command))
+	default:
+		return false
+	}
+
+	if len(p.inFlight) == 0 {
+		return true
+	}
+	switch p.flowControl {
+	case CharacterCounting:
+		return p.inFlightBytes+size <= p.bufferSize
+	default:
+		return false
+	}
+}
+
+// nextEntry returns the next entry to transmit. Entries requested for resending go first.
+// nextEntry assumes a lock.
+func (p *RepRap) nextEntry() *queueEntry {
+	if len(p.resend) > 0 {
+		e := p.resend[0]
+		p.resend[0] = nil
+		p.resend = p.resend[1:]
+		return e
+	}
+
+	i := p.nextQueued()
+	e := p.queue[i]
+	if i == 0 {
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+	} else {
+		p.queue = slices.Delete(p.queue, i, i+1)
+	}
+	p.lastLine++
+	e.line = p.lastLine
+	e.text = formatLine(e.line, e.cmd.command)
+
+	p.history = append(p.history, e)
+	if n := len(p.history) - p.historySize; n > 0 {
+		clear(p.history[:n])
+		p.history = p.history[n:]
+	}
+
+	return e
+}
+
+// nextQueued returns the index of the next queued command to send, or -1 if there is none.
+// While the printer is held, only the commands of the holder are sent. nextQueued assumes
+// a lock.
+func (p *RepRap) nextQueued() int {
+	if p.holder == nil {
+		if len(p.queue) == 0 {
+			return -1
+		}
+		return 0
+	}
+	return slices.IndexFunc(p.queue, func(e *queueEntry) bool { return e.holder == p.holder })
+}
+
+// rewind schedules all lines starting from line for resending.
+// rewind assumes a lock.
+func (p *RepRap) rewind(line int) {
+	if len(p.history) == 0 || line < p.history[0].line || line > p.lastLine {
+		p.stop(fmt.Errorf("printer: cannot resend line %d, history has lines %d to %d",
+			line, p.lastLine-len(p.history)+1, p.lastLine))
+		return
+	}
+	for _, t := range p.inFlight {
+		t.stale = true
+	}
+	p.resend = slices.Clone(p.history[line-p.history[0].line:])
+	p.cond.Broadcast()
+}
+
+func (p *RepRap) readLoop() {
+	s := bufio.NewScanner(p.conn)
+	for s.Scan() {
+		if response := strings.TrimSpace(s.Text()); response != "" {
+			p.events.publish(LineReceived{Line: response})
+			p.handleResponse(response)
+		}
+	}
+	err := s.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	p.fail(err)
+}
+
+func (p *RepRap) handleResponse(response string) {
+	if isHalt(response) {
+		p.halt(response)
+		return
+	}
+
+	var handler func(string)
+
+	func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		if len(p.inFlight) == 0 {
+			return
+		}
+		t := p.inFlight[0]
+
+		if isBusy(response) {
+			p.armTimeout()
+			return
+		}
+
+		if isOk(response) {
+			p.inFlight[0] = nil
+			p.inFlight = p.inFlight[1:]
+			p.inFlightBytes -= len(t.entry.text)
+			p.armTimeout()
+			p.cond.Broadcast()
+		} else if m := resendRe.FindStringSubmatch(response); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			if !t.stale {
+				p.rewind(line)
+			}
+			t.rejected = true
+			return
+		} else if isLineError(response) {
+			return
+		}
+
+		if !t.rejected {
+			handler = t.entry.cmd.responseHandler
+			if f := t.entry.cmd.future; f != nil {
+				f.add(response)
+			}
+		}
+	}()
+
+	if handler != nil {
+		handler(response)
+	}
+}
+
+func (p *RepRap) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stop(fmt.Errorf("printer: %w", err))
+}
+
+// stop records the first error that stopped communication and wakes up all waiters.
+// stop assumes a lock.
+func (p *RepRap) stop(err error) {
+	if p.err == nil {
+		p.err = err
+		close(p.done)
+
+		// the commands which were not acknowledged will never be
+		for _, t := range p.inFlight {
+			t.entry.complete(err)
+		}
+		for _, e := range p.resend {
+			e.complete(err)
+		}
+		for _, e := range p.queue {
+			e.complete(err)
+		}
+		p.armTimeout()
+	}
+	p.cond.Broadcast()
+}
+
+// formatLine adds a line number and a checksum to a command, and terminates it with an EOL.
+func formatLine(line int, command string) string {
+	s := fmt.Sprintf("N%d %s", line, command)
+	return fmt.Sprintf("%s*%d\n", s, checksum(s))
+}
+
+// checksum calculates the RepRap checksum, which is XOR of all bytes in s.
+func checksum(s string) byte {
+	var c byte
+	for i := 0; i < len(s); i++ {
+		c ^= s[i]
+	}
+	return c
+}
+
+// stripComment removes a semicolon comment and surrounding white space from a command.
+func stripComment(command string) string {
+	if i := strings.IndexByte(command, ';'); i != -1 {
+		command = command[:i]
+	}
+	return strings.TrimSpace(command)
+}
+
+// isLineError reports whether a response is an error caused by a corrupted line.
+// Such errors precede resend requests.
+func isLineError(response string) bool {
+	return strings.HasPrefix(response, "Error:") && strings.Contains(response, "Last Line")
+}
+
+// isOk reports whether a response acknowledges a command.
+func isOk(response string) bool {
+	return response == "ok" || strings.HasPrefix(response, "ok ")
+}
@@ -0,0 +1,543 @@
+package printer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFirmware imitates line number and checksum validation done by Marlin.
+type testFirmware struct {
+	// respond produces the lines sent back for a valid command, not including the final "ok".
+	respond func(command string) []string
+
+	// corrupt, if not nil, can modify the n-th received line before it is validated.
+	corrupt func(n int, text string) string
+
+	// resendFormat is the format of resend requests. The default is "Resend: %d".
+	resendFormat string
+
+	// delay is the time it takes to process a line.
+	delay time.Duration
+
+	lastLine   int
+	nLines     int
+	commands   []string
+	pending    int
+	maxPending int
+	lock       sync.Mutex
+}
+
+var testLineRe = regexp.MustCompile(`^N(\d+) (.*)\*(\d+)$`)
+
+func (f *testFirmware) open() io.ReadWriteCloser {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &connection{
+		inReader:  inReader,
+		inWriter:  inWriter,
+		outReader: outReader,
+		outWriter: outWriter,
+	}
+	// the receive buffer
+	lines := make(chan string, 1000)
+	go func() {
+		s := bufio.NewScanner(inReader)
+		for s.Scan() {
+			f.updatePending(len(s.Text()) + 1)
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	go func() {
+		for text := range lines {
+			time.Sleep(f.delay)
+			r := f.handle(text)
+			for _, l := range r[:len(r)-1] {
+				fmt.Fprintln(outWriter, l)
+			}
+			f.updatePending(-len(text) - 1)
+			fmt.Fprintln(outWriter, r[len(r)-1])
+		}
+		outWriter.Close()
+	}()
+	return c
+}
+
+// updatePending tracks the number of unacknowledged bytes.
+func (f *testFirmware) updatePending(delta int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pending += delta
+	f.maxPending = max(f.maxPending, f.pending)
+}
+
+func (f *testFirmware) handle(text string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.corrupt != nil {
+		text = f.corrupt(f.nLines, text)
+	}
+	f.nLines++
+
+	m := testLineRe.FindStringSubmatch(text)
+	if m == nil {
+		return f.reject("Error:No Line Number with checksum, Last Line: " + strconv.Itoa(f.lastLine))
+	}
+	n, _ := strconv.Atoi(m[1])
+	sum, _ := strconv.Atoi(m[3])
+	if sum != int(checksum(text[:strings.LastIndexByte(text, '*')])) {
+		return f.reject("Error:checksum mismatch, Last Line: " + strconv.Itoa(f.lastLine))
+	}
+	if n != f.lastLine+1 && !strings.HasPrefix(m[2], "M110") {
+		return f.reject("Error:Line Number is not Last Line Number+1, Last Line: " + strconv.Itoa(f.lastLine))
+	}
+
+	f.lastLine = n
+	f.commands = append(f.commands, m[2])
+	var r []string
+	if f.respond != nil {
+		r = f.respond(m[2])
+	}
+	return append(r, "ok")
+}
+
+// reject assumes a lock.
+func (f *testFirmware) reject(message string) []string {
+	format := f.resendFormat
+	if format == "" {
+		format = "Resend: %d"
+	}
+	return []string{message, fmt.Sprintf(format, f.lastLine+1), "ok"}
+}
+
+func (f *testFirmware) received() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.commands)
+}
+
+// responseCollector is a response handler that remembers responses and notifies when "ok"
+// is received.
+type responseCollector struct {
+	responses []string
+	done      chan struct{}
+}
+
+func newResponseCollector() *responseCollector {
+	return &responseCollector{done: make(chan struct{})}
+}
+
+func (c *responseCollector) handle(response string) {
+	c.responses = append(c.responses, response)
+	if isOk(response) {
+		close(c.done)
+	}
+}
+
+func (c *responseCollector) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case <-c.done:
+		return c.responses
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for ok, got %q", c.responses)
+		return nil
+	}
+}
+
+func TestRepRap_Send(t *testing.T) {
+	f := &testFirmware{}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	var collectors []*responseCollector
+	commands := []string{"G28", "G1 X10 Y10 ; move", "M84"}
+	for _, cmd := range commands {
+		c := newResponseCollector()
+		collectors = append(collectors, c)
+		if err := p.Send(NewCommand(cmd, c.handle)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	for i, c := range collectors {
+		if r := c.wait(t); !slices.Equal(r, []string{"ok"}) {
+			t.Errorf("Command %v: want [ok], got %q", i, r)
+		}
+	}
+
+	want := []string{"M110 N0", "M115", "G28", "G1 X10 Y10", "M84"}
+	if got := f.received(); !slices.Equal(got, want) {
+		t.Errorf("Unexpected commands: want %q, got %q", want, got)
+	}
+}
+
+// ackConn acknowledges every line as it is written, before Write returns.
+type ackConn struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (c *ackConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *ackConn) Write(b []byte) (int, error) {
+	if _, err := c.w.Write([]byte("ok\n")); err != nil {
+		return 0, err
+	}
+	// give the acknowledgement time to be received
+	time.Sleep(time.Millisecond)
+	return len(b), nil
+}
+
+func (c *ackConn) Close() error {
+	_ = c.w.Close()
+	return c.r.Close()
+}
+
+func TestRepRap_EventOrder(t *testing.T) {
+	r, w := io.Pipe()
+	p := NewRepRap(&ackConn{r: r, w: w}, Options{})
+	defer p.Close()
+	<-p.Identified()
+
+	// every response must come after the line it responds to
+	var sent int
+	var lock sync.Mutex
+	p.Subscribe(func(e Event) {
+		if e, ok := e.(LineSent); ok && strings.Contains(e.Line, "M105") {
+			lock.Lock()
+			sent++
+			lock.Unlock()
+		}
+	})
+
+	var last *responseCollector
+	for i := 0; i < 20; i++ {
+		i, c := i, newResponseCollector()
+		handle := func(response string) {
+			lock.Lock()
+			if sent <= i {
+				t.Errorf("Response to line %d received before the line was sent", i)
+			}
+			lock.Unlock()
+			c.handle(response)
+		}
+		if err := p.Send(NewCommand("M105", handle)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		last = c
+	}
+	last.wait(t)
+}
+
+func TestRepRap_ResponseRouting(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string {
+			switch command {
+			case "M105":
+				return []string{"T:20.0 /0.0 B:19.5 /0.0"}
+			case "M20":
+				return []string{"Begin file list", "A.GCO 123", "End file list"}
+			}
+			return nil
+		},
+	}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{command: "M105", want: []string{"T:20.0 /0.0 B:19.5 /0.0", "ok"}},
+		{command: "M20", want: []string{"Begin file list", "A.GCO 123", "End file list", "ok"}},
+		{command: "G90", want: []string{"ok"}},
+	}
+
+	var collectors []*responseCollector
+	for _, tt := range tests {
+		c := newResponseCollector()
+		collectors = append(collectors, c)
+		if err := p.Send(NewCommand(tt.command, c.handle)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	for i, tt := range tests {
+		if got := collectors[i].wait(t); !slices.Equal(got, tt.want) {
+			t.Errorf("%v: want %q, got %q", tt.command, tt.want, got)
+		}
+	}
+}
+
+func TestRepRap_Resend(t *testing.T) {
+	flipChecksum := func(text string) string {
+		return text[:len(text)-1] + string(text[len(text)-1]^1)
+	}
+	dropLineNumber := func(text string) string {
+		return text[strings.IndexByte(text, ' ')+1:]
+	}
+
+	flowControls := []struct {
+		name        string
+		flowControl FlowControl
+	}{
+		{name: "PingPong", flowControl: PingPong},
+		{name: "CharacterCounting", flowControl: CharacterCounting},
+	}
+
+	for _, fc := range flowControls {
+		tests := []struct {
+			name         string
+			resendFormat string
+			corrupt      func(n int, text string) string
+		}{
+			{
+				name: "Checksum",
+				corrupt: func(n int, text string) string {
+					if n == 2 {
+						return flipChecksum(text)
+					}
+					return text
+				},
+			},
+			{
+				name: "LineNumber",
+				corrupt: func(n int, text string) string {
+					if n == 3 {
+						return dropLineNumber(text)
+					}
+					return text
+				},
+			},
+			{
+				name: "SameLineTwice",
+				corrupt: func(n int, text string) string {
+					if n == 2 || n == 3 {
+						return flipChecksum(text)
+					}
+					return text
+				},
+			},
+			{
+				name: "EveryOtherLine",
+				corrupt: func() func(int, string) string {
+					corrupted := make(map[string]bool)
+					return func(n int, text string) string {
+						if n%2 == 1 && !corrupted[text] {
+							corrupted[text] = true
+							return flipChecksum(text)
+						}
+						return text
+					}
+				}(),
+			},
+			{
+				name:         "Repetier",
+				resendFormat: "rs %d",
+				corrupt: func(n int, text string) string {
+					if n == 2 {
+						return flipChecksum(text)
+					}
+					return text
+				},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(fc.name+"_"+tt.name, func(t *testing.T) {
+				f := &testFirmware{
+					corrupt:      tt.corrupt,
+					resendFormat: tt.resendFormat,
+					delay:        time.Millisecond,
+				}
+				p := NewRepRap(f.open(), Options{FlowControl: fc.flowControl})
+				defer p.Close()
+
+				commands := []string{"G28", "G1 X10", "G1 Y10", "G1 Z10", "G1 X20", "G1 Y20", "G1 Z20", "M84"}
+				var collectors []*responseCollector
+				for _, cmd := range commands {
+					c := newResponseCollector()
+					collectors = append(collectors, c)
+					if err := p.Send(NewCommand(cmd, c.handle)); err != nil {
+						t.Fatalf("Send failed: %v", err)
+					}
+				}
+
+				for i, c := range collectors {
+					if r := c.wait(t); !slices.Equal(r, []string{"ok"}) {
+						t.Errorf("%v: want [ok], got %q", commands[i], r)
+					}
+				}
+
+				want := append([]string{"M110 N0", "M115"}, commands...)
+				if got := f.received(); !slices.Equal(got, want) {
+					t.Errorf("Unexpected commands: want %q, got %q", want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestRepRap_Resend_NotInHistory(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string {
+			if command == "M400" {
+				return []string{"Resend: 1"}
+			}
+			return nil
+		},
+	}
+	p := NewRepRap(f.open(), Options{HistorySize: 2})
+	defer p.Close()
+
+	for _, cmd := range []string{"G28", "G1 X10", "G1 Y10", "M400"} {
+		if err := p.Send(NewCommand(cmd, nil)); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for an error")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRepRap_FlowControl(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     Options
+		wantPending func(t *testing.T, maxPending int)
+	}{
+		{
+			name:    "PingPong",
+			options: Options{FlowControl: PingPong},
+			wantPending: func(t *testing.T, maxPending int) {
+				if maxPending > len("N10 G1 X10 Y10*00\n") {
+					t.Errorf("More than one line in flight: %v bytes", maxPending)
+				}
+			},
+		},
+		{
+			name:    "CharacterCounting",
+			options: Options{FlowControl: CharacterCounting, BufferSize: 64},
+			wantPending: func(t *testing.T, maxPending int) {
+				if maxPending > 64 {
+					t.Errorf("Buffer overflow: %v bytes", maxPending)
+				}
+				if maxPending <= len("N10 G1 X10 Y10*00\n") {
+					t.Errorf("No lines sent ahead: %v bytes", maxPending)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &testFirmware{delay: time.Millisecond}
+			p := NewRepRap(f.open(), tt.options)
+			defer p.Close()
+
+			var want []string
+			var last *responseCollector
+			for i := 0; i < 30; i++ {
+				cmd := fmt.Sprintf("G1 X%v Y%v", i, i)
+				want = append(want, cmd)
+				last = newResponseCollector()
+				if err := p.Send(NewCommand(cmd, last.handle)); err != nil {
+					t.Fatalf("Send failed: %v", err)
+				}
+			}
+			last.wait(t)
+
+			if got := f.received(); !slices.Equal(got[2:], want) {
+				t.Errorf("Unexpected commands: want %q, got %q", want, got[2:])
+			}
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			tt.wantPending(t, f.maxPending)
+		})
+	}
+}
+
+func TestRepRap_Send_InvalidCommand(t *testing.T) {
+	p := NewRepRap(new(testFirmware).open(), Options{})
+	defer p.Close()
+
+	for _, cmd := range []string{"", "  ", "; comment", "G28\nG1 X0"} {
+		if err := p.Send(NewCommand(cmd, nil)); err == nil {
+			t.Errorf("Send(%q): want error, got nil", cmd)
+		}
+	}
+}
+
+func TestRepRap_Send_Closed(t *testing.T) {
+	p := NewRepRap(new(testFirmware).open(), Options{})
+	p.Close()
+
+	if err := p.Send(NewCommand("G28", nil)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Send after Close: want ErrClosed, got %v", err)
+	}
+}
+
+func TestRepRap_Done(t *testing.T) {
+	p := NewRepRap(new(testFirmware).open(), Options{})
+	select {
+	case <-p.Done():
+		t.Fatalf("Done before Close")
+	default:
+	}
+	p.Close()
+	<-p.Done()
+
+	// the connection is closed by the other side
+	r, w := io.Pipe()
+	p = NewRepRap(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{r, io.Discard, r}, Options{})
+	defer p.Close()
+	w.Close()
+
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for Done")
+	}
+	if err := p.Err(); !errors.Is(err, io.EOF) {
+		t.Errorf("Err: want EOF, got %v", err)
+	}
+}
+
+func TestFormatLine(t *testing.T) {
+	tests := []struct {
+		line    int
+		command string
+		want    string
+	}{
+		{line: 3, command: "T0", want: "N3 T0*57\n"},
+		{line: 0, command: "M110 N0", want: "N0 M110 N0*125\n"},
+	}
+
+	for _, tt := range tests {
+		if got := formatLine(tt.line, tt.command); got != tt.want {
+			t.Errorf("formatLine(%v, %q): want %q, got %q", tt.line, tt.command, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package printer
+
+import (
+	"context"
+	"strings"
+)
+
+// Response is the complete response of the printer to a command.
+type Response struct {
+	// Lines are all lines received from the printer while the command was being executed,
+	// including the final "ok".
+	Lines []string
+
+	// Err is nil if the printer executed the command successfully. It is a *FirmwareError
+	// if the printer reported an error, or the error that stopped communication before the
+	// command was acknowledged.
+	Err error
+}
+
+// FirmwareError is an error reported by the firmware with an "Error:" line.
+type FirmwareError struct {
+	// Message is the text following "Error:".
+	Message string
+}
+
+func (e *FirmwareError) Error() string {
+	return "printer: firmware error: " + e.Message
+}
+
+// Future is the response to a command that may not have been received yet.
+// Use RepRap.Request to create instances of Future.
+type Future struct {
+	lines    []string
+	err      error
+	response Response
+	done     chan struct{}
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed when the response is complete.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Response returns the response. It must be called only after Done is closed.
+func (f *Future) Response() Response {
+	<-f.done
+	return f.response
+}
+
+// Wait waits for the response to be complete. It returns the response and its Err, or
+// the context's error if the context is done first. In the latter case the command is
+// still executed, and the response can be received later.
+func (f *Future) Wait(ctx context.Context) (Response, error) {
+	select {
+	case <-f.done:
+		return f.response, f.response.Err
+	case <-ctx.Done():
+		return Response{}, ctx.Err()
+	}
+}
+
+// add adds a line of the response and reports whether the response is complete.
+// add assumes the lock of the printer executing the command.
+func (f *Future) add(line string) bool {
+	if f.isDone() {
+		// the response timed out or was cancelled
+		return false
+	}
+	f.lines = append(f.lines, line)
+	if message, ok := strings.CutPrefix(line, "Error:"); ok && f.err == nil {
+		f.err = &FirmwareError{Message: strings.TrimSpace(message)}
+	}
+	if !isOk(line) {
+		return false
+	}
+	f.complete(f.err)
+	return true
+}
+
+// complete completes the response with the lines added so far. Further calls have
+// no effect. complete assumes the lock of the printer executing the command.
+func (f *Future) complete(err error) {
+	if f.isDone() {
+		return
+	}
+	f.response = Response{Lines: f.lines, Err: err}
+	close(f.done)
+}
+
+func (f *Future) isDone() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,105 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepRap_Request(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string {
+			switch command {
+			case "M119":
+				return []string{"Reporting endstop status", "x_min: open", "y_min: TRIGGERED"}
+			case "M999":
+				return []string{"Error:Unknown command", "echo:see the log"}
+			}
+			return nil
+		},
+	}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	future, err := p.Request("M119")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	r, err := future.Wait(ctx)
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	want := []string{"Reporting endstop status", "x_min: open", "y_min: TRIGGERED", "ok"}
+	if !slices.Equal(r.Lines, want) {
+		t.Errorf("Lines: want %q, got %q", want, r.Lines)
+	}
+	select {
+	case <-future.Done():
+	default:
+		t.Errorf("Done is not closed after Wait")
+	}
+	if got := future.Response(); !slices.Equal(got.Lines, want) || got.Err != nil {
+		t.Errorf("Unexpected response %+v", got)
+	}
+
+	future, _ = p.Request("M999")
+	r, err = future.Wait(ctx)
+	var firmwareError *FirmwareError
+	if !errors.As(err, &firmwareError) || firmwareError.Message != "Unknown command" {
+		t.Errorf("Wait: want a firmware error, got %v", err)
+	}
+	if r.Err != err || len(r.Lines) != 3 {
+		t.Errorf("Unexpected response %+v", r)
+	}
+}
+
+func TestRepRap_Request_Resend(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string { return []string{"echo:" + command} },
+		corrupt: func(n int, text string) string {
+			if n == 2 {
+				return text + "x"
+			}
+			return text
+		},
+	}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	future, _ := p.Request("M114")
+	r, err := future.Wait(context.Background())
+	if want := []string{"echo:M114", "ok"}; err != nil || !slices.Equal(r.Lines, want) {
+		t.Errorf("Wait: want %q, got %q, %v", want, r.Lines, err)
+	}
+}
+
+func TestRepRap_Request_Stopped(t *testing.T) {
+	f := &testFirmware{delay: 50 * time.Millisecond}
+	p := NewRepRap(f.open(), Options{})
+
+	futures := make([]*Future, 3)
+	for i := range futures {
+		futures[i], _ = p.Request("G4 S1")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := futures[0].Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait with a timeout: want DeadlineExceeded, got %v", err)
+	}
+
+	p.Close()
+	for i, future := range futures {
+		if _, err := future.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+			t.Errorf("Wait for command %d: want ErrClosed, got %v", i, err)
+		}
+	}
+	if _, err := p.Request("G28"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Request after Close: want ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package printer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SDFile is a file on the printer's SD card.
+type SDFile struct {
+	// Name is the short DOS name of the file used in commands, including the directory,
+	// e.g. "/PRINTS/BENCHY~1.GCO".
+	Name string
+
+	// LongName is the long name of the file, e.g. "Benchy 0.2mm.gcode", or empty if the
+	// firmware does not report long names.
+	LongName string
+
+	// Size is the size of the file in bytes, or -1 if not reported.
+	Size int64
+}
+
+// DisplayName returns the long name of the file if known, or the short name otherwise.
+func (f SDFile) DisplayName() string {
+	if f.LongName != "" {
+		return f.LongName
+	}
+	return f.Name
+}
+
+// SDStatus is the status of printing from the SD card.
+type SDStatus struct {
+	// Printing is set while the printer prints a file from the SD card.
+	Printing bool
+
+	// Paused is set when printing was paused with SDCard.Pause. Firmwares keep reporting
+	// the position in a paused file, so a pause by other means is not detected.
+	Paused bool
+
+	// Finished is set when the printer reports that it printed the whole file.
+	Finished bool
+
+	// Bytes is the position in the selected file, TotalBytes the size of the file.
+	Bytes, TotalBytes int64
+}
+
+// Fraction returns the progress by bytes as a number between 0 and 1.
+func (s SDStatus) Fraction() float64 {
+	if s.TotalBytes == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.TotalBytes)
+}
+
+var (
+	sdProgressRe = regexp.MustCompile(`^SD printing byte (\d+)/(\d+)`)
+	sdFileRe     = regexp.MustCompile(`^(\S+)(?:\s+(\d+))?(?:\s+(.*))?$`)
+)
+
+// SDCard manages files on the printer's SD card and printing from it. It also tracks the
+// progress of SD printing and delivers it to subscribers: with M27 auto-reporting if the
+// firmware advertises AUTOREPORT_SD_STATUS, or by polling with M27 while printing
+// otherwise.
+//
+// The methods of SDCard send commands and wait for the printer to acknowledge them. They
+// must not be called from response handlers or event handlers.
+//
+// Use NewSDCard to create instances of SDCard.
+type SDCard struct {
+	printer     *RepRap
+	interval    time.Duration
+	status      broadcaster[SDStatus]
+	latest      SDStatus
+	polling     atomic.Bool
+	unsubscribe func()
+	ready       chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	lock        sync.Mutex
+}
+
+// NewSDCard starts tracking SD printing on a printer. Reporting starts once the printer's
+// capabilities are known. Interval is rounded to whole seconds for auto-reporting.
+func NewSDCard(printer *RepRap, interval time.Duration) *SDCard {
+	c := &SDCard{
+		printer:  printer,
+		interval: interval,
+		ready:    make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.unsubscribe = printer.Subscribe(c.handleEvent)
+	go c.run()
+	return c
+}
+
+// Subscribe adds a handler which receives the status of SD printing whenever the printer
+// reports it. The handler is called synchronously and must not block. Call unsubscribe to
+// remove the handler.
+func (c *SDCard) Subscribe(handler func(SDStatus)) (unsubscribe func()) {
+	return c.status.subscribe(handler)
+}
+
+// Status returns the most recently reported status of SD printing.
+func (c *SDCard) Status() SDStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.latest
+}
+
+// Close stops tracking SD printing. If auto-reporting was enabled, it is disabled.
+func (c *SDCard) Close() {
+	close(c.stop)
+	<-c.done
+	c.unsubscribe()
+}
+
+// List returns the files on the SD card. Long names are requested with M20 L if the
+// firmware advertises EXTENDED_M20.
+func (c *SDCard) List(ctx context.Context) ([]SDFile, error) {
+	command := "M20"
+	if caps, _ := c.printer.Capabilities(); caps.Has(CapExtendedM20) {
+		command = "M20 L"
+	}
+	r, err := c.printer.SendContext(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	return parseFileList(r.Lines), nil
+}
+
+// Select opens a file for printing. The name can be the short or, with some firmwares,
+// the long name.
+func (c *SDCard) Select(ctx context.Context, name string) error {
+	r, err := c.printer.SendContext(ctx, "M23 "+name)
+	if err != nil {
+		return err
+	}
+	if response, failed := findResponse(r.Lines, "open failed"); failed {
+		return fmt.Errorf("printer: cannot select %q: %s", name, response)
+	}
+
+	for _, line := range r.Lines {
+		if _, size, ok := parseFileOpened(line); ok {
+			c.lock.Lock()
+			c.latest = SDStatus{TotalBytes: size}
+			c.lock.Unlock()
+		}
+	}
+	return nil
+}
+
+// Start starts printing the selected file, or resumes a paused print.
+func (c *SDCard) Start(ctx context.Context) error {
+	if _, err := c.printer.SendContext(ctx, "M24"); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.latest.Printing, c.latest.Paused, c.latest.Finished = true, false, false
+	status := c.latest
+	c.lock.Unlock()
+
+	c.polling.Store(true)
+	c.status.publish(status)
+	return nil
+}
+
+// Print selects a file and starts printing it.
+func (c *SDCard) Print(ctx context.Context, name string) error {
+	if err := c.Select(ctx, name); err != nil {
+		return err
+	}
+	return c.Start(ctx)
+}
+
+// Pause pauses printing from the SD card. Use Start to resume.
+func (c *SDCard) Pause(ctx context.Context) error {
+	if _, err := c.printer.SendContext(ctx, "M25"); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.latest.Printing, c.latest.Paused = false, true
+	status := c.latest
+	c.lock.Unlock()
+
+	c.status.publish(status)
+	return nil
+}
+
+// Delete deletes a file.
+func (c *SDCard) Delete(ctx context.Context, name string) error {
+	r, err := c.printer.SendContext(ctx, "M30 "+name)
+	if err != nil {
+		return err
+	}
+	if response, failed := findResponse(r.Lines, "Deletion failed"); failed {
+		return fmt.Errorf("printer: cannot delete %q: %s", name, response)
+	}
+	return nil
+}
+
+// Upload writes G-code read from r to a file on the SD card. Comments and blank lines are
+// removed. Progress, if not nil, is called with the number of bytes read from r whenever
+// the printer acknowledges a line. Marlin requires a short DOS name, e.g. "PART.GCO".
+//
+// The firmware writes all commands it receives to the file until the upload ends, so
+// Upload holds the printer with RepRap.Acquire, and the commands of others, e.g. polling
+// temperatures, wait until the upload ends. Upload fails if the printer is already held,
+// or while a file from the SD card is being printed.
+//
+// If the context is done, Upload stops sending lines and closes the file, which then
+// contains the lines sent so far. The same happens if the lines are discarded by
+// Quickstop, and Upload returns ErrCancelled.
+func (c *SDCard) Upload(ctx context.Context, name string, r io.Reader, progress func(bytes int64)) error {
+	select {
+	case <-c.ready:
+	case <-c.done:
+	}
+	if s := c.Status(); s.Printing || s.Paused {
+		return errors.New("printer: cannot upload while printing from the SD card")
+	}
+	held, err := c.printer.Acquire()
+	if err != nil {
+		return err
+	}
+	defer held.Release()
+
+	response, err := held.SendContext(ctx, "M28 "+name)
+	if err != nil {
+		if ctx.Err() != nil {
+			// M28 may have been sent already
+			_, _ = held.SendContext(context.Background(), "M29")
+		}
+		return err
+	}
+	if response, failed := findResponse(response.Lines, "open failed"); failed {
+		return fmt.Errorf("printer: cannot write %q: %s", name, response)
+	}
+
+	// like Job, keep only a few lines in the printer's queue
+	window := make(chan struct{}, DefaultJobWindow)
+	cancelled := make(chan struct{})
+	var cancelOnce sync.Once
+	br := bufio.NewReader(r)
+	var read int64
+	for {
+		line, readErr := br.ReadString('\n')
+		read += int64(len(line))
+		if command := stripComment(line); command != "" {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				_, _ = held.SendContext(context.Background(), "M29")
+				return ctx.Err()
+			case <-cancelled:
+				_, _ = held.SendContext(context.Background(), "M29")
+				return ErrCancelled
+			case <-held.Done():
+				return held.Err()
+			}
+			bytes := read
+			cmd := NewCommand(command, func(response string) {
+				if !isOk(response) {
+					return
+				}
+				<-window
+				if progress != nil {
+					progress(bytes)
+				}
+			})
+			err := held.Send(cmd.OnCancel(func() {
+				cancelOnce.Do(func() { close(cancelled) })
+			}))
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			_, _ = held.SendContext(context.Background(), "M29")
+			return readErr
+		}
+	}
+
+	_, err = held.SendContext(ctx, "M29")
+	return err
+}
+
+func (c *SDCard) handleEvent(e Event) {
+	r, ok := e.(LineReceived)
+	if !ok {
+		return
+	}
+
+	c.lock.Lock()
+	status := c.latest
+	switch {
+	case r.Line == "Not SD printing":
+		status.Printing, status.Paused = false, false
+	case r.Line == "Done printing file":
+		status.Printing, status.Paused, status.Finished = false, false, true
+		status.Bytes = status.TotalBytes
+	default:
+		m := sdProgressRe.FindStringSubmatch(r.Line)
+		if m == nil {
+			c.lock.Unlock()
+			return
+		}
+		status.Bytes, _ = strconv.ParseInt(m[1], 10, 64)
+		status.TotalBytes, _ = strconv.ParseInt(m[2], 10, 64)
+		status.Printing, status.Finished = !status.Paused, false
+	}
+	c.latest = status
+	c.lock.Unlock()
+
+	if !status.Printing {
+		c.polling.Store(false)
+	}
+	c.status.publish(status)
+}
+
+func (c *SDCard) run() {
+	defer close(c.done)
+
+	select {
+	case <-c.printer.Identified():
+	case <-c.stop:
+		return
+	}
+
+	if caps, _ := c.printer.Capabilities(); caps.Has(CapAutoReportSDStatus) {
+		seconds := max(1, int(math.Round(c.interval.Seconds())))
+		if c.printer.Send(NewCommand(fmt.Sprintf("M27 S%d", seconds), nil)) != nil {
+			return
+		}
+		close(c.ready)
+		<-c.stop
+		_ = c.printer.Send(NewCommand("M27 S0", nil))
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	close(c.ready)
+
+	// as with M105 in TemperatureMonitor, a discarded M27 clears pending when cancelled
+	var pending atomic.Bool
+	for {
+		select {
+		case <-ticker.C:
+			if !c.polling.Load() || !pending.CompareAndSwap(false, true) {
+				continue
+			}
+			cmd := NewCommand("M27", func(response string) {
+				if isOk(response) {
+					pending.Store(false)
+				}
+			}).OnCancel(func() { pending.Store(false) })
+			if c.printer.Send(cmd) != nil {
+				return
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// findResponse returns the first response with the prefix.
+func findResponse(responses []string, prefix string) (string, bool) {
+	for _, r := range responses {
+		if strings.HasPrefix(r, prefix) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// parseFileList parses the response to M20. Marlin lists files between "Begin file list"
+// and "End file list" lines, one per line, with the short name, the size and, for M20 L,
+// the long name.
+func parseFileList(responses []string) []SDFile {
+	var files []SDFile
+	listing := false
+	for _, r := range responses {
+		switch {
+		case r == "Begin file list":
+			listing = true
+		case r == "End file list":
+			listing = false
+		case listing:
+			m := sdFileRe.FindStringSubmatch(r)
+			if m == nil {
+				continue
+			}
+			f := SDFile{Name: m[1], LongName: m[3], Size: -1}
+			if m[2] != "" {
+				f.Size, _ = strconv.ParseInt(m[2], 10, 64)
+			}
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// parseFileOpened parses the response to M23, e.g. "File opened: BENCHY~1.GCO Size: 1234".
+func parseFileOpened(response string) (name string, size int64, ok bool) {
+	rest, ok := strings.CutPrefix(response, "File opened:")
+	if !ok {
+		return "", 0, false
+	}
+	name, sizeText, ok := strings.Cut(rest, " Size:")
+	if !ok {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 10, 64)
+	return strings.TrimSpace(name), size, err == nil
+}
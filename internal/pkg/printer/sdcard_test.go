@@ -0,0 +1,325 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFileList(t *testing.T) {
+	responses := []string{
+		"echo:SD card ok",
+		"Begin file list",
+		"BENCHY~1.GCO 12345 Benchy 0.2mm.gcode",
+		"/PRINTS/CUBE.GCO 678",
+		"NOSIZE.GCO",
+		"End file list",
+		"ok",
+	}
+	want := []SDFile{
+		{Name: "BENCHY~1.GCO", LongName: "Benchy 0.2mm.gcode", Size: 12345},
+		{Name: "/PRINTS/CUBE.GCO", Size: 678},
+		{Name: "NOSIZE.GCO", Size: -1},
+	}
+	if got := parseFileList(responses); !slices.Equal(got, want) {
+		t.Errorf("parseFileList: want %+v, got %+v", want, got)
+	}
+	if got := parseFileList([]string{"ok"}); got != nil {
+		t.Errorf("parseFileList of an empty response: want nil, got %+v", got)
+	}
+}
+
+// waitForSDStatus waits until the card reports a status matching the condition.
+func waitForSDStatus(t *testing.T, c *SDCard, condition func(SDStatus) bool) SDStatus {
+	t.Helper()
+	statuses := make(chan SDStatus, 100)
+	unsubscribe := c.Subscribe(func(s SDStatus) {
+		select {
+		case statuses <- s:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		if s := c.Status(); condition(s) {
+			return s
+		}
+		select {
+		case <-statuses:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the SD status, got %+v", c.Status())
+		}
+	}
+}
+
+func TestSDCard(t *testing.T) {
+	content := strings.Repeat("G1 X10 Y10\n", 100)
+	tests := []struct {
+		name   string
+		script func(command string) ([]string, bool)
+	}{
+		{name: "AutoReport"},
+		{
+			name: "Polling",
+			script: func(command string) ([]string, bool) {
+				if command == "M115" {
+					return []string{"FIRMWARE_NAME:Test", "ok"}, true
+				}
+				return nil, false
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := OpenFake(FakeConfig{
+				Speed:  10,
+				Files:  []FakeFile{{Name: "PART.GCO", LongName: "Part.gcode", Content: content}},
+				Script: tt.script,
+			})
+			p := NewRepRap(conn, Options{})
+			defer p.Close()
+			c := NewSDCard(p, 100*time.Millisecond)
+			defer c.Close()
+			ctx := context.Background()
+			<-p.Identified()
+
+			files, err := c.List(ctx)
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			want := SDFile{Name: "PART.GCO", Size: int64(len(content))}
+			if tt.script == nil {
+				// the simulator advertises EXTENDED_M20
+				want.LongName = "Part.gcode"
+			}
+			if !slices.Equal(files, []SDFile{want}) {
+				t.Errorf("List: want %+v, got %+v", []SDFile{want}, files)
+			}
+
+			if err := c.Print(ctx, "PART.GCO"); err != nil {
+				t.Fatalf("Print failed: %v", err)
+			}
+			s := waitForSDStatus(t, c, func(s SDStatus) bool { return s.Bytes > 0 && s.Bytes < s.TotalBytes })
+			if !s.Printing || s.TotalBytes != int64(len(content)) {
+				t.Errorf("Unexpected status while printing %+v", s)
+			}
+
+			if err := c.Pause(ctx); err != nil {
+				t.Fatalf("Pause failed: %v", err)
+			}
+			if s := c.Status(); s.Printing || !s.Paused {
+				t.Errorf("Unexpected status after pausing %+v", s)
+			}
+			if err := c.Start(ctx); err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+
+			s = waitForSDStatus(t, c, func(s SDStatus) bool { return s.Finished })
+			if s.Printing || s.Bytes != s.TotalBytes || s.Fraction() != 1 {
+				t.Errorf("Unexpected status after finishing %+v", s)
+			}
+		})
+	}
+}
+
+func TestSDCard_Quickstop(t *testing.T) {
+	content := strings.Repeat("G1 X10 Y10\n", 1000)
+	conn, _ := OpenFake(FakeConfig{
+		Speed: 10,
+		Files: []FakeFile{{Name: "PART.GCO", Content: content}},
+		Script: func(command string) ([]string, bool) {
+			if command == "M115" {
+				return []string{"FIRMWARE_NAME:Test", "ok"}, true
+			}
+			return nil, false
+		},
+	})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	c := NewSDCard(p, 10*time.Millisecond)
+	defer c.Close()
+	ctx := context.Background()
+	<-p.Identified()
+
+	if err := c.Print(ctx, "PART.GCO"); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	waitForSDStatus(t, c, func(s SDStatus) bool { return s.Bytes > 0 })
+
+	// homing reports busy after 2 s of simulated time, meanwhile M27 waits in the queue
+	waitBusy := expectLine(t, p, isBusy)
+	_ = p.Send(NewCommand("G28", nil))
+	waitBusy()
+	if err := p.Quickstop(); err != nil {
+		t.Fatalf("Quickstop: %v", err)
+	}
+
+	// polling goes on after the queued M27 was discarded
+	bytes := c.Status().Bytes
+	s := waitForSDStatus(t, c, func(s SDStatus) bool { return s.Bytes > bytes })
+	if !s.Printing {
+		t.Errorf("Unexpected status after the quickstop %+v", s)
+	}
+}
+
+func TestSDCard_Files(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Files: []FakeFile{}})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	c := NewSDCard(p, time.Second)
+	defer c.Close()
+	ctx := context.Background()
+
+	var progress []int64
+	content := "; a comment\nG28 ; home\n\nG1 X10\n"
+	if err := c.Upload(ctx, "new.gco", strings.NewReader(content), func(bytes int64) {
+		progress = append(progress, bytes)
+	}); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if want := []int64{23, int64(len(content))}; !slices.Equal(progress, want) {
+		t.Errorf("Upload progress: want %v, got %v", want, progress)
+	}
+
+	files, _ := c.List(ctx)
+	want := []SDFile{{Name: "NEW.GCO", Size: int64(len("G28\nG1 X10\n"))}}
+	if !slices.Equal(files, want) {
+		t.Errorf("List after upload: want %+v, got %+v", want, files)
+	}
+
+	if err := c.Select(ctx, "missing.gco"); err == nil {
+		t.Errorf("Select of a missing file succeeded")
+	}
+	if err := c.Select(ctx, "NEW.GCO"); err != nil {
+		t.Errorf("Select failed: %v", err)
+	}
+	if s := c.Status(); s.TotalBytes != want[0].Size {
+		t.Errorf("Unexpected status after selecting %+v", s)
+	}
+
+	if err := c.Delete(ctx, "NEW.GCO"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if err := c.Delete(ctx, "NEW.GCO"); err == nil {
+		t.Errorf("Delete of a missing file succeeded")
+	}
+	if files, _ := c.List(ctx); len(files) != 0 {
+		t.Errorf("List after delete: want no files, got %+v", files)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.Upload(cancelled, "CANCEL.GCO", strings.NewReader(content), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Upload with a cancelled context: want Canceled, got %v", err)
+	}
+
+	_ = p.Close()
+	if _, err := c.List(ctx); err == nil {
+		t.Errorf("List of a closed printer succeeded")
+	}
+}
+
+func TestSDCard_Upload_Exclusive(t *testing.T) {
+	// the firmware writes the commands received between M28 and M29 to the file
+	var written []string
+	var writing bool
+	var lock sync.Mutex
+	conn, _ := OpenFake(FakeConfig{
+		Files: []FakeFile{},
+		Script: func(command string) ([]string, bool) {
+			if command == "M115" {
+				// no AUTOREPORT_TEMP, so temperatures are polled with M105
+				return []string{"FIRMWARE_NAME:Test", "Cap:SDCARD:1", "ok"}, true
+			}
+			return nil, false
+		},
+		Corrupt: func(n int, line string) string {
+			m := fakeLineRe.FindStringSubmatch(line)
+			if m == nil {
+				return line
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case strings.HasPrefix(m[2], "M28"):
+				writing = true
+			case m[2] == "M29":
+				writing = false
+			case writing:
+				written = append(written, m[2])
+			}
+			return line
+		},
+	})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	c := NewSDCard(p, time.Second)
+	defer c.Close()
+	m := NewTemperatureMonitor(p, time.Millisecond)
+	defer m.Close()
+	ctx := context.Background()
+
+	// wait until temperatures are polled
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := m.Latest(); !ok; _, ok = m.Latest() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for a temperature report")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var commands []string
+	for i := 0; i < 50; i++ {
+		commands = append(commands, fmt.Sprintf("G1 X%d", i))
+	}
+	var sent bool
+	err := c.Upload(ctx, "PART.GCO", strings.NewReader(strings.Join(commands, "\n")), func(int64) {
+		// the user sends a command during the upload
+		if !sent {
+			sent = true
+			_ = p.Send(NewCommand("M114", nil))
+		}
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	lock.Lock()
+	got := slices.Clone(written)
+	lock.Unlock()
+	if !slices.Equal(got, commands) {
+		t.Errorf("Uploaded file: want %q, got %q", commands, got)
+	}
+	if _, err := p.SendContext(ctx, "M105"); err != nil {
+		t.Errorf("SendContext after the upload: %v", err)
+	}
+}
+
+func TestSDCard_Upload_Held(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Files: []FakeFile{}})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	c := NewSDCard(p, time.Second)
+	defer c.Close()
+	<-p.Identified()
+
+	held, err := p.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if err := c.Upload(context.Background(), "PART.GCO", strings.NewReader("G28\n"), nil); !errors.Is(err, ErrHeld) {
+		t.Errorf("Upload of a held printer: want ErrHeld, got %v", err)
+	}
+	held.Release()
+	if err := c.Upload(context.Background(), "PART.GCO", strings.NewReader("G28\n"), nil); err != nil {
+		t.Errorf("Upload after release: %v", err)
+	}
+}
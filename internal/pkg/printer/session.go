@@ -0,0 +1,308 @@
+package printer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultReconnectInterval is the default value of SessionOptions.ReconnectInterval.
+const DefaultReconnectInterval = 2 * time.Second
+
+// State is the state of a Session.
+type State int
+
+const (
+	// StateDisconnected is the state of a session that is not connected.
+	StateDisconnected = State(iota)
+
+	// StateConnecting is the state of a session opening the connection.
+	StateConnecting
+
+	// StateHandshaking is the state of a session waiting for the firmware to respond to
+	// the first commands, see NewRepRap.
+	StateHandshaking
+
+	// StateReady is the state of a session whose printer accepts commands.
+	StateReady
+
+	// StatePrinting is the state of a session whose printer prints a job.
+	StatePrinting
+
+	// StatePaused is the state of a session whose printer has a paused job.
+	StatePaused
+
+	// StateError is the state of a session whose connection failed.
+	StateError
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateHandshaking:
+		return "handshaking"
+	case StateReady:
+		return "ready"
+	case StatePrinting:
+		return "printing"
+	case StatePaused:
+		return "paused"
+	case StateError:
+		return "error"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Connected reports whether the session has a printer in this state.
+func (s State) Connected() bool {
+	return s >= StateHandshaking && s <= StatePaused
+}
+
+// Status is a snapshot of a session's state.
+type Status struct {
+	State State
+
+	// Printer is the printer connected in the session, or nil if the state is not
+	// connected.
+	Printer *RepRap
+
+	// Err is the error that made the session fail in StateError. In StateConnecting,
+	// Err is the error of the previous connection when reconnecting.
+	Err error
+
+	// Reconnecting reports in StateError whether the session is going to reconnect.
+	Reconnecting bool
+}
+
+// Dialer opens the connection to a printer, e.g. a serial port.
+type Dialer func() (io.ReadWriteCloser, error)
+
+// SessionOptions configure a session. Zero values select reasonable defaults.
+type SessionOptions struct {
+	// Printer configures the printers created in the session.
+	Printer Options
+
+	// Reconnect enables reconnecting when the connection is lost, e.g. because the USB
+	// device disappeared. The session keeps dialing until the connection is open again
+	// or Disconnect is called. Reconnect does not apply to the first connection, and to
+	// firmware halts, which need the user's attention.
+	Reconnect bool
+
+	// ReconnectInterval is the time between attempts to reconnect. The default is
+	// DefaultReconnectInterval.
+	ReconnectInterval time.Duration
+}
+
+// Session manages the lifecycle of the connection to a printer:
+//
+//	Disconnected → Connecting → Handshaking → Ready ⇄ Printing ⇄ Paused
+//
+// Any connected state can end in Error when the connection fails, and Error is followed
+// by Connecting if the session reconnects. Each connection gets a new RepRap printer. The
+// transitions are delivered to subscribers, see Subscribe.
+//
+// Use NewSession to create instances of Session.
+type Session struct {
+	dial    Dialer
+	options SessionOptions
+
+	status      Status
+	statuses    broadcaster[Status]
+	job         *Job
+	unsubscribe func()
+	stop        chan struct{}
+	done        chan struct{}
+	lock        sync.Mutex
+
+	// publishLock is held from changing the status until it is published, so that
+	// subscribers receive the statuses in order. It is acquired before lock.
+	publishLock sync.Mutex
+}
+
+// NewSession creates a disconnected session which opens connections with dial.
+func NewSession(dial Dialer, options SessionOptions) *Session {
+	if options.ReconnectInterval <= 0 {
+		options.ReconnectInterval = DefaultReconnectInterval
+	}
+	return &Session{dial: dial, options: options}
+}
+
+// Subscribe adds a handler which receives the status whenever the state or the printer
+// changes. The handler is called synchronously from internal goroutines and must not
+// block or call Track. Call unsubscribe to remove the handler.
+func (s *Session) Subscribe(handler func(Status)) (unsubscribe func()) {
+	return s.statuses.subscribe(handler)
+}
+
+// Status returns the current status.
+func (s *Session) Status() Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.status
+}
+
+// Printer returns the connected printer, or nil.
+func (s *Session) Printer() *RepRap {
+	return s.Status().Printer
+}
+
+// Connect starts connecting in the background. Connect does nothing if the session is
+// already connecting or connected.
+func (s *Session) Connect() {
+	s.lock.Lock()
+	if s.stop != nil {
+		s.lock.Unlock()
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go s.run(s.stop, s.done)
+	s.lock.Unlock()
+}
+
+// Disconnect closes the connection, or stops connecting, and waits until the session is
+// disconnected.
+func (s *Session) Disconnect() {
+	s.lock.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Track makes the state of the session follow a job printed on the session's printer:
+// StatePrinting while the job prints and StatePaused while it is paused.
+func (s *Session) Track(j *Job) {
+	s.lock.Lock()
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+	}
+	s.job = j
+	s.unsubscribe = j.Subscribe(func(JobProgress) { s.refresh() })
+	s.lock.Unlock()
+
+	s.refresh()
+}
+
+// run connects and reconnects until stop is closed.
+func (s *Session) run(stop, done chan struct{}) {
+	defer close(done)
+
+	var lastErr error
+	for {
+		s.setStatus(Status{State: StateConnecting, Err: lastErr})
+		var identified bool
+		conn, err := s.dial()
+		if err == nil {
+			identified, err = s.serve(NewRepRap(conn, s.options.Printer), stop)
+		} else {
+			err = fmt.Errorf("printer: %w", err)
+		}
+
+		select {
+		case <-stop:
+			s.setStatus(Status{State: StateDisconnected})
+			return
+		default:
+		}
+
+		// reconnect after losing a working connection, and keep trying
+		var halt *HaltError
+		reconnect := s.options.Reconnect && (identified || lastErr != nil) && !errors.As(err, &halt)
+		s.setStatus(Status{State: StateError, Err: err, Reconnecting: reconnect})
+
+		var retry <-chan time.Time
+		if reconnect {
+			lastErr = err
+			retry = time.After(s.options.ReconnectInterval)
+		}
+		select {
+		case <-retry:
+		case <-stop:
+			s.setStatus(Status{State: StateDisconnected})
+			return
+		}
+	}
+}
+
+// serve follows a printer until it stops or stop is closed, and returns the error that
+// stopped it. Identified reports whether the handshake succeeded.
+func (s *Session) serve(p *RepRap, stop chan struct{}) (identified bool, err error) {
+	defer p.Close()
+	s.setStatus(Status{State: StateHandshaking, Printer: p})
+
+	select {
+	case <-p.Identified():
+		s.publishLock.Lock()
+		s.lock.Lock()
+		s.status.State = s.readyState()
+		status := s.status
+		s.lock.Unlock()
+		s.statuses.publish(status)
+		s.publishLock.Unlock()
+	case <-p.Done():
+		return false, p.Err()
+	case <-stop:
+		return false, nil
+	}
+
+	select {
+	case <-p.Done():
+		return true, p.Err()
+	case <-stop:
+		return true, nil
+	}
+}
+
+// refresh updates the state of a ready printer to follow the tracked job, and publishes
+// the status if the state changed.
+func (s *Session) refresh() {
+	s.publishLock.Lock()
+	defer s.publishLock.Unlock()
+	s.lock.Lock()
+	previous := s.status.State
+	if previous != StateReady && previous != StatePrinting && previous != StatePaused {
+		s.lock.Unlock()
+		return
+	}
+	s.status.State = s.readyState()
+	status := s.status
+	s.lock.Unlock()
+
+	if status.State != previous {
+		s.statuses.publish(status)
+	}
+}
+
+// readyState returns the state of an identified printer according to the tracked job.
+// readyState assumes a lock.
+func (s *Session) readyState() State {
+	if s.job != nil {
+		switch s.job.Progress().State {
+		case JobPrinting, JobPausing:
+			return StatePrinting
+		case JobPaused:
+			return StatePaused
+		}
+	}
+	return StateReady
+}
+
+// setStatus replaces the status and publishes it.
+func (s *Session) setStatus(status Status) {
+	s.publishLock.Lock()
+	defer s.publishLock.Unlock()
+	s.lock.Lock()
+	s.status = status
+	s.lock.Unlock()
+	s.statuses.publish(status)
+}
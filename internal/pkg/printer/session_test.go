@@ -0,0 +1,203 @@
+package printer
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stateRecorder records the states published by a session.
+type stateRecorder struct {
+	statuses chan Status
+	states   []State
+	lock     sync.Mutex
+}
+
+func recordStates(s *Session) *stateRecorder {
+	r := &stateRecorder{statuses: make(chan Status, 100)}
+	s.Subscribe(func(status Status) {
+		r.lock.Lock()
+		r.states = append(r.states, status.State)
+		r.lock.Unlock()
+		r.statuses <- status
+	})
+	return r
+}
+
+// waitFor waits until the session publishes the state and returns the status.
+func (r *stateRecorder) waitFor(t *testing.T, state State) Status {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case status := <-r.statuses:
+			if status.State == state {
+				return status
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for state %v, got %v", state, r.recorded())
+		}
+	}
+}
+
+func (r *stateRecorder) recorded() []State {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.states)
+}
+
+func TestSession(t *testing.T) {
+	s := NewSession(func() (io.ReadWriteCloser, error) {
+		return OpenFake(FakeConfig{Speed: 10})
+	}, SessionOptions{})
+	r := recordStates(s)
+
+	s.Connect()
+	status := r.waitFor(t, StateReady)
+	if status.Printer == nil || status.Printer != s.Printer() {
+		t.Errorf("Ready without the printer: %+v", status)
+	}
+
+	j, _ := NewJob(s.Printer(), strings.NewReader(strings.Repeat("G4 P500\n", 4)), JobOptions{Window: 1})
+	s.Track(j)
+	_ = j.Start()
+	r.waitFor(t, StatePrinting)
+	_ = j.Pause()
+	r.waitFor(t, StatePaused)
+	_ = j.Resume()
+	r.waitFor(t, StatePrinting)
+	r.waitFor(t, StateReady)
+
+	s.Disconnect()
+	if status := s.Status(); status.State != StateDisconnected || status.Printer != nil {
+		t.Errorf("Unexpected status after disconnecting %+v", status)
+	}
+	want := []State{
+		StateConnecting, StateHandshaking, StateReady, StatePrinting, StatePaused, StatePrinting,
+		StateReady, StateDisconnected,
+	}
+	if got := r.recorded(); !slices.Equal(got, want) {
+		t.Errorf("States: want %v, got %v", want, got)
+	}
+}
+
+func TestSession_StatusOrder(t *testing.T) {
+	s := NewSession(nil, SessionOptions{})
+	var states []State
+	var lock sync.Mutex
+	handling := make(chan struct{})
+	s.Subscribe(func(status Status) {
+		if status.State == StateConnecting {
+			// a slow subscriber must not let a later status overtake this one
+			close(handling)
+			time.Sleep(20 * time.Millisecond)
+		}
+		lock.Lock()
+		states = append(states, status.State)
+		lock.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.setStatus(Status{State: StateConnecting})
+		close(done)
+	}()
+	<-handling
+	s.setStatus(Status{State: StateDisconnected})
+	<-done
+
+	want := []State{StateConnecting, StateDisconnected}
+	if !slices.Equal(states, want) {
+		t.Errorf("States: want %v, got %v", want, states)
+	}
+}
+
+func TestSession_Reconnect(t *testing.T) {
+	errGone := errors.New("no such device")
+	var conns []io.ReadWriteCloser
+	var lock sync.Mutex
+	s := NewSession(func() (io.ReadWriteCloser, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(conns) == 1 {
+			// the device is gone for a while
+			conns = append(conns, nil)
+			return nil, errGone
+		}
+		conn, err := OpenFake(FakeConfig{})
+		conns = append(conns, conn)
+		return conn, err
+	}, SessionOptions{Reconnect: true, ReconnectInterval: 10 * time.Millisecond})
+	r := recordStates(s)
+	defer s.Disconnect()
+
+	s.Connect()
+	first := r.waitFor(t, StateReady).Printer
+
+	// unplugging the device
+	lock.Lock()
+	_ = conns[0].Close()
+	lock.Unlock()
+	if status := r.waitFor(t, StateError); status.Err == nil || status.Printer != nil || !status.Reconnecting {
+		t.Errorf("Unexpected status after losing the connection %+v", status)
+	}
+	if status := r.waitFor(t, StateError); !errors.Is(status.Err, errGone) {
+		t.Errorf("Unexpected status after failing to reconnect %+v", status)
+	}
+	if status := r.waitFor(t, StateReady); status.Printer == nil || status.Printer == first {
+		t.Errorf("Unexpected status after reconnecting %+v", status)
+	}
+
+	want := []State{
+		StateConnecting, StateHandshaking, StateReady,
+		StateError, StateConnecting, StateError,
+		StateConnecting, StateHandshaking, StateReady,
+	}
+	if got := r.recorded(); !slices.Equal(got, want) {
+		t.Errorf("States: want %v, got %v", want, got)
+	}
+}
+
+func TestSession_NoReconnect(t *testing.T) {
+	t.Run("FirstConnection", func(t *testing.T) {
+		s := NewSession(func() (io.ReadWriteCloser, error) {
+			return nil, errors.New("no such device")
+		}, SessionOptions{Reconnect: true, ReconnectInterval: 10 * time.Millisecond})
+		r := recordStates(s)
+		s.Connect()
+		if status := r.waitFor(t, StateError); status.Reconnecting {
+			t.Errorf("Unexpected status %+v", status)
+		}
+		time.Sleep(50 * time.Millisecond)
+		s.Disconnect()
+
+		want := []State{StateConnecting, StateError, StateDisconnected}
+		if got := r.recorded(); !slices.Equal(got, want) {
+			t.Errorf("States: want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Halt", func(t *testing.T) {
+		s := NewSession(func() (io.ReadWriteCloser, error) {
+			return OpenFake(FakeConfig{})
+		}, SessionOptions{Reconnect: true, ReconnectInterval: 10 * time.Millisecond})
+		r := recordStates(s)
+		s.Connect()
+		_ = r.waitFor(t, StateReady).Printer.EmergencyStop()
+		var halt *HaltError
+		if status := r.waitFor(t, StateError); !errors.As(status.Err, &halt) || status.Reconnecting {
+			t.Errorf("Want HaltError without reconnecting, got %+v", status)
+		}
+		time.Sleep(50 * time.Millisecond)
+		s.Disconnect()
+
+		want := []State{StateConnecting, StateHandshaking, StateReady, StateError, StateDisconnected}
+		if got := r.recorded(); !slices.Equal(got, want) {
+			t.Errorf("States: want %v, got %v", want, got)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package printer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HeaterReading is the state of a single heater in a temperature report.
+type HeaterReading struct {
+	// Name identifies the heater: "T0", "T1" and so on for hotends, "B" for the bed,
+	// "C" for the chamber and "P" for the probe.
+	Name string
+
+	// Actual is the current temperature in °C.
+	Actual float64
+
+	// Target is the target temperature in °C, zero if the heater is off or the target
+	// is not reported.
+	Target float64
+
+	// Power is the raw heater PWM value as reported by the firmware, or -1 if not reported.
+	// The range is 0 to 127 for Marlin and 0 to 255 for Repetier.
+	Power int
+}
+
+// TemperatureSample is a set of heater readings from a single temperature report.
+type TemperatureSample struct {
+	// Time is when the report was received.
+	Time time.Time
+
+	// Heaters are the readings in the order of their appearance in the report.
+	Heaters []HeaterReading
+}
+
+// Heater returns the reading of the heater with the given name.
+func (s TemperatureSample) Heater(name string) (HeaterReading, bool) {
+	for _, h := range s.Heaters {
+		if h.Name == name {
+			return h, true
+		}
+	}
+	return HeaterReading{}, false
+}
+
+var (
+	temperatureRe = regexp.MustCompile(`(?:^|\s)(T\d*|B|C|P):\s*(-?\d+(?:\.\d*)?)(?:\s*/\s*(-?\d+(?:\.\d*)?))?`)
+	heaterPowerRe = regexp.MustCompile(`(?:^|\s)([BC]?)@(\d*):\s*(-?\d+)`)
+)
+
+// ParseTemperatures parses a temperature report, such as a response to M105 or an
+// automatic report enabled by M155. Formats used by Marlin, Klipper, RepRapFirmware and
+// Repetier are supported, for example:
+//
+//	ok T:210.0 /210.0 B:60.0 /60.0 @:127 B@:64
+//	ok B:60.0 /60.0 T0:210.0 /210.0
+//	T:25.00 /0 B:24.47 /0 B@:0 @:0 T0:25.00 /0 @0:0
+//
+// Hotends are always named by their index. A report of the active hotend, "T:", is
+// treated as "T0:" if there are no indexed hotends in the report, and ignored otherwise.
+// The returned sample has no Time. ParseTemperatures returns false if the line is not
+// a temperature report.
+func ParseTemperatures(line string) (TemperatureSample, bool) {
+	var s TemperatureSample
+	hasIndexedTools := false
+	for _, m := range temperatureRe.FindAllStringSubmatch(line, -1) {
+		if len(m[1]) > 1 && m[1][0] == 'T' {
+			hasIndexedTools = true
+		}
+	}
+
+	hasHeater := false
+	for _, m := range temperatureRe.FindAllStringSubmatch(line, -1) {
+		name := m[1]
+		if name == "T" {
+			if hasIndexedTools {
+				continue
+			}
+			name = "T0"
+		}
+		r := HeaterReading{Name: name, Power: -1}
+		r.Actual, _ = strconv.ParseFloat(m[2], 64)
+		if m[3] != "" {
+			r.Target, _ = strconv.ParseFloat(m[3], 64)
+		}
+		if name[0] == 'T' || name == "B" {
+			hasHeater = true
+		}
+		s.Heaters = append(s.Heaters, r)
+	}
+	if !hasHeater {
+		return TemperatureSample{}, false
+	}
+
+	// "@:" is the active hotend, ignore it if there are indexed powers
+	powers := heaterPowerRe.FindAllStringSubmatch(line, -1)
+	hasIndexedPowers := false
+	for _, m := range powers {
+		if m[2] != "" {
+			hasIndexedPowers = true
+		}
+	}
+	for _, m := range powers {
+		name := m[1]
+		switch {
+		case name != "":
+		case m[2] != "":
+			name = "T" + m[2]
+		case !hasIndexedPowers:
+			name = "T0"
+		default:
+			continue
+		}
+		for i := range s.Heaters {
+			if s.Heaters[i].Name == name {
+				s.Heaters[i].Power, _ = strconv.Atoi(m[3])
+			}
+		}
+	}
+
+	return s, true
+}
+
+// TemperatureMonitor parses temperature reports received from a printer and delivers
+// them to subscribers as samples. It also makes the printer report temperatures
+// periodically: with M155 auto-reporting if the firmware advertises AUTOREPORT_TEMP,
+// or by polling with M105 otherwise.
+//
+// Use NewTemperatureMonitor to create instances of TemperatureMonitor.
+type TemperatureMonitor struct {
+	printer     *RepRap
+	interval    time.Duration
+	samples     broadcaster[TemperatureSample]
+	latest      TemperatureSample
+	unsubscribe func()
+	stop        chan struct{}
+	done        chan struct{}
+	lock        sync.Mutex
+}
+
+// NewTemperatureMonitor starts monitoring temperatures of a printer. Reporting starts once
+// the printer's capabilities are known. Interval is rounded to whole seconds for
+// auto-reporting.
+func NewTemperatureMonitor(printer *RepRap, interval time.Duration) *TemperatureMonitor {
+	m := &TemperatureMonitor{
+		printer:  printer,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	m.unsubscribe = printer.Subscribe(m.handleEvent)
+	go m.run()
+	return m
+}
+
+// Subscribe adds a handler which receives temperature samples. The handler is called
+// synchronously and must not block. Call unsubscribe to remove the handler.
+func (m *TemperatureMonitor) Subscribe(handler func(TemperatureSample)) (unsubscribe func()) {
+	return m.samples.subscribe(handler)
+}
+
+// Latest returns the most recent sample. The second return value is false if no samples
+// were received yet.
+func (m *TemperatureMonitor) Latest() (TemperatureSample, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.latest, !m.latest.Time.IsZero()
+}
+
+// Close stops monitoring. If auto-reporting was enabled, it is disabled.
+func (m *TemperatureMonitor) Close() {
+	close(m.stop)
+	<-m.done
+	m.unsubscribe()
+}
+
+func (m *TemperatureMonitor) handleEvent(e Event) {
+	r, ok := e.(LineReceived)
+	if !ok {
+		return
+	}
+	s, ok := ParseTemperatures(r.Line)
+	if !ok {
+		return
+	}
+	s.Time = time.Now()
+
+	m.lock.Lock()
+	m.latest = s
+	m.lock.Unlock()
+
+	m.samples.publish(s)
+}
+
+func (m *TemperatureMonitor) run() {
+	defer close(m.done)
+
+	select {
+	case <-m.printer.Identified():
+	case <-m.stop:
+		return
+	}
+
+	if c, _ := m.printer.Capabilities(); c.Has(CapAutoReportTemp) {
+		seconds := max(1, int(math.Round(m.interval.Seconds())))
+		if m.printer.Send(NewCommand(fmt.Sprintf("M155 S%d", seconds), nil)) != nil {
+			return
+		}
+		<-m.stop
+		_ = m.printer.Send(NewCommand("M155 S0", nil))
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	// don't pile up M105 while the printer is busy with a long command. A discarded M105
+	// is never acknowledged, so its cancel handler clears pending instead.
+	var pending atomic.Bool
+	for {
+		select {
+		case <-ticker.C:
+			if !pending.CompareAndSwap(false, true) {
+				continue
+			}
+			cmd := NewCommand("M105", func(response string) {
+				if isOk(response) {
+					pending.Store(false)
+				}
+			}).OnCancel(func() { pending.Store(false) })
+			if m.printer.Send(cmd) != nil {
+				return
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
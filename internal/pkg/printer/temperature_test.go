@@ -0,0 +1,265 @@
+package printer
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTemperatures(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []HeaterReading
+	}{
+		{
+			name: "Marlin",
+			line: "ok T:210.0 /210.0 B:60.0 /60.0 @:127 B@:64",
+			want: []HeaterReading{
+				{Name: "T0", Actual: 210, Target: 210, Power: 127},
+				{Name: "B", Actual: 60, Target: 60, Power: 64},
+			},
+		},
+		{
+			name: "MarlinAutoReport",
+			line: "T:24.53 /0.00 B:23.91 /0.00 @:0 B@:0",
+			want: []HeaterReading{
+				{Name: "T0", Actual: 24.53, Power: 0},
+				{Name: "B", Actual: 23.91, Power: 0},
+			},
+		},
+		{
+			name: "MarlinMultipleExtruders",
+			line: "ok T:200.00 /200.00 B:25.00 /0.00 T0:200.00 /200.00 T1:25.00 /0.00 @:80 B@:0 @0:80 @1:0",
+			want: []HeaterReading{
+				{Name: "B", Actual: 25, Power: 0},
+				{Name: "T0", Actual: 200, Target: 200, Power: 80},
+				{Name: "T1", Actual: 25, Power: 0},
+			},
+		},
+		{
+			name: "MarlinChamberAndProbe",
+			line: "ok T:25.00 /0.00 B:25.00 /0.00 C:30.00 /40.00 P:24.00 /0.00 @:0 B@:0 C@:255",
+			want: []HeaterReading{
+				{Name: "T0", Actual: 25, Power: 0},
+				{Name: "B", Actual: 25, Power: 0},
+				{Name: "C", Actual: 30, Target: 40, Power: 255},
+				{Name: "P", Actual: 24, Power: -1},
+			},
+		},
+		{
+			name: "MarlinWaiting",
+			line: " T:150.23 E:0 W:?",
+			want: []HeaterReading{
+				{Name: "T0", Actual: 150.23, Power: -1},
+			},
+		},
+		{
+			name: "Klipper",
+			line: "ok B:60.0 /60.0 T0:210.0 /210.0",
+			want: []HeaterReading{
+				{Name: "B", Actual: 60, Target: 60, Power: -1},
+				{Name: "T0", Actual: 210, Target: 210, Power: -1},
+			},
+		},
+		{
+			name: "RepRapFirmware",
+			line: "ok T:25.2 /0.0 B:24.8 /0.0",
+			want: []HeaterReading{
+				{Name: "T0", Actual: 25.2, Power: -1},
+				{Name: "B", Actual: 24.8, Power: -1},
+			},
+		},
+		{
+			name: "Repetier",
+			line: "T:25.00 /0 B:24.47 /0 B@:0 @:0 T0:25.00 /0 @0:0 T1:26.10 /0 @1:0",
+			want: []HeaterReading{
+				{Name: "B", Actual: 24.47, Power: 0},
+				{Name: "T0", Actual: 25, Power: 0},
+				{Name: "T1", Actual: 26.1, Power: 0},
+			},
+		},
+		{
+			name: "NoSpaceBeforeTarget",
+			line: "ok T:-14.5/0 B:20/50",
+			want: []HeaterReading{
+				{Name: "T0", Actual: -14.5, Power: -1},
+				{Name: "B", Actual: 20, Target: 50, Power: -1},
+			},
+		},
+		{name: "Ok", line: "ok"},
+		{name: "Position", line: "X:10.00 Y:10.00 Z:0.00 E:0.00 Count X:800 Y:800 Z:0"},
+		{name: "Echo", line: "echo:Unknown command: \"foo\""},
+		{name: "ProbeOnly", line: "P:24.00 /0.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTemperatures(tt.line)
+			if ok != (tt.want != nil) {
+				t.Fatalf("want ok %v, got %v", tt.want != nil, ok)
+			}
+			if !reflect.DeepEqual(got.Heaters, tt.want) {
+				t.Errorf("want %+v, got %+v", tt.want, got.Heaters)
+			}
+		})
+	}
+}
+
+// sentCommands records commands received by the fake printer.
+type sentCommands struct {
+	commands []string
+	lock     sync.Mutex
+}
+
+func (s *sentCommands) script(command string) ([]string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands = append(s.commands, command)
+	return nil, false
+}
+
+func (s *sentCommands) count(prefix string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := 0
+	for _, c := range s.commands {
+		if strings.HasPrefix(c, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func waitForSamples(t *testing.T, m *TemperatureMonitor, n int) []TemperatureSample {
+	samples := make(chan TemperatureSample, n)
+	unsubscribe := m.Subscribe(func(s TemperatureSample) {
+		select {
+		case samples <- s:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	var got []TemperatureSample
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case s := <-samples:
+			got = append(got, s)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for samples, got %v", len(got))
+		}
+	}
+	return got
+}
+
+func TestTemperatureMonitor_AutoReport(t *testing.T) {
+	var sent sentCommands
+	conn, _ := OpenFake(FakeConfig{Speed: 100, Script: sent.script})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+
+	m := NewTemperatureMonitor(p, time.Second)
+	for _, s := range waitForSamples(t, m, 3) {
+		if _, ok := s.Heater("T0"); !ok || s.Time.IsZero() {
+			t.Errorf("Unexpected sample %+v", s)
+		}
+	}
+	if _, ok := m.Latest(); !ok {
+		t.Errorf("No latest sample")
+	}
+	m.Close()
+
+	// M155 S0 is sent asynchronously, wait for it to be processed
+	done := make(chan struct{})
+	_ = p.Send(NewCommand("M400", func(response string) {
+		if isOk(response) {
+			close(done)
+		}
+	}))
+	<-done
+
+	if got := sent.count("M155 S1"); got != 1 {
+		t.Errorf("Want M155 S1 once, got %v", got)
+	}
+	if got := sent.count("M155 S0"); got != 1 {
+		t.Errorf("Want M155 S0 once, got %v", got)
+	}
+	if got := sent.count("M105"); got != 0 {
+		t.Errorf("Want no M105, got %v", got)
+	}
+}
+
+func TestTemperatureMonitor_Polling(t *testing.T) {
+	var sent sentCommands
+	conn, _ := OpenFake(FakeConfig{
+		Speed: 100,
+		Script: func(command string) ([]string, bool) {
+			if command == "M115" {
+				return []string{"FIRMWARE_NAME:Legacy EXTRUDER_COUNT:1", "ok"}, true
+			}
+			return sent.script(command)
+		},
+	})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+
+	m := NewTemperatureMonitor(p, 10*time.Millisecond)
+	defer m.Close()
+
+	for _, s := range waitForSamples(t, m, 3) {
+		if r, ok := s.Heater("B"); !ok || r.Actual != 25 {
+			t.Errorf("Unexpected sample %+v", s)
+		}
+	}
+	if got := sent.count("M155"); got != 0 {
+		t.Errorf("Want no M155, got %v", got)
+	}
+}
+
+func TestTemperatureMonitor_CloseBeforeIdentified(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{
+		Script: func(command string) ([]string, bool) {
+			// never respond to M115
+			return nil, command == "M115"
+		},
+	})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+
+	m := NewTemperatureMonitor(p, time.Second)
+	m.Close()
+}
+
+func TestTemperatureMonitor_Quickstop(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{
+		Speed: 10,
+		Script: func(command string) ([]string, bool) {
+			if command == "M115" {
+				return []string{"FIRMWARE_NAME:Legacy EXTRUDER_COUNT:1", "ok"}, true
+			}
+			return nil, false
+		},
+	})
+	p := NewRepRap(conn, Options{})
+	defer p.Close()
+	<-p.Identified()
+
+	m := NewTemperatureMonitor(p, 10*time.Millisecond)
+	defer m.Close()
+	waitForSamples(t, m, 1)
+
+	// homing reports busy after 2 s of simulated time, meanwhile M105 waits in the queue
+	waitBusy := expectLine(t, p, isBusy)
+	_ = p.Send(NewCommand("G28", nil))
+	waitBusy()
+	if err := p.Quickstop(); err != nil {
+		t.Fatalf("Quickstop: %v", err)
+	}
+
+	// polling goes on after the queued M105 was discarded
+	waitForSamples(t, m, 2)
+}
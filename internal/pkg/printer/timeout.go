@@ -0,0 +1,104 @@
+package printer
+
+import (
+	"errors"
+	"reprapctl/pkg/gcode"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is the error of a response that was not received in time.
+var ErrTimeout = errors.New("printer: command timed out")
+
+// Timeouts used by DefaultTimeout.
+const (
+	// ShortTimeout is the timeout of queries answered immediately, such as M105.
+	ShortTimeout = 5 * time.Second
+
+	// NormalTimeout is the timeout of most commands.
+	NormalTimeout = time.Minute
+
+	// LongTimeout is the timeout of commands that take minutes, such as heating and
+	// homing.
+	LongTimeout = 10 * time.Minute
+)
+
+// shortCommands are queries which the firmware answers without waiting for other
+// commands to finish.
+var shortCommands = map[string]bool{
+	"M27":  true, // SD status
+	"M31":  true, // print time
+	"M105": true, // temperatures
+	"M114": true, // position
+	"M115": true, // firmware info
+	"M119": true, // endstops
+}
+
+// longCommands wait for the printer to heat, move or calibrate.
+var longCommands = map[string]bool{
+	"G28":  true, // home
+	"G29":  true, // bed leveling
+	"G33":  true, // delta calibration
+	"G34":  true, // Z alignment
+	"M109": true, // wait for hotend temperature
+	"M190": true, // wait for bed temperature
+	"M191": true, // wait for chamber temperature
+	"M303": true, // PID autotune
+	"M400": true, // wait for moves
+	"M600": true, // filament change
+}
+
+// DefaultTimeout is the default value of Options.Timeout. It returns LongTimeout for
+// commands like M109, M190, G28 and G29, ShortTimeout for queries like M105, and
+// NormalTimeout for other commands.
+func DefaultTimeout(command string) time.Duration {
+	l, err := gcode.ParseLine(command)
+	switch code := l.Code(); {
+	case err != nil:
+		return NormalTimeout
+	case longCommands[code]:
+		return LongTimeout
+	case shortCommands[code]:
+		return ShortTimeout
+	default:
+		return NormalTimeout
+	}
+}
+
+// isBusy reports whether a response is a keepalive sent by the firmware while it executes
+// a long command, e.g. "echo:busy: processing".
+func isBusy(response string) bool {
+	return strings.HasPrefix(response, "busy:") || strings.HasPrefix(response, "echo:busy:")
+}
+
+// armTimeout starts measuring the timeout of the first command in flight, replacing
+// the previous measurement. armTimeout assumes a lock.
+func (p *RepRap) armTimeout() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.timerGeneration++
+	if p.err != nil || len(p.inFlight) == 0 {
+		return
+	}
+
+	e := p.inFlight[0].entry
+	f := e.cmd.future
+	if f == nil || f.isDone() {
+		return
+	}
+	timeout := p.timeout(e.cmd.command)
+	if timeout <= 0 {
+		return
+	}
+
+	generation := p.timerGeneration
+	p.timer = time.AfterFunc(timeout, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.timerGeneration == generation {
+			f.complete(ErrTimeout)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDefaultTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"M109 S210":      LongTimeout,
+		"m190 s60":       LongTimeout,
+		"G28":            LongTimeout,
+		"G29 P1":         LongTimeout,
+		"M105":           ShortTimeout,
+		"M114":           ShortTimeout,
+		"G1 X10":         NormalTimeout,
+		"M104 S200":      NormalTimeout,
+		`M98 P"unclosed`: NormalTimeout,
+	}
+	for command, want := range tests {
+		if got := DefaultTimeout(command); got != want {
+			t.Errorf("DefaultTimeout(%q): want %v, got %v", command, want, got)
+		}
+	}
+}
+
+func TestRepRap_SendContext(t *testing.T) {
+	f := &testFirmware{
+		respond: func(command string) []string { return []string{"echo:" + command} },
+		delay:   20 * time.Millisecond,
+	}
+	p := NewRepRap(f.open(), Options{})
+	defer p.Close()
+
+	r, err := p.SendContext(context.Background(), "M114")
+	if want := []string{"echo:M114", "ok"}; err != nil || !slices.Equal(r.Lines, want) {
+		t.Errorf("SendContext: want %q, got %q, %v", want, r.Lines, err)
+	}
+
+	// a command cancelled while waiting in the queue is not sent
+	first, _ := p.Request("G4 P10")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := p.SendContext(ctx, "M115"); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendContext of a cancelled command: want Canceled, got %v", err)
+	}
+	if _, err := first.Wait(context.Background()); err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+	if _, err := p.SendContext(context.Background(), "M400"); err != nil {
+		t.Errorf("SendContext: %v", err)
+	}
+	if want := []string{"M114", "G4 P10", "M400"}; !slices.Equal(f.received()[2:], want) {
+		t.Errorf("Unexpected commands: want %q, got %q", want, f.received()[2:])
+	}
+}
+
+func TestRepRap_SendContext_Timeout(t *testing.T) {
+	conn, _ := OpenFake(FakeConfig{Speed: 10})
+	p := NewRepRap(conn, Options{
+		Timeout: func(command string) time.Duration { return 300 * time.Millisecond },
+	})
+	defer p.Close()
+	ctx := context.Background()
+
+	// the firmware reports busy every 2 s of simulated time, i.e. every 200 ms
+	r, err := p.SendContext(ctx, "G4 S6")
+	if err != nil {
+		t.Fatalf("SendContext with busy reports: %v", err)
+	}
+	if want := []string{"ok"}; !slices.Equal(r.Lines, want) {
+		t.Errorf("Lines: want %q, got %q", want, r.Lines)
+	}
+
+	if _, err := p.SendContext(ctx, "M113 S0"); err != nil {
+		t.Fatalf("SendContext: %v", err)
+	}
+	if _, err := p.SendContext(ctx, "G4 S6"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("SendContext without busy reports: want ErrTimeout, got %v", err)
+	}
+
+	// the late "ok" still belongs to the command that timed out
+	r, err = p.SendContext(ctx, "M114")
+	if err != nil || len(r.Lines) != 2 || r.Lines[0][:2] != "X:" {
+		t.Errorf("SendContext after a timeout: %q, %v", r.Lines, err)
+	}
+}
@@ -0,0 +1,358 @@
+package tempchart
+
+import (
+	"fmt"
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"image/color"
+	"math"
+	"reprapctl/internal/pkg/printer"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ fyne.Widget = (*TempChart)(nil)
+
+// DefaultWindow is the time span shown by a new TempChart.
+const DefaultWindow = 5 * time.Minute
+
+// TempChart plots actual and target temperatures of printer heaters over a sliding time
+// window. Actual temperatures are drawn with solid lines, targets with faint lines of the
+// same color.
+type TempChart struct {
+	widget.BaseWidget
+
+	window   time.Duration
+	samples  []printer.TemperatureSample
+	heaters  []string
+	dataLock sync.RWMutex
+}
+
+// New creates an empty chart showing the last DefaultWindow.
+func New() *TempChart {
+	c := &TempChart{window: DefaultWindow}
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+// CreateRenderer is a private method to Fyne which links this widget to its renderer.
+func (c *TempChart) CreateRenderer() fyne.WidgetRenderer {
+	return newTempChartRenderer(c)
+}
+
+// Window returns the time span shown by the chart.
+func (c *TempChart) Window() time.Duration {
+	c.dataLock.RLock()
+	defer c.dataLock.RUnlock()
+	return c.window
+}
+
+// SetWindow sets the time span shown by the chart. Samples which are already out of the
+// window are dropped when the next sample is added.
+func (c *TempChart) SetWindow(window time.Duration) {
+	c.dataLock.Lock()
+	defer c.dataLock.Unlock()
+	c.window = window
+}
+
+// Add appends a sample to the chart and drops samples which fell out of the time window.
+// Samples must be added in chronological order.
+func (c *TempChart) Add(sample printer.TemperatureSample) {
+	func() {
+		c.dataLock.Lock()
+		defer c.dataLock.Unlock()
+
+		c.samples = append(c.samples, sample)
+		for _, h := range sample.Heaters {
+			if !slices.Contains(c.heaters, h.Name) {
+				c.heaters = append(c.heaters, h.Name)
+			}
+		}
+
+		// keep one sample before the window so that the lines reach the left edge
+		cutoff := sample.Time.Add(-c.window)
+		first := 0
+		for first+1 < len(c.samples) && !c.samples[first+1].Time.After(cutoff) {
+			first++
+		}
+		c.samples = slices.Delete(c.samples, 0, first)
+	}()
+
+	c.Refresh()
+}
+
+// Clear removes all samples from the chart.
+func (c *TempChart) Clear() {
+	func() {
+		c.dataLock.Lock()
+		defer c.dataLock.Unlock()
+		c.samples = nil
+		c.heaters = nil
+	}()
+
+	c.Refresh()
+}
+
+// HeaterColor returns the color used to plot a heater.
+func HeaterColor(name string) color.Color {
+	switch name {
+	case "T0":
+		return color.NRGBA{R: 0xe5, G: 0x39, B: 0x35, A: 0xff}
+	case "T1":
+		return color.NRGBA{R: 0xfb, G: 0x8c, B: 0x00, A: 0xff}
+	case "T2":
+		return color.NRGBA{R: 0x8e, G: 0x24, B: 0xaa, A: 0xff}
+	case "B":
+		return color.NRGBA{R: 0x1e, G: 0x88, B: 0xe5, A: 0xff}
+	case "C":
+		return color.NRGBA{R: 0x43, G: 0xa0, B: 0x47, A: 0xff}
+	default:
+		return color.NRGBA{R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff}
+	}
+}
+
+func targetColor(c color.Color) color.Color {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	n.A = 0x70
+	return n
+}
+
+var _ fyne.WidgetRenderer = (*tempChartRenderer)(nil)
+
+type tempChartRenderer struct {
+	chart  *TempChart
+	size   fyne.Size
+	border *canvas.Rectangle
+
+	lines      []*canvas.Line
+	texts      []*canvas.Text
+	nLines     int
+	nTexts     int
+	renderLock sync.Mutex
+	objects    atomic.Value
+}
+
+func newTempChartRenderer(chart *TempChart) *tempChartRenderer {
+	r := &tempChartRenderer{
+		chart: chart,
+		border: &canvas.Rectangle{
+			StrokeColor:  theme.InputBorderColor(),
+			StrokeWidth:  theme.InputBorderSize(),
+			CornerRadius: theme.InputRadiusSize(),
+		},
+	}
+	r.objects.Store([]fyne.CanvasObject{r.border})
+	return r
+}
+
+func (r *tempChartRenderer) Destroy() {
+}
+
+func (r *tempChartRenderer) Layout(size fyne.Size) {
+	func() {
+		r.renderLock.Lock()
+		defer r.renderLock.Unlock()
+		r.size = size
+	}()
+	r.Refresh()
+}
+
+func (r *tempChartRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(200, 120)
+}
+
+func (r *tempChartRenderer) Objects() []fyne.CanvasObject {
+	return r.objects.Load().([]fyne.CanvasObject)
+}
+
+func (r *tempChartRenderer) Refresh() {
+	r.renderLock.Lock()
+	defer r.renderLock.Unlock()
+	r.chart.dataLock.RLock()
+	defer r.chart.dataLock.RUnlock()
+
+	r.nLines, r.nTexts = 0, 0
+	r.border.Resize(r.size)
+
+	padding := theme.InnerPadding()
+	textSize := theme.CaptionTextSize()
+	labelSize := fyne.MeasureText("000°", textSize, fyne.TextStyle{})
+
+	plot := struct{ left, top, right, bottom float32 }{
+		left:   padding + labelSize.Width + padding/2,
+		top:    padding + labelSize.Height + padding/2,
+		right:  r.size.Width - padding,
+		bottom: r.size.Height - padding - labelSize.Height,
+	}
+
+	samples := r.chart.samples
+	window := r.chart.window
+	var now time.Time
+	if len(samples) > 0 {
+		now = samples[len(samples)-1].Time
+	}
+
+	maxTemp := 0.0
+	for _, s := range samples {
+		for _, h := range s.Heaters {
+			maxTemp = max(maxTemp, h.Actual, h.Target)
+		}
+	}
+	// round the scale up to a multiple of 50 °C with some headroom
+	scale := max(50, math.Ceil((maxTemp+10)/50)*50)
+
+	toX := func(t time.Time) float32 {
+		age := float32(now.Sub(t)) / float32(window)
+		return plot.right - age*(plot.right-plot.left)
+	}
+	toY := func(temperature float64) float32 {
+		return plot.bottom - float32(temperature/scale)*(plot.bottom-plot.top)
+	}
+
+	if plot.right > plot.left && plot.bottom > plot.top && window > 0 {
+		gridColor := theme.DisabledColor()
+		labelColor := theme.PlaceHolderColor()
+
+		step := scale / 5
+		for i := 0; i <= 5; i++ {
+			temperature := float64(i) * step
+			y := toY(temperature)
+			r.addLine(gridColor, 0.5, plot.left, y, plot.right, y)
+			label := r.addText(fmt.Sprintf("%.0f°", temperature), labelColor, textSize)
+			label.Alignment = fyne.TextAlignTrailing
+			label.Move(fyne.NewPos(padding, y-labelSize.Height/2))
+			label.Resize(fyne.NewSize(labelSize.Width, labelSize.Height))
+		}
+
+		for i := 0; i <= 4; i++ {
+			age := window * time.Duration(i) / 4
+			x := plot.right - float32(age)/float32(window)*(plot.right-plot.left)
+			r.addLine(gridColor, 0.5, x, plot.top, x, plot.bottom)
+			label := r.addText(formatAge(age), labelColor, textSize)
+			label.Alignment = fyne.TextAlignCenter
+			labelX := x - labelSize.Width
+			// keep the labels at the edges inside the plot
+			switch i {
+			case 0:
+				label.Alignment = fyne.TextAlignTrailing
+				labelX = x - labelSize.Width*2
+			case 4:
+				label.Alignment = fyne.TextAlignLeading
+				labelX = x
+			}
+			label.Move(fyne.NewPos(labelX, plot.bottom))
+			label.Resize(fyne.NewSize(labelSize.Width*2, labelSize.Height))
+		}
+
+		for _, name := range r.chart.heaters {
+			heaterColor := HeaterColor(name)
+			r.addSeries(samples, name, toX, toY, plot.left, targetColor(heaterColor), 1,
+				func(h printer.HeaterReading) float64 { return h.Target })
+			r.addSeries(samples, name, toX, toY, plot.left, heaterColor, 2,
+				func(h printer.HeaterReading) float64 { return h.Actual })
+		}
+	}
+
+	// legend with the latest readings
+	x := plot.left
+	if len(samples) > 0 {
+		for _, name := range r.chart.heaters {
+			h, ok := samples[len(samples)-1].Heater(name)
+			if !ok {
+				continue
+			}
+			text := r.addText(fmt.Sprintf("%s %.1f° / %.0f°", name, h.Actual, h.Target), HeaterColor(name), textSize)
+			text.Alignment = fyne.TextAlignLeading
+			size := text.MinSize()
+			text.Move(fyne.NewPos(x, padding/2))
+			text.Resize(size)
+			x += size.Width + padding*2
+		}
+	}
+
+	objects := make([]fyne.CanvasObject, 0, 1+r.nLines+r.nTexts)
+	objects = append(objects, r.border)
+	for _, l := range r.lines[:r.nLines] {
+		objects = append(objects, l)
+	}
+	for _, t := range r.texts[:r.nTexts] {
+		objects = append(objects, t)
+	}
+	r.objects.Store(objects)
+	for _, o := range objects {
+		o.Refresh()
+	}
+}
+
+// addSeries draws a line through the values of a heater, clipped at the left edge of the plot.
+func (r *tempChartRenderer) addSeries(
+	samples []printer.TemperatureSample,
+	name string,
+	toX func(time.Time) float32,
+	toY func(float64) float32,
+	left float32,
+	color color.Color,
+	width float32,
+	value func(printer.HeaterReading) float64,
+) {
+	var prev fyne.Position
+	havePrev := false
+	for _, s := range samples {
+		h, ok := s.Heater(name)
+		if !ok {
+			havePrev = false
+			continue
+		}
+		p := fyne.NewPos(toX(s.Time), toY(value(h)))
+		if havePrev && p.X > left {
+			if prev.X < left {
+				prev.Y += (p.Y - prev.Y) * (left - prev.X) / (p.X - prev.X)
+				prev.X = left
+			}
+			r.addLine(color, width, prev.X, prev.Y, p.X, p.Y)
+		}
+		prev, havePrev = p, true
+	}
+}
+
+func (r *tempChartRenderer) addLine(color color.Color, width, x1, y1, x2, y2 float32) {
+	if r.nLines == len(r.lines) {
+		r.lines = append(r.lines, canvas.NewLine(color))
+	}
+	l := r.lines[r.nLines]
+	r.nLines++
+	l.StrokeColor = color
+	l.StrokeWidth = width
+	l.Position1 = fyne.NewPos(x1, y1)
+	l.Position2 = fyne.NewPos(x2, y2)
+}
+
+func (r *tempChartRenderer) addText(s string, color color.Color, size float32) *canvas.Text {
+	if r.nTexts == len(r.texts) {
+		r.texts = append(r.texts, canvas.NewText("", color))
+	}
+	t := r.texts[r.nTexts]
+	r.nTexts++
+	t.Text = s
+	t.Color = color
+	t.TextSize = size
+	return t
+}
+
+// formatAge formats the age of a grid line as a label, e.g. "-30s", "-2m" or "-1:30".
+func formatAge(age time.Duration) string {
+	if age == 0 {
+		return "now"
+	}
+	if age%time.Minute == 0 {
+		return fmt.Sprintf("-%dm", age/time.Minute)
+	}
+	if age < time.Minute {
+		return fmt.Sprintf("-%ds", age/time.Second)
+	}
+	return fmt.Sprintf("-%d:%02d", age/time.Minute, age%time.Minute/time.Second)
+}
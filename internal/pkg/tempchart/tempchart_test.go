@@ -0,0 +1,81 @@
+package tempchart
+
+import (
+	"fyne.io/fyne/v2/test"
+	"reprapctl/internal/pkg/printer"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTempChart_Add(t *testing.T) {
+	app := test.NewApp()
+	defer app.Quit()
+
+	c := New()
+	c.SetWindow(time.Minute)
+	start := time.Now()
+	sample := func(seconds int, heaters ...string) printer.TemperatureSample {
+		s := printer.TemperatureSample{Time: start.Add(time.Duration(seconds) * time.Second)}
+		for _, h := range heaters {
+			s.Heaters = append(s.Heaters, printer.HeaterReading{Name: h, Actual: 25})
+		}
+		return s
+	}
+	times := func() []int {
+		var r []int
+		for _, s := range c.samples {
+			r = append(r, int(s.Time.Sub(start)/time.Second))
+		}
+		return r
+	}
+
+	tests := []struct {
+		add         printer.TemperatureSample
+		wantTimes   []int
+		wantHeaters []string
+	}{
+		{add: sample(0, "T0"), wantTimes: []int{0}, wantHeaters: []string{"T0"}},
+		{add: sample(30, "T0", "B"), wantTimes: []int{0, 30}, wantHeaters: []string{"T0", "B"}},
+		{add: sample(60, "T0"), wantTimes: []int{0, 30, 60}, wantHeaters: []string{"T0", "B"}},
+		// one sample before the window is kept, so that the lines reach the left edge
+		{add: sample(70, "B"), wantTimes: []int{0, 30, 60, 70}, wantHeaters: []string{"T0", "B"}},
+		{add: sample(95, "T0"), wantTimes: []int{30, 60, 70, 95}, wantHeaters: []string{"T0", "B"}},
+		{add: sample(200, "T1"), wantTimes: []int{95, 200}, wantHeaters: []string{"T0", "B", "T1"}},
+	}
+
+	for _, tt := range tests {
+		c.Add(tt.add)
+		if got := times(); !slices.Equal(got, tt.wantTimes) {
+			t.Errorf("Samples after adding %v: want %v, got %v", tt.add.Time.Sub(start), tt.wantTimes, got)
+		}
+		if !slices.Equal(c.heaters, tt.wantHeaters) {
+			t.Errorf("Heaters after adding %v: want %v, got %v", tt.add.Time.Sub(start), tt.wantHeaters, c.heaters)
+		}
+	}
+
+	c.Clear()
+	if len(c.samples) != 0 || len(c.heaters) != 0 {
+		t.Errorf("Clear left %v samples and heaters %v", len(c.samples), c.heaters)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{age: 0, want: "now"},
+		{age: 15 * time.Second, want: "-15s"},
+		{age: time.Minute, want: "-1m"},
+		{age: 5 * time.Minute, want: "-5m"},
+		{age: 90 * time.Second, want: "-1:30"},
+		{age: 2*time.Minute + 5*time.Second, want: "-2:05"},
+	}
+
+	for _, tt := range tests {
+		if got := formatAge(tt.age); got != tt.want {
+			t.Errorf("formatAge(%v): want %q, got %q", tt.age, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package tty
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PortInfo describes a serial device found by Discover.
+type PortInfo struct {
+	// Path is the path of the device node, e.g. "/dev/ttyUSB0".
+	Path string
+
+	// Driver is the name of the kernel driver, e.g. "ftdi_sio" or "cdc_acm".
+	Driver string
+
+	// VendorID and ProductID are hexadecimal USB identifiers, e.g. "2341" and "0042".
+	// They are empty for devices not connected over USB.
+	VendorID, ProductID string
+
+	// Manufacturer, Product and SerialNumber are USB descriptor strings.
+	// Any of them can be empty.
+	Manufacturer, Product, SerialNumber string
+}
+
+// String returns a human-readable description of the port.
+func (p PortInfo) String() string {
+	var details []string
+	if s := strings.TrimSpace(p.Manufacturer + " " + p.Product); s != "" {
+		details = append(details, s)
+	}
+	if p.VendorID != "" {
+		details = append(details, p.VendorID+":"+p.ProductID)
+	}
+	if len(details) == 0 {
+		return p.Path
+	}
+	return fmt.Sprintf("%s (%s)", p.Path, strings.Join(details, ", "))
+}
+
+// CommonBaudRates lists baud rates used by 3D printer firmware, most popular first.
+var CommonBaudRates = []int{115200, 250000, 57600, 230400, 500000, 1000000, 38400, 19200, 9600}
+
+// Discover finds serial devices that a printer can be connected to. Virtual consoles and
+// placeholder legacy serial ports are skipped. The ports are sorted by path.
+func Discover() ([]PortInfo, error) {
+	return discover("/sys/class/tty", "/dev")
+}
+
+func discover(sysClassTTY, devDir string) ([]PortInfo, error) {
+	entries, err := os.ReadDir(sysClassTTY)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, e := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(sysClassTTY, e.Name(), "device"))
+		if err != nil {
+			// virtual terminals and pseudo terminals have no device
+			continue
+		}
+		if filepath.Base(device) == "serial8250" {
+			// legacy ports which may not have any hardware behind them
+			continue
+		}
+
+		path := filepath.Join(devDir, e.Name())
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		info := PortInfo{Path: path}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(device, "driver")); err == nil {
+			info.Driver = filepath.Base(driver)
+		}
+		for dir := device; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if vendor := readAttr(dir, "idVendor"); vendor != "" {
+				info.VendorID = vendor
+				info.ProductID = readAttr(dir, "idProduct")
+				info.Manufacturer = readAttr(dir, "manufacturer")
+				info.Product = readAttr(dir, "product")
+				info.SerialNumber = readAttr(dir, "serial")
+				break
+			}
+		}
+		ports = append(ports, info)
+	}
+
+	slices.SortFunc(ports, func(a, b PortInfo) int { return strings.Compare(a.Path, b.Path) })
+	return ports, nil
+}
+
+// readAttr reads a sysfs attribute. It returns an empty string if the attribute does not exist.
+func readAttr(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// DetectBaudRate finds the baud rate of a printer by trying each of the rates in turn.
+// For each rate DetectBaudRate opens the port with config, sends M115 and waits up to
+// timeout for a sane reply: a line of printable text containing firmware information or
+// starting with "ok". If rates is nil, CommonBaudRates are tried.
+//
+// Opening a port often resets the printer's controller, so the timeout should allow for
+// the firmware to boot, usually 2 to 5 seconds.
+func DetectBaudRate(path string, config Config, rates []int, timeout time.Duration) (int, error) {
+	return detectBaudRate(func(rate int) (io.ReadWriteCloser, error) {
+		c := config
+		c.BaudRate = rate
+		return Open(path, c)
+	}, rates, timeout, path)
+}
+
+func detectBaudRate(
+	open func(rate int) (io.ReadWriteCloser, error),
+	rates []int,
+	timeout time.Duration,
+	name string,
+) (int, error) {
+	if rates == nil {
+		rates = CommonBaudRates
+	}
+	for _, rate := range rates {
+		conn, err := open(rate)
+		if err != nil {
+			return 0, err
+		}
+		ok := probe(conn, timeout)
+		_ = conn.Close()
+		if ok {
+			return rate, nil
+		}
+	}
+	return 0, fmt.Errorf("%v: no response at any of the baud rates %v", name, rates)
+}
+
+// probe sends M115 a few times within timeout and reports whether a sane reply arrives.
+func probe(conn io.ReadWriteCloser, timeout time.Duration) bool {
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			select {
+			case lines <- s.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	const attempts = 4
+	deadline := time.After(timeout)
+	resend := time.NewTicker(timeout / attempts)
+	defer resend.Stop()
+
+	// the leading EOL terminates any garbage the firmware might have received
+	if _, err := io.WriteString(conn, "\nM115\n"); err != nil {
+		return false
+	}
+	for {
+		select {
+		case l := <-lines:
+			if isSaneReply(l) {
+				return true
+			}
+		case <-resend.C:
+			if _, err := io.WriteString(conn, "M115\n"); err != nil {
+				return false
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func isSaneReply(line string) bool {
+	line = strings.TrimSpace(line)
+	for _, r := range line {
+		if r < ' ' && r != '\t' || r > '~' {
+			return false
+		}
+	}
+	return strings.Contains(line, "FIRMWARE_NAME") || line == "ok" || strings.HasPrefix(line, "ok ")
+}
@@ -0,0 +1,234 @@
+package tty
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSysfs builds a minimal copy of sysfs and /dev in a temporary directory.
+type fakeSysfs struct {
+	t    *testing.T
+	root string
+}
+
+func newFakeSysfs(t *testing.T) *fakeSysfs {
+	f := &fakeSysfs{t: t, root: t.TempDir()}
+	f.mkdir("sys/class/tty")
+	f.mkdir("sys/bus/usb-serial/drivers/ftdi_sio")
+	f.mkdir("sys/bus/usb/drivers/cdc_acm")
+	f.mkdir("sys/bus/platform/drivers/serial8250")
+	f.mkdir("dev")
+	return f
+}
+
+func (f *fakeSysfs) path(p string) string {
+	return filepath.Join(f.root, p)
+}
+
+func (f *fakeSysfs) mkdir(p string) {
+	if err := os.MkdirAll(f.path(p), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeSysfs) write(p, content string) {
+	f.mkdir(filepath.Dir(p))
+	if err := os.WriteFile(f.path(p), []byte(content+"\n"), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fakeSysfs) link(p, target string) {
+	f.mkdir(filepath.Dir(p))
+	if err := os.Symlink(f.path(target), f.path(p)); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// addTTY registers a tty with the given device directory and driver.
+// An empty device creates a virtual terminal.
+func (f *fakeSysfs) addTTY(name, device, driver string) {
+	class := "sys/devices/virtual/tty/" + name
+	if device != "" {
+		class = device + "/tty/" + name
+		f.link(class+"/device", device)
+		f.link(device+"/driver", driver)
+	}
+	f.mkdir(class)
+	f.link("sys/class/tty/"+name, class)
+	f.write("dev/"+name, "")
+}
+
+func (f *fakeSysfs) addUSBDevice(dir, vendor, product, manufacturer, productName, serial string) {
+	f.write(dir+"/idVendor", vendor)
+	f.write(dir+"/idProduct", product)
+	f.write(dir+"/manufacturer", manufacturer)
+	f.write(dir+"/product", productName)
+	f.write(dir+"/serial", serial)
+}
+
+func TestDiscover(t *testing.T) {
+	f := newFakeSysfs(t)
+
+	usb1 := "sys/devices/pci0000:00/0000:00:14.0/usb1/1-1"
+	f.addUSBDevice(usb1, "2341", "0042", "Arduino (www.arduino.cc)", "Mega 2560", "75833353035351B0E1A1")
+	f.addTTY("ttyACM0", usb1+"/1-1:1.0", "sys/bus/usb/drivers/cdc_acm")
+
+	usb2 := "sys/devices/pci0000:00/0000:00:14.0/usb1/1-2"
+	f.addUSBDevice(usb2, "0403", "6001", "FTDI", "FT232R USB UART", "A50285BI")
+	f.addTTY("ttyUSB0", usb2+"/1-2:1.0/ttyUSB0", "sys/bus/usb-serial/drivers/ftdi_sio")
+
+	f.addTTY("ttyS0", "sys/devices/pnp0/00:04", "sys/bus/platform/drivers/serial8250")
+	f.addTTY("ttyS1", "sys/devices/platform/serial8250", "sys/bus/platform/drivers/serial8250")
+	f.addTTY("tty1", "", "")
+
+	got, err := discover(f.path("sys/class/tty"), f.path("dev"))
+	if err != nil {
+		t.Fatalf("discover failed: %v", err)
+	}
+
+	want := []PortInfo{
+		{
+			Path:         f.path("dev/ttyACM0"),
+			Driver:       "cdc_acm",
+			VendorID:     "2341",
+			ProductID:    "0042",
+			Manufacturer: "Arduino (www.arduino.cc)",
+			Product:      "Mega 2560",
+			SerialNumber: "75833353035351B0E1A1",
+		},
+		{
+			Path:   f.path("dev/ttyS0"),
+			Driver: "serial8250",
+		},
+		{
+			Path:         f.path("dev/ttyUSB0"),
+			Driver:       "ftdi_sio",
+			VendorID:     "0403",
+			ProductID:    "6001",
+			Manufacturer: "FTDI",
+			Product:      "FT232R USB UART",
+			SerialNumber: "A50285BI",
+		},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestPortInfo_String(t *testing.T) {
+	tests := []struct {
+		info PortInfo
+		want string
+	}{
+		{
+			info: PortInfo{Path: "/dev/ttyS0"},
+			want: "/dev/ttyS0",
+		},
+		{
+			info: PortInfo{Path: "/dev/ttyACM0", VendorID: "2341", ProductID: "0042", Manufacturer: "Arduino", Product: "Mega 2560"},
+			want: "/dev/ttyACM0 (Arduino Mega 2560, 2341:0042)",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("want %q, got %q", tt.want, got)
+		}
+	}
+}
+
+// fakeFirmwareConn answers M115 properly only at the right baud rate, and with noise otherwise.
+type fakeFirmwareConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func openFakeFirmware(rate, wantRate int) (io.ReadWriteCloser, error) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	go func() {
+		defer outWriter.Close()
+		s := bufio.NewScanner(inReader)
+		for s.Scan() {
+			if !strings.Contains(s.Text(), "M115") {
+				continue
+			}
+			var err error
+			if rate == wantRate {
+				_, err = io.WriteString(outWriter, "FIRMWARE_NAME:Marlin 2.1.2 PROTOCOL_VERSION:1.0\nok\n")
+			} else {
+				_, err = outWriter.Write([]byte{0xfe, 0x13, 'x', 0x80, '\n'})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return &fakeFirmwareConn{Reader: outReader, WriteCloser: inWriter}, nil
+}
+
+func TestDetectBaudRate(t *testing.T) {
+	var tried []int
+	open := func(rate int) (io.ReadWriteCloser, error) {
+		tried = append(tried, rate)
+		return openFakeFirmware(rate, 57600)
+	}
+
+	rate, err := detectBaudRate(open, nil, 50*time.Millisecond, "fake")
+	if err != nil {
+		t.Fatalf("detectBaudRate failed: %v", err)
+	}
+	if rate != 57600 {
+		t.Errorf("want 57600, got %v", rate)
+	}
+	if want := []int{115200, 250000, 57600}; !slices.Equal(tried, want) {
+		t.Errorf("Tried rates: want %v, got %v", want, tried)
+	}
+}
+
+func TestDetectBaudRate_NoResponse(t *testing.T) {
+	open := func(rate int) (io.ReadWriteCloser, error) {
+		return openFakeFirmware(rate, 0)
+	}
+	if _, err := detectBaudRate(open, []int{9600, 19200}, 20*time.Millisecond, "fake"); err == nil {
+		t.Errorf("Want error, got nil")
+	}
+}
+
+func TestDetectBaudRate_OpenError(t *testing.T) {
+	openErr := errors.New("busy")
+	open := func(rate int) (io.ReadWriteCloser, error) {
+		return nil, openErr
+	}
+	if _, err := detectBaudRate(open, nil, time.Second, "fake"); !errors.Is(err, openErr) {
+		t.Errorf("want %v, got %v", openErr, err)
+	}
+}
+
+func TestDetectBaudRate_Pty(t *testing.T) {
+	master, path := openPty(t)
+	go func() {
+		s := bufio.NewScanner(master)
+		for s.Scan() {
+			if strings.Contains(s.Text(), "M115") {
+				io.WriteString(master, "FIRMWARE_NAME:Marlin\r\nok\r\n")
+			}
+		}
+	}()
+
+	rate, err := DetectBaudRate(path, Config{}, []int{250000}, time.Second)
+	if err != nil {
+		t.Fatalf("DetectBaudRate failed: %v", err)
+	}
+	if rate != 250000 {
+		t.Errorf("want 250000, got %v", rate)
+	}
+}
@@ -3,54 +3,200 @@ package tty
 import (
 	"fmt"
 	"golang.org/x/sys/unix"
+	"io"
+	"os"
 )
 
-// ConfigureTty sets up a TTY device for communication.
-func ConfigureTty(path string) error {
-	var err error
+var _ io.ReadWriteCloser = (*Port)(nil)
 
-	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK, 0)
+// Parity is the parity checking mode of a serial port.
+type Parity int
+
+const (
+	// ParityNone disables parity checking.
+	ParityNone = Parity(iota)
+
+	// ParityEven enables even parity.
+	ParityEven
+
+	// ParityOdd enables odd parity.
+	ParityOdd
+)
+
+// FlowControl is the flow control mode of a serial port.
+type FlowControl int
+
+const (
+	// FlowNone disables flow control.
+	FlowNone = FlowControl(iota)
+
+	// FlowHardware enables RTS/CTS flow control.
+	FlowHardware
+
+	// FlowSoftware enables XON/XOFF flow control.
+	FlowSoftware
+)
+
+// Config describes serial port settings. Zero values select defaults suitable for most
+// 3D printers: 115200 baud, 8 data bits, 1 stop bit, no parity, no flow control, raw mode.
+type Config struct {
+	// BaudRate is the speed of the port in bits per second. Non-standard rates such as
+	// 250000 are supported. The default is 115200.
+	BaudRate int
+
+	// DataBits is the number of data bits in a character, 5 to 8. The default is 8.
+	DataBits int
+
+	// TwoStopBits selects two stop bits instead of one.
+	TwoStopBits bool
+
+	// Parity is the parity checking mode. The default is ParityNone.
+	Parity Parity
+
+	// FlowControl is the flow control mode. The default is FlowNone.
+	FlowControl FlowControl
+
+	// Cooked enables the terminal line discipline: line editing, echo, signal characters
+	// and CR/NL translation. By default the port is in raw mode which passes all bytes
+	// through unchanged.
+	Cooked bool
+}
+
+// Port is an open serial port.
+type Port struct {
+	file *os.File
+}
+
+// Open opens a TTY device and configures it for communication.
+//
+// The port is opened for exclusive use: other processes cannot open it until it is closed.
+// Close interrupts any Read or Write in progress.
+func Open(path string, config Config) (*Port, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
 	if err != nil {
-		return fmt.Errorf("%v: %v", path, err)
+		return nil, fmt.Errorf("%v: %v", path, err)
 	}
 
-	defer func(fd int) {
+	if err := configure(fd, config); err != nil {
 		_ = unix.Close(fd)
-	}(fd)
-
-	termios := unix.Termios{
-		Iflag: unix.INPCK,
-		Oflag: 0,
-		Cflag: unix.PARENB | unix.CRTSCTS,
-		Lflag: 0,
-		Line:  0,
-		Cc: [19]uint8{
-			unix.VDISCARD,
-			0,
-			unix.VEOF,
-			unix.VEOL,
-			unix.VEOL2,
-			unix.VERASE,
-			unix.VINTR,
-			unix.VKILL,
-			unix.VLNEXT,
-			unix.VMIN,
-			unix.VQUIT,
-			unix.VREPRINT,
-			unix.VSTART,
-			0,
-			unix.VSTOP,
-			unix.VSUSP,
-			unix.VSWTC,
-			unix.VTIME,
-			unix.VWERASE,
-		},
-	}
-
-	err = unix.IoctlSetTermios(fd, unix.TCSETS, &termios)
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	// a non-blocking descriptor makes the file pollable, so that Close can interrupt Read
+	return &Port{file: os.NewFile(uintptr(fd), path)}, nil
+}
+
+// Name returns the path of the TTY device.
+func (p *Port) Name() string {
+	return p.file.Name()
+}
+
+func (p *Port) Read(b []byte) (int, error) {
+	return p.file.Read(b)
+}
+
+func (p *Port) Write(b []byte) (int, error) {
+	return p.file.Write(b)
+}
+
+// Close closes the port.
+func (p *Port) Close() error {
+	return p.file.Close()
+}
+
+func configure(fd int, config Config) error {
+	if err := unix.IoctlSetInt(fd, unix.TIOCEXCL, 0); err != nil {
+		return err
+	}
+
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
 	if err != nil {
-		return fmt.Errorf("%v: %v", path, err)
+		return err
+	}
+	if err := updateTermios(termios, config); err != nil {
+		return err
+	}
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS2, termios); err != nil {
+		return err
+	}
+
+	// discard anything received before the port was configured
+	return unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIOFLUSH)
+}
+
+// updateTermios applies config to termios2 settings.
+func updateTermios(termios *unix.Termios, config Config) error {
+	if !config.Cooked {
+		// same as cfmakeraw(3)
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+			unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+	}
+
+	termios.Cflag |= unix.CLOCAL | unix.CREAD
+
+	termios.Cflag &^= unix.CSIZE
+	switch config.DataBits {
+	case 0, 8:
+		termios.Cflag |= unix.CS8
+	case 7:
+		termios.Cflag |= unix.CS7
+	case 6:
+		termios.Cflag |= unix.CS6
+	case 5:
+		termios.Cflag |= unix.CS5
+	default:
+		return fmt.Errorf("unsupported number of data bits %v", config.DataBits)
+	}
+
+	if config.TwoStopBits {
+		termios.Cflag |= unix.CSTOPB
+	} else {
+		termios.Cflag &^= unix.CSTOPB
+	}
+
+	termios.Cflag &^= unix.PARENB | unix.PARODD | unix.CMSPAR
+	termios.Iflag &^= unix.INPCK
+	switch config.Parity {
+	case ParityNone:
+	case ParityEven:
+		termios.Cflag |= unix.PARENB
+		termios.Iflag |= unix.INPCK
+	case ParityOdd:
+		termios.Cflag |= unix.PARENB | unix.PARODD
+		termios.Iflag |= unix.INPCK
+	default:
+		return fmt.Errorf("unsupported parity %v", config.Parity)
+	}
+
+	termios.Cflag &^= unix.CRTSCTS
+	termios.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	switch config.FlowControl {
+	case FlowNone:
+	case FlowHardware:
+		termios.Cflag |= unix.CRTSCTS
+	case FlowSoftware:
+		termios.Iflag |= unix.IXON | unix.IXOFF
+	default:
+		return fmt.Errorf("unsupported flow control %v", config.FlowControl)
+	}
+
+	// BOTHER allows arbitrary baud rates in Ispeed and Ospeed.
+	// Zero input baud bits make the input speed the same as the output speed.
+	baudRate := config.BaudRate
+	if baudRate == 0 {
+		baudRate = 115200
+	}
+	if baudRate < 0 {
+		return fmt.Errorf("invalid baud rate %v", baudRate)
 	}
+	termios.Cflag &^= unix.CBAUD | unix.CIBAUD
+	termios.Cflag |= unix.BOTHER
+	termios.Ispeed = uint32(baudRate)
+	termios.Ospeed = uint32(baudRate)
 
 	return nil
 }
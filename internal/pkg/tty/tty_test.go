@@ -0,0 +1,218 @@
+package tty
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"testing"
+)
+
+// openPty opens a pseudo terminal and returns its master side and the path of its slave side.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		t.Skipf("Pseudo terminals not available: %v", err)
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	t.Cleanup(func() { master.Close() })
+
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("Failed to unlock pty: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("Failed to get pty number: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestUpdateTermios(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		wantSpeed uint32
+		wantCflag uint32
+		wantIflag uint32
+	}{
+		{
+			name:      "Default",
+			wantSpeed: 115200,
+			wantCflag: unix.CS8,
+		},
+		{
+			name:      "NonStandardBaudRate",
+			config:    Config{BaudRate: 250000},
+			wantSpeed: 250000,
+			wantCflag: unix.CS8,
+		},
+		{
+			name:      "SevenEvenTwo",
+			config:    Config{BaudRate: 9600, DataBits: 7, Parity: ParityEven, TwoStopBits: true},
+			wantSpeed: 9600,
+			wantCflag: unix.CS7 | unix.PARENB | unix.CSTOPB,
+			wantIflag: unix.INPCK,
+		},
+		{
+			name:      "OddParity",
+			config:    Config{Parity: ParityOdd},
+			wantSpeed: 115200,
+			wantCflag: unix.CS8 | unix.PARENB | unix.PARODD,
+			wantIflag: unix.INPCK,
+		},
+		{
+			name:      "HardwareFlowControl",
+			config:    Config{FlowControl: FlowHardware},
+			wantSpeed: 115200,
+			wantCflag: unix.CS8 | unix.CRTSCTS,
+		},
+		{
+			name:      "SoftwareFlowControl",
+			config:    Config{FlowControl: FlowSoftware},
+			wantSpeed: 115200,
+			wantCflag: unix.CS8,
+			wantIflag: unix.IXON | unix.IXOFF,
+		},
+		{
+			name:      "Cooked",
+			config:    Config{Cooked: true},
+			wantSpeed: 115200,
+			wantCflag: unix.CS8,
+			wantIflag: unix.ICRNL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			termios := unix.Termios{
+				Iflag: unix.ICRNL | unix.IXON,
+				Oflag: unix.OPOST,
+				Cflag: unix.CS8 | unix.B9600,
+				Lflag: unix.ICANON | unix.ECHO,
+			}
+
+			if err := updateTermios(&termios, tt.config); err != nil {
+				t.Fatalf("updateTermios failed: %v", err)
+			}
+
+			if termios.Ospeed != tt.wantSpeed || termios.Ispeed != tt.wantSpeed {
+				t.Errorf("Speed: want %v, got %v/%v", tt.wantSpeed, termios.Ispeed, termios.Ospeed)
+			}
+			if got := termios.Cflag & unix.CBAUD; got != unix.BOTHER {
+				t.Errorf("Baud bits: want BOTHER, got %#x", got)
+			}
+			cflagMask := uint32(unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS)
+			if got := termios.Cflag & cflagMask; got != tt.wantCflag {
+				t.Errorf("Cflag: want %#x, got %#x", tt.wantCflag, got)
+			}
+			iflagMask := uint32(unix.INPCK | unix.IXON | unix.IXOFF | unix.ICRNL)
+			if got := termios.Iflag & iflagMask; got != tt.wantIflag {
+				t.Errorf("Iflag: want %#x, got %#x", tt.wantIflag, got)
+			}
+			if raw := termios.Lflag&(unix.ICANON|unix.ECHO) == 0; raw == tt.config.Cooked {
+				t.Errorf("Lflag: want cooked %v, got %#x", tt.config.Cooked, termios.Lflag)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	master, path := openPty(t)
+
+	p, err := Open(path, Config{BaudRate: 250000})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+
+	termios, err := unix.IoctlGetTermios(int(p.file.Fd()), unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2 failed: %v", err)
+	}
+	if termios.Ospeed != 250000 {
+		t.Errorf("Speed: want 250000, got %v", termios.Ospeed)
+	}
+
+	// raw mode passes CR and NL unchanged in both directions
+	if _, err := master.Write([]byte("ok\r\n")); err != nil {
+		t.Fatalf("Master write failed: %v", err)
+	}
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	if err != nil || string(buf[:n]) != "ok\r\n" {
+		t.Errorf("Read: want %q, got %q, %v", "ok\r\n", buf[:n], err)
+	}
+
+	if _, err := p.Write([]byte("G28\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	n, err = master.Read(buf)
+	if err != nil || string(buf[:n]) != "G28\n" {
+		t.Errorf("Master read: want %q, got %q, %v", "G28\n", buf[:n], err)
+	}
+}
+
+func TestOpen_Exclusive(t *testing.T) {
+	_, path := openPty(t)
+
+	p, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer p.Close()
+
+	if os.Geteuid() == 0 {
+		t.Skip("Exclusive mode does not apply to root")
+	}
+	if p2, err := Open(path, Config{}); err == nil {
+		p2.Close()
+		t.Errorf("Second Open: want error, got nil")
+	}
+}
+
+func TestOpen_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "DataBits", config: Config{DataBits: 9}},
+		{name: "Parity", config: Config{Parity: Parity(10)}},
+		{name: "FlowControl", config: Config{FlowControl: FlowControl(10)}},
+		{name: "BaudRate", config: Config{BaudRate: -1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, path := openPty(t)
+			if p, err := Open(path, tt.config); err == nil {
+				p.Close()
+				t.Errorf("Want error, got nil")
+			}
+		})
+	}
+}
+
+func TestOpen_NoDevice(t *testing.T) {
+	if _, err := Open("/dev/no-such-tty", Config{}); err == nil {
+		t.Errorf("Want error, got nil")
+	}
+}
+
+func TestPort_CloseInterruptsRead(t *testing.T) {
+	_, path := openPty(t)
+	p, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	p.Close()
+	if err := <-done; err == nil {
+		t.Errorf("Read after Close: want error, got nil")
+	}
+}
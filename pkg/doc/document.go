@@ -1,6 +1,7 @@
 package doc
 
 import (
+	"slices"
 	"strings"
 	"sync"
 )
@@ -15,9 +16,14 @@ const (
 
 // Document is a thread safe, ordered collection of lines.
 //
+// Every line has attributes: metadata such as the time and the source of the line, and
+// styled spans, see Attrs. Lines added with Add have zero attributes.
+//
 // Document keeps track of an arbitrary number of bookmarks and updates them when lines are added
 // or removed.
 //
+// Subscribers are notified of every change of the document, see Change.
+//
 // A Document can have Capacity expressed as the total number of lines that the document can hold.
 // If any operation that adds lines exceeds capacity, lines at the top are removed accordingly.
 // Setting capacity to zero or negative disables the capacity checks.
@@ -39,11 +45,19 @@ type Document interface {
 	// reused.
 	Read(action func(lines []string))
 
+	// ReadAttrs is like Read, but also passes the attributes of the lines. The attrs slice
+	// has the same length as lines, and the same restrictions apply to it.
+	ReadAttrs(action func(lines []string, attrs []Attrs))
+
 	// Add adds lines at the bottom of the document.
 	// If number of lines in the document exceeds Capacity, lines at the top are removed
 	// and bookmarks are adjusted accordingly.
 	Add(lines ...string)
 
+	// AddLines is like Add, but adds lines along with their attributes. Spans are clamped to
+	// the text of their line, see Attrs.Spans.
+	AddLines(lines ...Line)
+
 	// Version is an opaque value that changes every time the Document is mutated.
 	Version() uint64
 
@@ -78,6 +92,12 @@ type Document interface {
 	// Removing a bookmark that is not in the document is a no-op.
 	RemoveBookmark(key any)
 
+	// Subscribe adds a handler which is notified of every change of the document.
+	// Handlers are called synchronously, in the order of the changes, while the document is
+	// locked for writing. They must not block and must not call the document's methods.
+	// Call unsubscribe to remove the handler.
+	Subscribe(handler func(Change)) (unsubscribe func())
+
 	// String returns contents of the document between two bookmarks.
 	// Individual lines are joined with the separator.
 	//
@@ -92,6 +112,28 @@ type Document interface {
 	String(bookmark1, bookmark2 any, separator string) (string, bool)
 }
 
+// Change describes a change of a document: first Removed lines were removed from the top,
+// then Lines were appended at the bottom. Lines that were appended and immediately removed
+// because of capacity are not reported.
+type Change struct {
+	// Removed is the number of lines removed from the top of the document.
+	Removed int
+
+	// Start is the line index of the first appended line after the change.
+	Start int
+
+	// Lines are the appended lines. The slice must be treated as read-only.
+	Lines []string
+
+	// Attrs are the attributes of the appended lines, in the same order as Lines.
+	Attrs []Attrs
+}
+
+// End returns the line index past the last appended line after the change.
+func (c Change) End() int {
+	return c.Start + len(c.Lines)
+}
+
 // New creates a new instance of Document.
 func New() Document {
 	d := document{}
@@ -101,16 +143,22 @@ func New() Document {
 
 type document struct {
 	lines          []string
+	attrs          []Attrs
 	capacity       int
 	version        uint64
 	selectionStart Anchor
 	selectionEnd   Anchor
 	bookmarks      map[any]Anchor
+	subscribers    []*subscriber
 	lock           sync.RWMutex
 }
 
 type bookmark int
 
+type subscriber struct {
+	handler func(Change)
+}
+
 func (d *document) Capacity() int {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
@@ -121,7 +169,9 @@ func (d *document) SetCapacity(c int) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 	d.capacity = c
-	d.enforceCapacity()
+	if removed := d.enforceCapacity(); removed > 0 {
+		d.notify(Change{Removed: removed, Start: len(d.lines)})
+	}
 }
 
 func (d *document) Read(action func(lines []string)) {
@@ -130,12 +180,45 @@ func (d *document) Read(action func(lines []string)) {
 	action(d.lines)
 }
 
+func (d *document) ReadAttrs(action func(lines []string, attrs []Attrs)) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	action(d.lines, d.attrs)
+}
+
 func (d *document) Add(lines ...string) {
+	d.add(lines, make([]Attrs, len(lines)))
+}
+
+func (d *document) AddLines(lines ...Line) {
+	text := make([]string, len(lines))
+	attrs := make([]Attrs, len(lines))
+	for i, l := range lines {
+		text[i], attrs[i] = l.Text, l.Attrs
+		attrs[i].Spans = normalizeSpans(l.Attrs.Spans, l.Text)
+	}
+	d.add(text, attrs)
+}
+
+func (d *document) add(lines []string, attrs []Attrs) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
+	previous := len(d.lines)
 	d.lines = append(d.lines, lines...)
-	d.enforceCapacity()
+	d.attrs = append(d.attrs, attrs...)
+	removed := d.enforceCapacity()
 	d.version++
+
+	// the lines removed from the top may include some of the added lines
+	if removed > previous {
+		lines, attrs = lines[removed-previous:], attrs[removed-previous:]
+		removed = previous
+	}
+	if removed > 0 || len(lines) > 0 {
+		// the caller may reuse the slice it passed to Add
+		lines = slices.Clone(lines)
+		d.notify(Change{Removed: removed, Start: len(d.lines) - len(lines), Lines: lines, Attrs: attrs})
+	}
 }
 
 func (d *document) Version() uint64 {
@@ -165,6 +248,19 @@ func (d *document) RemoveBookmark(key any) {
 	delete(d.bookmarks, key)
 }
 
+func (d *document) Subscribe(handler func(Change)) (unsubscribe func()) {
+	s := &subscriber{handler: handler}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.subscribers = append(d.subscribers, s)
+
+	return func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.subscribers = slices.DeleteFunc(d.subscribers, func(x *subscriber) bool { return x == s })
+	}
+}
+
 func (d *document) String(bookmark1, bookmark2 any, separator string) (string, bool) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
@@ -202,21 +298,32 @@ func (d *document) String(bookmark1, bookmark2 any, separator string) (string, b
 	return builder.String(), true
 }
 
+// enforceCapacity returns the number of lines removed from the top.
 // enforceCapacity assumes a write lock.
-func (d *document) enforceCapacity() {
+func (d *document) enforceCapacity() int {
 	if d.capacity > 0 && len(d.lines) > d.capacity {
-		d.removeLines(0, len(d.lines)-d.capacity)
+		n := len(d.lines) - d.capacity
+		d.removeLines(0, n)
+		return n
+	}
+	return 0
+}
+
+// notify assumes a write lock.
+func (d *document) notify(c Change) {
+	for _, s := range d.subscribers {
+		s.handler(c)
 	}
 }
 
 // removeLines assumes a write lock.
 func (d *document) removeLines(start, end int) {
 	n := copy(d.lines[start:], d.lines[end:])
+	copy(d.attrs[start:], d.attrs[end:])
 	// allow GC to collect the removed lines
-	for i := start + n; i < len(d.lines); i++ {
-		d.lines[i] = ""
-	}
-	d.lines = d.lines[:start+n]
+	clear(d.lines[start+n:])
+	clear(d.attrs[start+n:])
+	d.lines, d.attrs = d.lines[:start+n], d.attrs[:start+n]
 
 	// update selection
 	d.selectionStart = removeLinesFromAnchor(d.selectionStart, start, end)
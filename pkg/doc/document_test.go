@@ -1,9 +1,12 @@
 package doc_test
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
 	"reprapctl/pkg/doc"
 	"testing"
+	"time"
 )
 
 func TestDocument_Empty(t *testing.T) {
@@ -116,6 +119,171 @@ func TestDocument_Version(t *testing.T) {
 	checkVersion()
 }
 
+func TestDocument_Subscribe(t *testing.T) {
+	d := doc.New()
+	d.SetCapacity(4)
+
+	var changes []doc.Change
+	unsubscribe := d.Subscribe(func(c doc.Change) {
+		// lines added without attributes have zero attributes
+		assert.Len(t, c.Attrs, len(c.Lines))
+		for _, a := range c.Attrs {
+			assert.Zero(t, a)
+		}
+		c.Attrs = nil
+		changes = append(changes, c)
+	})
+
+	d.Add("line1", "line2")
+	d.Add()
+	d.Add("line3", "line4", "line5")
+	d.Add("line6", "line7", "line8", "line9", "line10")
+	d.SetCapacity(2)
+	d.SetCapacity(10)
+	unsubscribe()
+	d.Add("line11")
+
+	assert.Equal(t, []doc.Change{
+		{Start: 0, Lines: []string{"line1", "line2"}},
+		{Removed: 1, Start: 1, Lines: []string{"line3", "line4", "line5"}},
+		{Removed: 4, Start: 0, Lines: []string{"line7", "line8", "line9", "line10"}},
+		{Removed: 2, Start: 2},
+	}, changes)
+	assert.Equal(t, 4, changes[2].End())
+}
+
+func TestDocument_Subscribe_CallerSlice(t *testing.T) {
+	d := doc.New()
+	var changes []doc.Change
+	d.Subscribe(func(c doc.Change) {
+		changes = append(changes, c)
+	})
+
+	lines := []string{"line1", "line2"}
+	d.Add(lines...)
+	lines[0] = "changed"
+
+	assert.Equal(t, []string{"line1", "line2"}, changes[0].Lines)
+}
+
+func TestDocument_Subscribe_Replay(t *testing.T) {
+	d := doc.New()
+	d.SetCapacity(5)
+
+	// a copy of the document maintained from the changes
+	var replica []string
+	d.Subscribe(func(c doc.Change) {
+		replica = append(replica[c.Removed:], c.Lines...)
+		assert.Equal(t, c.End(), len(replica))
+	})
+
+	for i := 0; i < 20; i++ {
+		lines := make([]string, i%7)
+		for j := range lines {
+			lines[j] = fmt.Sprintf("line%d.%d", i, j)
+		}
+		d.Add(lines...)
+		d.Read(func(lines []string) {
+			assert.Equal(t, lines, replica)
+		})
+	}
+}
+
+func TestDocument_AddLines(t *testing.T) {
+	d := doc.New()
+	d.SetCapacity(3)
+	now := time.Now()
+	sent := doc.Attrs{Time: now, Source: doc.SourceSent}
+	errorSpan := doc.Span{Start: 0, End: 6, Color: doc.ColorError, Bold: true}
+
+	var changes []doc.Change
+	d.Subscribe(func(c doc.Change) {
+		changes = append(changes, c)
+	})
+
+	d.Add("line1")
+	d.AddLines(
+		doc.Line{Text: "G28", Attrs: sent},
+		doc.Line{Text: "Error:boom", Attrs: doc.Attrs{
+			Time:   now,
+			Source: doc.SourceReceived,
+			Spans:  []doc.Span{errorSpan},
+		}},
+		doc.Line{Text: "ok", Attrs: doc.Attrs{Level: slog.LevelWarn, Source: doc.SourceAppLog}},
+	)
+
+	wantAttrs := []doc.Attrs{
+		sent,
+		{Time: now, Source: doc.SourceReceived, Spans: []doc.Span{errorSpan}},
+		{Level: slog.LevelWarn, Source: doc.SourceAppLog},
+	}
+	d.ReadAttrs(func(lines []string, attrs []doc.Attrs) {
+		assert.Equal(t, []string{"G28", "Error:boom", "ok"}, lines)
+		assert.Equal(t, wantAttrs, attrs)
+	})
+	d.Read(func(lines []string) {
+		assert.Equal(t, []string{"G28", "Error:boom", "ok"}, lines)
+	})
+	s, ok := d.String(doc.BookmarkStart, doc.BookmarkEnd, "\n")
+	assert.True(t, ok)
+	assert.Equal(t, "G28\nError:boom\nok", s)
+	assert.Equal(t, wantAttrs, changes[1].Attrs)
+
+	d.SetCapacity(1)
+	d.ReadAttrs(func(lines []string, attrs []doc.Attrs) {
+		assert.Equal(t, []string{"ok"}, lines)
+		assert.Equal(t, wantAttrs[2:], attrs)
+	})
+}
+
+func TestDocument_AddLines_Spans(t *testing.T) {
+	red, blue := doc.ColorError, doc.ColorPrimary
+	tests := []struct {
+		name  string
+		spans []doc.Span
+		want  []doc.Span
+	}{
+		{
+			name: "None",
+		},
+		{
+			name:  "Sorted",
+			spans: []doc.Span{{Start: 4, End: 6, Color: blue}, {Start: 0, End: 2, Color: red}},
+			want:  []doc.Span{{Start: 0, End: 2, Color: red}, {Start: 4, End: 6, Color: blue}},
+		},
+		{
+			name:  "Clamped",
+			spans: []doc.Span{{Start: -3, End: 2, Color: red}, {Start: 8, End: 20, Bold: true}},
+			want:  []doc.Span{{Start: 0, End: 2, Color: red}, {Start: 8, End: 10, Bold: true}},
+		},
+		{
+			name:  "Empty",
+			spans: []doc.Span{{Start: 2, End: 2, Color: red}, {Start: 12, End: 14, Color: blue}},
+		},
+		{
+			name:  "Overlapping",
+			spans: []doc.Span{{Start: 0, End: 6, Color: red}, {Start: 4, End: 8, Color: blue}, {Start: 2, End: 5}},
+			want:  []doc.Span{{Start: 0, End: 6, Color: red}, {Start: 6, End: 8, Color: blue}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := doc.New()
+			d.AddLines(doc.Line{Text: "0123456789", Attrs: doc.Attrs{Spans: tt.spans}})
+			d.ReadAttrs(func(_ []string, attrs []doc.Attrs) {
+				assert.Equal(t, tt.want, attrs[0].Spans)
+			})
+		})
+	}
+}
+
+func TestSource_String(t *testing.T) {
+	assert.Equal(t, "sent", doc.SourceSent.String())
+	assert.Equal(t, "app log", doc.SourceAppLog.String())
+	assert.Equal(t, "Source(42)", doc.Source(42).String())
+}
+
 func TestDocument_Bookmarks_GetSet(t *testing.T) {
 	tests := []struct {
 		name string
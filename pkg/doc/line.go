@@ -0,0 +1,131 @@
+package doc
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Source tells where a line of a document comes from.
+type Source int
+
+const (
+	// SourceUnknown is the source of lines added without attributes.
+	SourceUnknown = Source(iota)
+
+	// SourceSent is the source of lines sent to a device.
+	SourceSent
+
+	// SourceReceived is the source of lines received from a device.
+	SourceReceived
+
+	// SourceAppLog is the source of log records of the application.
+	SourceAppLog
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceUnknown:
+		return "unknown"
+	case SourceSent:
+		return "sent"
+	case SourceReceived:
+		return "received"
+	case SourceAppLog:
+		return "app log"
+	default:
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+}
+
+// Color is the color of a span. It names a role rather than a value, so that views can
+// map it to the colors of their theme.
+type Color string
+
+const (
+	// ColorDefault is the color of text without a span.
+	ColorDefault = Color("")
+
+	// ColorPrimary makes text stand out.
+	ColorPrimary = Color("primary")
+
+	// ColorError is the color of errors.
+	ColorError = Color("error")
+
+	// ColorWarning is the color of warnings.
+	ColorWarning = Color("warning")
+
+	// ColorSuccess is the color of success messages.
+	ColorSuccess = Color("success")
+
+	// ColorDimmed is the color of less important text.
+	ColorDimmed = Color("dimmed")
+)
+
+// Span styles the bytes of a line from Start to End.
+type Span struct {
+	Start int
+	End   int
+
+	// Color is the color of the text. ColorDefault is the default foreground color.
+	Color Color
+
+	// Bold makes the text bold.
+	Bold bool
+}
+
+// Attrs are the metadata and the styled spans of a line.
+type Attrs struct {
+	// Time is the time when the line was produced. It can be zero.
+	Time time.Time
+
+	// Level is the level of a log record, see SourceAppLog.
+	Level slog.Level
+
+	// Source tells where the line comes from.
+	Source Source
+
+	// Spans are the styled parts of the line, sorted by Start and not overlapping. Text
+	// outside of spans has the default style.
+	Spans []Span
+}
+
+// Line is a line of text along with its attributes, see Document.AddLines.
+type Line struct {
+	Text  string
+	Attrs Attrs
+}
+
+// normalizeSpans clamps spans to the text, drops the empty ones and sorts the rest by
+// Start. Where spans overlap, the span that starts first wins. normalizeSpans returns nil
+// if there are no spans left.
+func normalizeSpans(spans []Span, text string) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	r := make([]Span, 0, len(spans))
+	for _, s := range spans {
+		s.Start, s.End = max(s.Start, 0), min(s.End, len(text))
+		if s.Start < s.End {
+			r = append(r, s)
+		}
+	}
+	slices.SortStableFunc(r, func(a, b Span) int { return cmp.Compare(a.Start, b.Start) })
+
+	n := 0
+	for _, s := range r {
+		if n > 0 {
+			s.Start = max(s.Start, r[n-1].End)
+		}
+		if s.Start < s.End {
+			r[n] = s
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return r[:n]
+}
@@ -0,0 +1,497 @@
+package gcode
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Defaults of AnalyzerOptions.
+const (
+	DefaultFilamentDiameter = 1.75   // mm
+	DefaultFilamentDensity  = 1.24   // g/cm³, PLA
+	DefaultAcceleration     = 1000.0 // mm/s²
+	DefaultFeedrate         = 1500.0 // mm/min
+)
+
+// AnalyzerOptions describe the printer and the filament. Zero values select the defaults.
+type AnalyzerOptions struct {
+	// FilamentDiameter is the diameter of the filament in mm.
+	FilamentDiameter float64
+
+	// FilamentDensity is the density of the filament in g/cm³.
+	FilamentDensity float64
+
+	// Acceleration is the acceleration of moves in mm/s² until it is set by M204.
+	Acceleration float64
+
+	// Feedrate is the feedrate of moves in mm/min until it is set by an F parameter.
+	Feedrate float64
+}
+
+// Point is a position in mm.
+type Point struct {
+	X, Y, Z float64
+}
+
+// Bounds is a box in which all extruding moves take place.
+type Bounds struct {
+	Min, Max Point
+}
+
+// Size returns the dimensions of the box.
+func (b Bounds) Size() Point {
+	return Point{X: b.Max.X - b.Min.X, Y: b.Max.Y - b.Min.Y, Z: b.Max.Z - b.Min.Z}
+}
+
+// Extrusion is the amount of filament used by an extruder.
+type Extrusion struct {
+	// Length is the length of the filament in mm.
+	Length float64
+
+	// Volume is the volume of the filament in mm³.
+	Volume float64
+
+	// Weight is the weight of the filament in g.
+	Weight float64
+}
+
+// Layer is a layer of the print. A layer starts with the first extruding move at a new
+// height.
+type Layer struct {
+	// Z is the height of the layer, Height the difference to the previous layer.
+	Z, Height float64
+
+	// Line is the 1-based number of the line with the first extruding move.
+	Line int
+
+	// Start is the estimated print time before the layer.
+	Start time.Duration
+}
+
+// Analysis is the result of analyzing G-code.
+type Analysis struct {
+	// Lines is the number of lines analyzed.
+	Lines int
+
+	// Time is the estimated print time.
+	Time time.Duration
+
+	// Extruders is the filament used by each extruder, indexed by the tool number.
+	Extruders []Extrusion
+
+	// Bounds contains all extruding moves. It is valid only if HasBounds is set.
+	Bounds    Bounds
+	HasBounds bool
+
+	// Layers are the layers in the order they are printed.
+	Layers []Layer
+
+	// Errors are the errors of the lines which could not be parsed. Such lines are
+	// skipped, but counted in Lines.
+	Errors []*SyntaxError
+}
+
+// Filament returns the filament used by all extruders.
+func (a Analysis) Filament() Extrusion {
+	var total Extrusion
+	for _, e := range a.Extruders {
+		total.Length += e.Length
+		total.Volume += e.Volume
+		total.Weight += e.Weight
+	}
+	return total
+}
+
+// Axes of the position.
+const (
+	axisX = iota
+	axisY
+	axisZ
+	axisE
+	axisCount
+)
+
+var axisLetters = [axisCount]byte{'X', 'Y', 'Z', 'E'}
+
+// Analyzer simulates the motion of a printer to estimate the print time and the filament
+// used by G-code.
+//
+// The print time is estimated for each move separately, accelerating from and
+// decelerating to a stop, using the acceleration set by M204 and limited per axis by M201.
+// The estimate is an upper bound, because the firmware's planner keeps the speed between
+// moves going in a similar direction.
+//
+// Use NewAnalyzer to create instances of Analyzer.
+type Analyzer struct {
+	options AnalyzerOptions
+
+	position  [axisCount]float64
+	relative  bool
+	relativeE bool
+	inches    bool
+	feedrate  float64 // mm/s
+	tool      int
+
+	maxAcceleration     [axisCount]float64
+	printAcceleration   float64
+	travelAcceleration  float64
+	retractAcceleration float64
+
+	time      float64 // s
+	extruded  []float64
+	lines     int
+	bounds    Bounds
+	hasBounds bool
+	layers    []Layer
+	errors    []*SyntaxError
+}
+
+// NewAnalyzer creates an analyzer of a printer in its initial state: absolute positioning
+// at the origin, using the first extruder.
+func NewAnalyzer(options AnalyzerOptions) *Analyzer {
+	if options.FilamentDiameter <= 0 {
+		options.FilamentDiameter = DefaultFilamentDiameter
+	}
+	if options.FilamentDensity <= 0 {
+		options.FilamentDensity = DefaultFilamentDensity
+	}
+	if options.Acceleration <= 0 {
+		options.Acceleration = DefaultAcceleration
+	}
+	if options.Feedrate <= 0 {
+		options.Feedrate = DefaultFeedrate
+	}
+	return &Analyzer{
+		options:             options,
+		feedrate:            options.Feedrate / 60,
+		printAcceleration:   options.Acceleration,
+		travelAcceleration:  options.Acceleration,
+		retractAcceleration: options.Acceleration,
+	}
+}
+
+// Analyze reads G-code from r and analyzes it. Lines which cannot be parsed are skipped
+// and reported in Analysis.Errors. Analyze returns an error only if reading fails.
+func Analyze(r io.Reader, options AnalyzerOptions) (Analysis, error) {
+	a := NewAnalyzer(options)
+	br := bufio.NewReader(r)
+	for {
+		s, err := br.ReadString('\n')
+		if s != "" || err == nil {
+			l, parseErr := ParseLine(strings.TrimRight(s, "\r\n"))
+			var syntaxError *SyntaxError
+			if errors.As(parseErr, &syntaxError) {
+				a.Skip(syntaxError)
+			} else if parseErr != nil {
+				return Analysis{}, parseErr
+			} else {
+				a.Process(l)
+			}
+		}
+		if err == io.EOF {
+			return a.Analysis(), nil
+		} else if err != nil {
+			return Analysis{}, err
+		}
+	}
+}
+
+// Analysis returns the result of the lines processed so far.
+func (a *Analyzer) Analysis() Analysis {
+	result := Analysis{
+		Lines:     a.lines,
+		Time:      seconds(a.time),
+		Bounds:    a.bounds,
+		HasBounds: a.hasBounds,
+		Layers:    append([]Layer(nil), a.layers...),
+		Extruders: make([]Extrusion, len(a.extruded)),
+		Errors:    append([]*SyntaxError(nil), a.errors...),
+	}
+	area := math.Pi * a.options.FilamentDiameter * a.options.FilamentDiameter / 4
+	for i, length := range a.extruded {
+		volume := length * area
+		result.Extruders[i] = Extrusion{
+			Length: length,
+			Volume: volume,
+			Weight: volume / 1000 * a.options.FilamentDensity,
+		}
+	}
+	return result
+}
+
+// Skip counts a line which could not be parsed, and records the error with the number of
+// the line.
+func (a *Analyzer) Skip(err *SyntaxError) {
+	a.lines++
+	err.Line = a.lines
+	a.errors = append(a.errors, err)
+}
+
+// Process simulates a line of G-code. Commands which do not affect the analysis are
+// ignored.
+func (a *Analyzer) Process(l Line) {
+	a.lines++
+
+	if l.Command.Letter == 'T' {
+		if tool, ok := l.Command.Int(); ok && tool >= 0 {
+			a.tool = tool
+		}
+		return
+	}
+
+	switch l.Code() {
+	case "G0", "G1":
+		a.move(l)
+	case "G2":
+		a.arc(l, true)
+	case "G3":
+		a.arc(l, false)
+	case "G4":
+		if ms, ok := l.Float('P'); ok {
+			a.time += ms / 1000
+		}
+		if s, ok := l.Float('S'); ok {
+			a.time += s
+		}
+	case "G20":
+		a.inches = true
+	case "G21":
+		a.inches = false
+	case "G28":
+		all := !l.Has('X') && !l.Has('Y') && !l.Has('Z')
+		for axis := axisX; axis <= axisZ; axis++ {
+			if all || l.Has(axisLetters[axis]) {
+				a.position[axis] = 0
+			}
+		}
+	case "G90":
+		a.relative, a.relativeE = false, false
+	case "G91":
+		a.relative, a.relativeE = true, true
+	case "G92":
+		all := true
+		for axis := range a.position {
+			if v, ok := l.Float(axisLetters[axis]); ok {
+				a.position[axis] = a.length(v)
+				all = false
+			}
+		}
+		if all {
+			a.position = [axisCount]float64{}
+		}
+	case "M82":
+		a.relativeE = false
+	case "M83":
+		a.relativeE = true
+	case "M201":
+		for axis := range a.maxAcceleration {
+			if v, ok := l.Float(axisLetters[axis]); ok && v >= 0 {
+				a.maxAcceleration[axis] = v
+			}
+		}
+	case "M204":
+		if v, ok := l.Float('S'); ok && v > 0 {
+			a.printAcceleration, a.travelAcceleration = v, v
+		}
+		if v, ok := l.Float('P'); ok && v > 0 {
+			a.printAcceleration = v
+		}
+		if v, ok := l.Float('T'); ok && v > 0 {
+			a.travelAcceleration = v
+		}
+		if v, ok := l.Float('R'); ok && v > 0 {
+			a.retractAcceleration = v
+		}
+	}
+}
+
+// target returns the position after a move to the parameters of the line.
+func (a *Analyzer) target(l Line) [axisCount]float64 {
+	target := a.position
+	for axis := range target {
+		v, ok := l.Float(axisLetters[axis])
+		if !ok {
+			continue
+		}
+		v = a.length(v)
+		if axis == axisE && a.relativeE || axis != axisE && a.relative {
+			target[axis] += v
+		} else {
+			target[axis] = v
+		}
+	}
+	if f, ok := l.Float('F'); ok && f > 0 {
+		a.feedrate = a.length(f) / 60
+	}
+	return target
+}
+
+func (a *Analyzer) move(l Line) {
+	to := a.target(l)
+	dx, dy, dz := to[axisX]-a.position[axisX], to[axisY]-a.position[axisY], to[axisZ]-a.position[axisZ]
+	a.segment(to, math.Sqrt(dx*dx+dy*dy+dz*dz), nil)
+}
+
+// arc simulates G2 and G3, given either the offset of the center with I and J, or the
+// radius with R.
+func (a *Analyzer) arc(l Line, clockwise bool) {
+	from := a.position
+	to := a.target(l)
+
+	var cx, cy float64
+	if r, ok := l.Float('R'); ok {
+		// the center is on the perpendicular bisector of the chord; a negative radius
+		// selects the longer of the two possible arcs
+		r = a.length(r)
+		dx, dy := to[axisX]-from[axisX], to[axisY]-from[axisY]
+		d := math.Hypot(dx, dy)
+		if d == 0 {
+			a.move(l)
+			return
+		}
+		h := math.Sqrt(math.Max(0, r*r-d*d/4))
+		if clockwise != (r < 0) {
+			h = -h
+		}
+		cx = from[axisX] + dx/2 - h*dy/d
+		cy = from[axisY] + dy/2 + h*dx/d
+	} else {
+		i, _ := l.Float('I')
+		j, _ := l.Float('J')
+		cx, cy = from[axisX]+a.length(i), from[axisY]+a.length(j)
+	}
+
+	radius := math.Hypot(from[axisX]-cx, from[axisY]-cy)
+	start := math.Atan2(from[axisY]-cy, from[axisX]-cx)
+	end := math.Atan2(to[axisY]-cy, to[axisX]-cx)
+	sweep := end - start
+	if clockwise {
+		sweep = -sweep
+	}
+	// equal start and end make a full circle
+	for sweep <= 1e-9 {
+		sweep += 2 * math.Pi
+	}
+
+	// the extremes of the circle that lie on the arc extend the bounds
+	var extremes []Point
+	for k := 0; k < 4; k++ {
+		angle := float64(k) * math.Pi / 2
+		offset := angle - start
+		if clockwise {
+			offset = -offset
+		}
+		offset = math.Mod(offset+4*math.Pi, 2*math.Pi)
+		if offset <= sweep {
+			extremes = append(extremes, Point{
+				X: cx + radius*math.Cos(angle),
+				Y: cy + radius*math.Sin(angle),
+				Z: from[axisZ] + (to[axisZ]-from[axisZ])*offset/sweep,
+			})
+		}
+	}
+
+	a.segment(to, math.Hypot(radius*sweep, to[axisZ]-from[axisZ]), extremes)
+}
+
+// segment moves to the position along a path of the given length in mm. The extremes are
+// points on a curved path which extend the bounds.
+func (a *Analyzer) segment(to [axisCount]float64, length float64, extremes []Point) {
+	from := a.position
+	a.position = to
+
+	var delta [axisCount]float64
+	for axis := range delta {
+		delta[axis] = to[axis] - from[axis]
+	}
+	extruded := delta[axisE]
+
+	// moves of the extruder only, e.g. retractions, run at their own acceleration
+	acceleration := a.travelAcceleration
+	moved := length > 0
+	switch {
+	case !moved:
+		length = math.Abs(extruded)
+		acceleration = a.retractAcceleration
+	case extruded > 0:
+		acceleration = a.printAcceleration
+	}
+	if length == 0 {
+		return
+	}
+	for axis, limit := range a.maxAcceleration {
+		if limit > 0 && delta[axis] != 0 {
+			acceleration = math.Min(acceleration, limit*length/math.Abs(delta[axis]))
+		}
+	}
+
+	if extruded != 0 {
+		for len(a.extruded) <= a.tool {
+			a.extruded = append(a.extruded, 0)
+		}
+		a.extruded[a.tool] += extruded
+	}
+	if extruded > 0 && moved {
+		a.extrude(from, to, extremes)
+	}
+
+	a.time += moveTime(length, a.feedrate, acceleration)
+}
+
+// extrude updates the bounds and the layers with an extruding move.
+func (a *Analyzer) extrude(from, to [axisCount]float64, extremes []Point) {
+	a.include(Point{X: from[axisX], Y: from[axisY], Z: from[axisZ]})
+	a.include(Point{X: to[axisX], Y: to[axisY], Z: to[axisZ]})
+	for _, p := range extremes {
+		a.include(p)
+	}
+
+	z := to[axisZ]
+	previous := 0.0
+	if n := len(a.layers); n > 0 {
+		previous = a.layers[n-1].Z
+		if math.Abs(z-previous) < 1e-6 {
+			return
+		}
+	}
+	a.layers = append(a.layers, Layer{Z: z, Height: z - previous, Line: a.lines, Start: seconds(a.time)})
+}
+
+func (a *Analyzer) include(p Point) {
+	if !a.hasBounds {
+		a.bounds = Bounds{Min: p, Max: p}
+		a.hasBounds = true
+		return
+	}
+	a.bounds.Min = Point{X: math.Min(a.bounds.Min.X, p.X), Y: math.Min(a.bounds.Min.Y, p.Y), Z: math.Min(a.bounds.Min.Z, p.Z)}
+	a.bounds.Max = Point{X: math.Max(a.bounds.Max.X, p.X), Y: math.Max(a.bounds.Max.Y, p.Y), Z: math.Max(a.bounds.Max.Z, p.Z)}
+}
+
+// length converts a length in the current units to mm.
+func (a *Analyzer) length(v float64) float64 {
+	if a.inches {
+		return v * 25.4
+	}
+	return v
+}
+
+// moveTime returns the time in seconds to move the length in mm, starting and ending at
+// rest. The speed follows a trapezoidal profile, or a triangular one if the move is too
+// short to reach the feedrate.
+func moveTime(length, feedrate, acceleration float64) float64 {
+	if acceleration <= 0 {
+		return length / feedrate
+	}
+	if length >= feedrate*feedrate/acceleration {
+		return length/feedrate + feedrate/acceleration
+	}
+	return 2 * math.Sqrt(length/acceleration)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,218 @@
+package gcode_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"reprapctl/pkg/gcode"
+	"strings"
+	"testing"
+)
+
+func analyze(t *testing.T, program string, options gcode.AnalyzerOptions) gcode.Analysis {
+	t.Helper()
+	a, err := gcode.Analyze(strings.NewReader(program), options)
+	require.NoError(t, err)
+	return a
+}
+
+func TestAnalyze_Time(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		want    float64
+	}{
+		{
+			name:    "Trapezoid",
+			program: "G1 X100 F6000",
+			want:    1.1, // 0.1 s accelerating and decelerating, 0.9 s at 100 mm/s
+		},
+		{
+			name:    "Triangle",
+			program: "G1 X4 F6000",
+			want:    2 * math.Sqrt(0.004),
+		},
+		{
+			name:    "Dwell",
+			program: "G4 P500\nG4 S1",
+			want:    1.5,
+		},
+		{
+			name:    "DefaultFeedrate",
+			program: "G1 X100\nG1 X200 F1500",
+			want:    2 * (4 + 0.025),
+		},
+		{
+			name:    "Acceleration",
+			program: "M204 S500\nG1 X100 F6000",
+			want:    1.2,
+		},
+		{
+			name:    "PrintAndTravelAcceleration",
+			program: "M204 P250 T500\nG1 X100 F6000\nG1 X200 E1",
+			want:    1.2 + 1.4,
+		},
+		{
+			name:    "RetractAcceleration",
+			program: "M204 R250\nG1 E-4 F6000",
+			want:    2 * math.Sqrt(4.0/250),
+		},
+		{
+			name:    "MaxAcceleration",
+			program: "M201 X250\nG1 X100 F6000\nG1 Y100",
+			want:    1.4 + 1.1,
+		},
+		{
+			name: "MaxAccelerationDiagonal",
+			// X moves 0.6 of the length, so the acceleration along the path is 250/0.6
+			program: "M201 X250\nG1 X60 Y80 F6000",
+			want:    1 + 100/(250/0.6),
+		},
+		{
+			name:    "NoMove",
+			program: "G1 X0 F6000\nG92 X100\nG1 X100",
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := analyze(t, tt.program, gcode.AnalyzerOptions{})
+			assert.InDelta(t, tt.want, a.Time.Seconds(), 1e-6)
+		})
+	}
+}
+
+func TestAnalyze_Filament(t *testing.T) {
+	const program = `G92 E0
+G1 X10 E5
+G1 E3 ; retract
+G1 E5
+G1 X20 E10
+G92 E0
+G1 X30 E2
+T1
+M83
+G1 X40 E4
+G1 X50 E-1
+G91
+G1 X10 E1
+G90
+G1 X0
+T0
+M82
+G92 E0
+G1 X10 E1`
+	a := analyze(t, program, gcode.AnalyzerOptions{FilamentDiameter: 2, FilamentDensity: 1.25})
+
+	require.Len(t, a.Extruders, 2)
+	assert.InDelta(t, 13, a.Extruders[0].Length, 1e-9)
+	assert.InDelta(t, 4, a.Extruders[1].Length, 1e-9)
+	assert.InDelta(t, 13*math.Pi, a.Extruders[0].Volume, 1e-9)
+	assert.InDelta(t, 13*math.Pi/1000*1.25, a.Extruders[0].Weight, 1e-9)
+	assert.InDelta(t, 17, a.Filament().Length, 1e-9)
+	assert.Equal(t, 19, a.Lines)
+}
+
+func TestAnalyze_BoundsAndLayers(t *testing.T) {
+	const program = `G28
+G1 Z5 F600 ; travel
+G1 X10 Y10 Z0.2
+G1 X20 Y10 E1
+G1 X20 Y30 E2
+G1 Z0.6 ; hop
+G1 Z0.4
+G1 X10 Y30 E3
+G1 X15 Y25 E4
+G1 Z10 X100 Y100`
+	a := analyze(t, program, gcode.AnalyzerOptions{})
+
+	require.True(t, a.HasBounds)
+	assert.Equal(t, gcode.Bounds{Min: gcode.Point{X: 10, Y: 10, Z: 0.2}, Max: gcode.Point{X: 20, Y: 30, Z: 0.4}}, a.Bounds)
+	assert.InDelta(t, 20, a.Bounds.Size().Y, 1e-9)
+
+	require.Len(t, a.Layers, 2)
+	assert.InDelta(t, 0.2, a.Layers[0].Z, 1e-9)
+	assert.InDelta(t, 0.2, a.Layers[0].Height, 1e-9)
+	assert.Equal(t, 4, a.Layers[0].Line)
+	assert.InDelta(t, 0.4, a.Layers[1].Z, 1e-9)
+	assert.InDelta(t, 0.2, a.Layers[1].Height, 1e-9)
+	assert.Equal(t, 8, a.Layers[1].Line)
+	assert.Greater(t, a.Layers[1].Start, a.Layers[0].Start)
+
+	empty := analyze(t, "G28\nG1 X10", gcode.AnalyzerOptions{})
+	assert.False(t, empty.HasBounds)
+	assert.Empty(t, empty.Layers)
+	assert.Empty(t, empty.Extruders)
+}
+
+func TestAnalyze_Arcs(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		bounds  gcode.Bounds
+		length  float64
+	}{
+		{
+			name:    "Clockwise",
+			program: "G2 X20 Y0 I10 J0 E1",
+			bounds:  gcode.Bounds{Max: gcode.Point{X: 20, Y: 10}},
+			length:  10 * math.Pi,
+		},
+		{
+			name:    "CounterClockwise",
+			program: "G3 X20 Y0 I10 J0 E1",
+			bounds:  gcode.Bounds{Min: gcode.Point{Y: -10}, Max: gcode.Point{X: 20}},
+			length:  10 * math.Pi,
+		},
+		{
+			name:    "Radius",
+			program: "G2 X10 Y10 R10 E1",
+			bounds:  gcode.Bounds{Max: gcode.Point{X: 10, Y: 10}},
+			length:  5 * math.Pi,
+		},
+		{
+			name:    "NegativeRadius",
+			program: "G2 X10 Y10 R-10 E1",
+			bounds:  gcode.Bounds{Min: gcode.Point{X: -10}, Max: gcode.Point{X: 10, Y: 20}},
+			length:  15 * math.Pi,
+		},
+		{
+			name:    "FullCircle",
+			program: "G3 X0 Y0 I10 J0 E1",
+			bounds:  gcode.Bounds{Min: gcode.Point{Y: -10}, Max: gcode.Point{X: 20, Y: 10}},
+			length:  20 * math.Pi,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// without acceleration limits, the time is the length at 1 mm/s
+			a := analyze(t, "G1 F60\n"+tt.program, gcode.AnalyzerOptions{Acceleration: math.Inf(1)})
+			require.True(t, a.HasBounds)
+			assert.InDelta(t, tt.bounds.Min.X, a.Bounds.Min.X, 1e-9)
+			assert.InDelta(t, tt.bounds.Min.Y, a.Bounds.Min.Y, 1e-9)
+			assert.InDelta(t, tt.bounds.Max.X, a.Bounds.Max.X, 1e-9)
+			assert.InDelta(t, tt.bounds.Max.Y, a.Bounds.Max.Y, 1e-9)
+			assert.InDelta(t, tt.length, a.Time.Seconds(), 1e-6)
+		})
+	}
+}
+
+func TestAnalyze_Units(t *testing.T) {
+	a := analyze(t, "G20\nG1 X1 Y2 E0.1\nG21\nG1 X10 E1", gcode.AnalyzerOptions{})
+	assert.InDelta(t, 25.4, a.Bounds.Max.X, 1e-9)
+	assert.InDelta(t, 50.8, a.Bounds.Max.Y, 1e-9)
+	assert.InDelta(t, 1, a.Extruders[0].Length, 1e-9)
+}
+
+func TestAnalyze_SyntaxError(t *testing.T) {
+	a, err := gcode.Analyze(strings.NewReader("G28\r\n\r\nG1 X10\nG1 10\nG1 X20 E1 (extrude)\n"), gcode.AnalyzerOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, a.Lines)
+	require.Len(t, a.Errors, 1)
+	assert.Equal(t, 4, a.Errors[0].Line)
+	assert.Equal(t, "gcode: line 4, column 4: unexpected '1', want a letter", a.Errors[0].Error())
+	require.True(t, a.HasBounds)
+	assert.InDelta(t, 20, a.Bounds.Max.X, 1e-9)
+}
@@ -0,0 +1,453 @@
+// Package gcode parses and serializes lines of G-code as understood by RepRap firmwares
+// such as Marlin and RepRapFirmware.
+//
+// A line consists of an optional line number, a command with parameters, an optional
+// checksum and an optional comment:
+//
+//	N12 G1 X10 Y10.5 E0.2*57 ; comment
+//
+// Comments can also be enclosed in parentheses between words, as in G1 X10 (move) Y10.
+// They are skipped by the parser and not kept in the Line.
+//
+// Parameters are words made of a letter and a value. Values are usually numbers, but
+// can also be quoted strings as in RepRapFirmware's M98 P"config.g". A few commands,
+// such as M23 and M117, take the rest of the line as a single string argument.
+//
+// Analyzer estimates the print time and the filament used by a G-code file.
+package gcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Word is a letter followed by a value, e.g. "X10.5", "S" or `P"file name.g"`.
+type Word struct {
+	// Letter is the upper case letter of the word.
+	Letter byte
+
+	// Value is the text of the value as written, without quotes. It is empty for flags
+	// such as the X in "G28 X".
+	Value string
+
+	// Quoted is set if the value is a quoted string.
+	Quoted bool
+}
+
+// Float returns the value of the word as a number.
+func (w Word) Float() (float64, bool) {
+	if w.Quoted {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(w.Value, 64)
+	return f, err == nil
+}
+
+// Int returns the value of the word as an integer. Numbers with a fractional part
+// are not integers.
+func (w Word) Int() (int, bool) {
+	if w.Quoted {
+		return 0, false
+	}
+	i, err := strconv.Atoi(w.Value)
+	return i, err == nil
+}
+
+// String returns the word as written in G-code.
+func (w Word) String() string {
+	if w.Quoted {
+		return string(w.Letter) + quote(w.Value)
+	}
+	return string(w.Letter) + w.Value
+}
+
+// Line is a parsed line of G-code. The zero Line is an empty line.
+type Line struct {
+	// Number is the line number given with the N word. It is valid only if HasNumber is set.
+	Number    int
+	HasNumber bool
+
+	// Command is the command word, e.g. G1, M104 or T0. The Letter is zero if the line has
+	// no command.
+	Command Word
+
+	// Params are the words following the command.
+	Params []Word
+
+	// Text is the string argument of commands like M23 and M117, which take the rest of
+	// the line as a single argument. TextQuoted is set if the argument is a quoted string.
+	Text       string
+	TextQuoted bool
+
+	// Checksum is the checksum following the asterisk. It is valid only if HasChecksum
+	// is set.
+	Checksum    byte
+	HasChecksum bool
+
+	// Comment is the text following the semicolon, including any leading space.
+	// HasComment distinguishes an empty comment from no comment.
+	Comment    string
+	HasComment bool
+}
+
+// textCommands take the rest of the line as a single string argument.
+var textCommands = map[string]bool{
+	"M23":  true, // select SD file
+	"M28":  true, // start SD write
+	"M30":  true, // delete SD file
+	"M32":  true, // select and start SD file
+	"M33":  true, // get long name
+	"M117": true, // display message
+	"M118": true, // serial print
+	"M928": true, // start SD logging
+}
+
+// SyntaxError describes a line that cannot be parsed.
+type SyntaxError struct {
+	// Line is the 1-based number of the line in a file, or zero if not known.
+	Line int
+
+	// Column is the byte offset in the line where the error was found.
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("gcode: line %d, column %d: %s", e.Line, e.Column+1, e.Msg)
+	}
+	return fmt.Sprintf("gcode: column %d: %s", e.Column+1, e.Msg)
+}
+
+// ParseLine parses a single line of G-code. The line must not contain EOL characters.
+// Letters of words are converted to upper case.
+func ParseLine(s string) (Line, error) {
+	p := parser{s: s}
+	return p.parse()
+}
+
+// Code returns the normalized code of the command, e.g. "G1" for "G01" and "G29.1" for
+// "g29.1". Code returns an empty string if the line has no command.
+func (l Line) Code() string {
+	if l.Command.Letter == 0 {
+		return ""
+	}
+	return string(l.Command.Letter) + normalizeNumber(l.Command.Value)
+}
+
+// Is reports whether the line's command has the given code, e.g. "G1" or "M104".
+func (l Line) Is(code string) bool {
+	if len(code) == 0 || l.Command.Letter == 0 {
+		return false
+	}
+	return l.Command.Letter == upper(code[0]) && normalizeNumber(l.Command.Value) == normalizeNumber(code[1:])
+}
+
+// Param returns the first parameter with the given letter.
+func (l Line) Param(letter byte) (Word, bool) {
+	letter = upper(letter)
+	for _, w := range l.Params {
+		if w.Letter == letter {
+			return w, true
+		}
+	}
+	return Word{}, false
+}
+
+// Has reports whether the line has a parameter with the given letter.
+func (l Line) Has(letter byte) bool {
+	_, ok := l.Param(letter)
+	return ok
+}
+
+// Float returns the numeric value of the first parameter with the given letter.
+func (l Line) Float(letter byte) (float64, bool) {
+	w, ok := l.Param(letter)
+	if !ok {
+		return 0, false
+	}
+	return w.Float()
+}
+
+// IsEmpty reports whether the line has no command, i.e. it is blank or only a comment.
+func (l Line) IsEmpty() bool {
+	return l.Command.Letter == 0 && !l.HasNumber
+}
+
+// String serializes the line. Words are separated by single spaces, and the comment,
+// if any, is separated from the rest of the line by a space. The Checksum is written
+// as is; use SetChecksum to update it.
+func (l Line) String() string {
+	var b strings.Builder
+	b.WriteString(l.body())
+	if l.HasChecksum {
+		b.WriteByte('*')
+		b.WriteString(strconv.Itoa(int(l.Checksum)))
+	}
+	if l.HasComment {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte(';')
+		b.WriteString(l.Comment)
+	}
+	return b.String()
+}
+
+// SetChecksum calculates the checksum of the line as serialized by String.
+func (l *Line) SetChecksum() {
+	l.Checksum = Checksum(l.body())
+	l.HasChecksum = true
+}
+
+// VerifyChecksum reports whether the line has a checksum matching its content as
+// serialized by String. Lines written in a different form, e.g. without spaces between
+// words, must be verified with Checksum applied to the original text.
+func (l Line) VerifyChecksum() bool {
+	return l.HasChecksum && l.Checksum == Checksum(l.body())
+}
+
+// Checksum calculates the RepRap checksum, which is XOR of all bytes in s.
+func Checksum(s string) byte {
+	var c byte
+	for i := 0; i < len(s); i++ {
+		c ^= s[i]
+	}
+	return c
+}
+
+// body serializes the line without the checksum and comment.
+func (l Line) body() string {
+	var parts []string
+	if l.HasNumber {
+		parts = append(parts, "N"+strconv.Itoa(l.Number))
+	}
+	if l.Command.Letter != 0 {
+		parts = append(parts, l.Command.String())
+	}
+	for _, w := range l.Params {
+		parts = append(parts, w.String())
+	}
+	if l.TextQuoted {
+		parts = append(parts, quote(l.Text))
+	} else if l.Text != "" {
+		parts = append(parts, l.Text)
+	}
+	return strings.Join(parts, " ")
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) parse() (Line, error) {
+	var l Line
+
+	// the comment ends the line, unless the semicolon is in a quoted string
+	end := len(p.s)
+	if i, err := p.indexOutsideQuotes(';', false); err != nil {
+		return Line{}, err
+	} else if i >= 0 {
+		l.Comment, l.HasComment = p.s[i+1:], true
+		end = i
+	}
+	// like Marlin, take the last asterisk so that it can appear in text arguments
+	p.s = p.s[:end]
+	if i, err := p.indexOutsideQuotes('*', true); err != nil {
+		return Line{}, err
+	} else if i >= 0 {
+		digits := strings.TrimSpace(p.s[i+1 : end])
+		c, err := strconv.ParseUint(digits, 10, 8)
+		if err != nil {
+			return Line{}, &SyntaxError{Column: i, Msg: fmt.Sprintf("invalid checksum %q", digits)}
+		}
+		l.Checksum, l.HasChecksum = byte(c), true
+		end = i
+	}
+	p.s = p.s[:end]
+
+	for {
+		p.skipSpace()
+		if p.pos == len(p.s) {
+			break
+		}
+		if p.s[p.pos] == '(' {
+			if err := p.skipComment(); err != nil {
+				return Line{}, err
+			}
+			continue
+		}
+
+		start := p.pos
+		w, err := p.word()
+		if err != nil {
+			return Line{}, err
+		}
+
+		switch {
+		case w.Letter == 'N' && !l.HasNumber && l.Command.Letter == 0 && len(l.Params) == 0:
+			n, ok := w.Int()
+			if !ok {
+				return Line{}, &SyntaxError{Column: start, Msg: fmt.Sprintf("invalid line number %q", w.Value)}
+			}
+			l.Number, l.HasNumber = n, true
+		case l.Command.Letter == 0 && len(l.Params) == 0:
+			l.Command = w
+			if textCommands[l.Code()] {
+				if err := p.text(&l); err != nil {
+					return Line{}, err
+				}
+			}
+		default:
+			l.Params = append(l.Params, w)
+		}
+	}
+
+	return l, nil
+}
+
+// word parses a letter and a value.
+func (p *parser) word() (Word, error) {
+	c := p.s[p.pos]
+	if !isLetter(c) {
+		return Word{}, &SyntaxError{Column: p.pos, Msg: fmt.Sprintf("unexpected %q, want a letter", c)}
+	}
+	w := Word{Letter: upper(c)}
+	p.pos++
+
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		s, err := p.quoted()
+		if err != nil {
+			return Word{}, err
+		}
+		w.Value, w.Quoted = s, true
+		return w, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && !isSpace(p.s[p.pos]) && !isLetter(p.s[p.pos]) && p.s[p.pos] != '(' {
+		if p.s[p.pos] == '"' {
+			return Word{}, &SyntaxError{Column: p.pos, Msg: "unexpected quote"}
+		}
+		p.pos++
+	}
+	w.Value = p.s[start:p.pos]
+	return w, nil
+}
+
+// text parses the string argument of a text command.
+func (p *parser) text(l *Line) error {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		s, err := p.quoted()
+		if err != nil {
+			return err
+		}
+		l.Text, l.TextQuoted = s, true
+		p.skipSpace()
+		if p.pos < len(p.s) {
+			return &SyntaxError{Column: p.pos, Msg: "unexpected text after the quoted string"}
+		}
+		return nil
+	}
+	l.Text = strings.TrimRight(p.s[p.pos:], " \t")
+	p.pos = len(p.s)
+	return nil
+}
+
+// skipComment skips a comment in parentheses.
+func (p *parser) skipComment() error {
+	i := strings.IndexByte(p.s[p.pos:], ')')
+	if i == -1 {
+		return &SyntaxError{Column: p.pos, Msg: "unterminated comment"}
+	}
+	p.pos += i + 1
+	return nil
+}
+
+// quoted parses a quoted string. Double quotes within the string are escaped by doubling
+// them.
+func (p *parser) quoted() (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		if c != '"' {
+			b.WriteByte(c)
+		} else if p.pos < len(p.s) && p.s[p.pos] == '"' {
+			b.WriteByte('"')
+			p.pos++
+		} else {
+			return b.String(), nil
+		}
+	}
+	return "", &SyntaxError{Column: start, Msg: "unterminated quoted string"}
+}
+
+// indexOutsideQuotes finds the first, or the last, c which is not inside a quoted string.
+func (p *parser) indexOutsideQuotes(c byte, last bool) (int, error) {
+	index := -1
+	inQuotes, quoteStart := false, 0
+	for i := 0; i < len(p.s); i++ {
+		switch {
+		case p.s[i] == '"':
+			if !inQuotes {
+				quoteStart = i
+			}
+			inQuotes = !inQuotes
+		case p.s[i] == c && !inQuotes:
+			index = i
+			if !last {
+				return index, nil
+			}
+		}
+	}
+	if inQuotes {
+		return -1, &SyntaxError{Column: quoteStart, Msg: "unterminated quoted string"}
+	}
+	return index, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// normalizeNumber removes leading zeros of the integer part and trailing zeros of the
+// fractional part of a number.
+func normalizeNumber(s string) string {
+	whole, fraction, _ := strings.Cut(s, ".")
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
+
+func isLetter(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func upper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
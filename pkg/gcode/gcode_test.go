@@ -0,0 +1,272 @@
+package gcode_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"reprapctl/pkg/gcode"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want gcode.Line
+	}{
+		{
+			name: "Empty",
+			line: "",
+			want: gcode.Line{},
+		},
+		{
+			name: "Blank",
+			line: " \t ",
+			want: gcode.Line{},
+		},
+		{
+			name: "Comment",
+			line: "; generated by PrusaSlicer",
+			want: gcode.Line{Comment: " generated by PrusaSlicer", HasComment: true},
+		},
+		{
+			name: "Move",
+			line: "G1 X10 Y-10.5 E.25 F3000",
+			want: gcode.Line{
+				Command: gcode.Word{Letter: 'G', Value: "1"},
+				Params: []gcode.Word{
+					{Letter: 'X', Value: "10"},
+					{Letter: 'Y', Value: "-10.5"},
+					{Letter: 'E', Value: ".25"},
+					{Letter: 'F', Value: "3000"},
+				},
+			},
+		},
+		{
+			name: "NoSpaces",
+			line: "g1x10y20",
+			want: gcode.Line{
+				Command: gcode.Word{Letter: 'G', Value: "1"},
+				Params:  []gcode.Word{{Letter: 'X', Value: "10"}, {Letter: 'Y', Value: "20"}},
+			},
+		},
+		{
+			name: "Flags",
+			line: "G28 X Y",
+			want: gcode.Line{
+				Command: gcode.Word{Letter: 'G', Value: "28"},
+				Params:  []gcode.Word{{Letter: 'X'}, {Letter: 'Y'}},
+			},
+		},
+		{
+			name: "LineNumberAndChecksum",
+			line: "N12 G1 X10 Y10*26",
+			want: gcode.Line{
+				Number:      12,
+				HasNumber:   true,
+				Command:     gcode.Word{Letter: 'G', Value: "1"},
+				Params:      []gcode.Word{{Letter: 'X', Value: "10"}, {Letter: 'Y', Value: "10"}},
+				Checksum:    26,
+				HasChecksum: true,
+			},
+		},
+		{
+			name: "ParenthesizedComment",
+			line: "G1 X1(move) (to the right)Y2",
+			want: gcode.Line{
+				Command: gcode.Word{Letter: 'G', Value: "1"},
+				Params:  []gcode.Word{{Letter: 'X', Value: "1"}, {Letter: 'Y', Value: "2"}},
+			},
+		},
+		{
+			name: "TrailingComment",
+			line: "M104 S210 ; hotend",
+			want: gcode.Line{
+				Command:    gcode.Word{Letter: 'M', Value: "104"},
+				Params:     []gcode.Word{{Letter: 'S', Value: "210"}},
+				Comment:    " hotend",
+				HasComment: true,
+			},
+		},
+		{
+			name: "Subcode",
+			line: "G29.1",
+			want: gcode.Line{Command: gcode.Word{Letter: 'G', Value: "29.1"}},
+		},
+		{
+			name: "Tool",
+			line: "T1",
+			want: gcode.Line{Command: gcode.Word{Letter: 'T', Value: "1"}},
+		},
+		{
+			name: "TextArgument",
+			line: "M117 Printing 50%  done",
+			want: gcode.Line{Command: gcode.Word{Letter: 'M', Value: "117"}, Text: "Printing 50%  done"},
+		},
+		{
+			name: "TextArgumentWithAsterisk",
+			line: "N3 M23 /a*b.gco*86",
+			want: gcode.Line{
+				Number:      3,
+				HasNumber:   true,
+				Command:     gcode.Word{Letter: 'M', Value: "23"},
+				Text:        "/a*b.gco",
+				Checksum:    86,
+				HasChecksum: true,
+			},
+		},
+		{
+			name: "QuotedTextArgument",
+			line: `M28 "big ""box"".gcode"`,
+			want: gcode.Line{
+				Command:    gcode.Word{Letter: 'M', Value: "28"},
+				Text:       `big "box".gcode`,
+				TextQuoted: true,
+			},
+		},
+		{
+			name: "QuotedParameter",
+			line: `M98 P"macros/park; now.g" S1 ; run`,
+			want: gcode.Line{
+				Command: gcode.Word{Letter: 'M', Value: "98"},
+				Params: []gcode.Word{
+					{Letter: 'P', Value: "macros/park; now.g", Quoted: true},
+					{Letter: 'S', Value: "1"},
+				},
+				Comment:    " run",
+				HasComment: true,
+			},
+		},
+		{
+			name: "EmptyComment",
+			line: "G90;",
+			want: gcode.Line{Command: gcode.Word{Letter: 'G', Value: "90"}, HasComment: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gcode.ParseLine(tt.line)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseLine_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		column int
+	}{
+		{name: "NoLetter", line: "G1 10", column: 3},
+		{name: "InvalidLineNumber", line: "N1.5 G28", column: 0},
+		{name: "InvalidChecksum", line: "N1 G28*x", column: 6},
+		{name: "ChecksumOutOfRange", line: "N1 G28*256", column: 6},
+		{name: "UnterminatedQuote", line: `M98 P"foo`, column: 5},
+		{name: "QuoteInValue", line: `G1 X1"`, column: 5},
+		{name: "TextAfterQuotedText", line: `M23 "foo" bar`, column: 10},
+		{name: "UnterminatedComment", line: "G1 X1 (move", column: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gcode.ParseLine(tt.line)
+			var syntaxError *gcode.SyntaxError
+			require.ErrorAs(t, err, &syntaxError)
+			assert.Equal(t, tt.column, syntaxError.Column)
+		})
+	}
+}
+
+func TestLine_RoundTrip(t *testing.T) {
+	// canonical lines serialize to the same text
+	lines := []string{
+		"",
+		";comment",
+		"; comment with \"quotes\"",
+		"G28",
+		"G28 X Y",
+		"G1 X10.500 Y-3 E0.02 F1800",
+		"N12 G1 X10 Y10*26",
+		"N0 M110 N0*125",
+		"M104 S210 ; set temperature",
+		"M117 Hello, World!",
+		`M23 "file ""name"".gcode"`,
+		`M98 P"config.g"`,
+		"T0",
+		"G29.1 ;",
+	}
+	for _, s := range lines {
+		l, err := gcode.ParseLine(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, s, l.String())
+	}
+
+	// other lines serialize in the canonical form, which parses to the same line
+	nonCanonical := []string{
+		"g1x10y10",
+		"  G1   X1 ;comment",
+		"N5 M105 * 7",
+		"M117    text   ",
+	}
+	for _, s := range nonCanonical {
+		l, err := gcode.ParseLine(s)
+		require.NoError(t, err, s)
+		again, err := gcode.ParseLine(l.String())
+		require.NoError(t, err, l.String())
+		assert.Equal(t, l, again, s)
+	}
+}
+
+func TestLine_Checksum(t *testing.T) {
+	l, err := gcode.ParseLine("N12 G1 X10 Y10*26")
+	require.NoError(t, err)
+	assert.True(t, l.VerifyChecksum())
+
+	l.Params[0].Value = "20"
+	assert.False(t, l.VerifyChecksum())
+	l.SetChecksum()
+	assert.True(t, l.VerifyChecksum())
+	assert.Equal(t, "N12 G1 X20 Y10*25", l.String())
+
+	l, err = gcode.ParseLine("G1 X10")
+	require.NoError(t, err)
+	assert.False(t, l.VerifyChecksum())
+	assert.Equal(t, byte(125), gcode.Checksum("N0 M110 N0"))
+}
+
+func TestLine_Accessors(t *testing.T) {
+	l, err := gcode.ParseLine("G01 X10.5 S200 P\"x\"")
+	require.NoError(t, err)
+
+	assert.Equal(t, "G1", l.Code())
+	assert.True(t, l.Is("G1"))
+	assert.True(t, l.Is("g001"))
+	assert.False(t, l.Is("G10"))
+	assert.False(t, l.Is("M1"))
+
+	x, ok := l.Float('x')
+	assert.True(t, ok)
+	assert.Equal(t, 10.5, x)
+
+	s, _ := l.Param('S')
+	i, ok := s.Int()
+	assert.True(t, ok)
+	assert.Equal(t, 200, i)
+
+	p, _ := l.Param('P')
+	_, ok = p.Float()
+	assert.False(t, ok)
+
+	assert.True(t, l.Has('X'))
+	assert.False(t, l.Has('Y'))
+	assert.False(t, l.IsEmpty())
+
+	empty, _ := gcode.ParseLine("; nothing")
+	assert.True(t, empty.IsEmpty())
+	assert.Equal(t, "", empty.Code())
+	assert.False(t, empty.Is("G1"))
+
+	sub, _ := gcode.ParseLine("G29.10")
+	assert.Equal(t, "G29.1", sub.Code())
+}